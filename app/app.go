@@ -1,15 +1,21 @@
 package app
 
 import (
+	"context"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 	"users-api/app/appdata"
 	"users-api/app/models"
 	"users-api/app/routes"
+	"users-api/app/utils"
 	_ "users-api/docs"
 
 	jwtware "github.com/gofiber/contrib/jwt"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/gofiber/fiber/v2"
 	fiberlogger "github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
@@ -57,15 +63,152 @@ func (app *App) InitializeApp() {
 	appdata.SmtpPort = uint(envSmtpPort)
 	appdata.SmtpUsername = os.Getenv("SMTP_FROM")
 	appdata.LogRequests = os.Getenv("LOG_REQUESTS") == "true"
+	appdata.NotifyTokenReuse = os.Getenv("NOTIFY_TOKEN_REUSE") == "true"
+	appdata.TotpIssuer = os.Getenv("TOTP_ISSUER")
+	if appdata.TotpIssuer == "" {
+		appdata.TotpIssuer = "VerseQuick"
+	}
 	if appdata.SmtpPort == 0 || appdata.SmtpServer == "" || appdata.SmtpPassword == "" || appdata.SmtpUsername == "" {
 		log.Fatal("Failed to load environment variables for SMTP settings.")
 	}
-	jwtSecretString := os.Getenv("JWT_SECRET")
-	if jwtSecretString == "" {
-		log.Fatal("Failed to load JWT_SECRET from .env")
+	appdata.JwtKeysDir = os.Getenv("JWT_KEYS_DIR")
+	if appdata.JwtKeysDir == "" {
+		log.Fatal("Failed to load JWT_KEYS_DIR from .env")
+	}
+	appdata.JwtSigningAlg = os.Getenv("JWT_SIGNING_ALG")
+	if appdata.JwtSigningAlg == "" {
+		appdata.JwtSigningAlg = "RS256"
 	}
-	appdata.JwtSecret = []byte(jwtSecretString)
+	appdata.JwtKeyRotationMinutes = getExpiryMinutes("JWT_KEY_ROTATION_MINUTES")
 	appdata.ResetValidMinutes = getExpiryMinutes("RESET_VALID_MINUTES")
+	appdata.MagicLinkValidMinutes = getExpiryMinutes("MAGIC_LINK_VALID_MINUTES")
+	appdata.AccountDeletionGraceMinutes = getExpiryMinutes("ACCOUNT_DELETION_GRACE_MINUTES")
+	appdata.DataExportValidMinutes = getExpiryMinutes("DATA_EXPORT_VALID_MINUTES")
+	appdata.DataExportDir = os.Getenv("DATA_EXPORT_DIR")
+	if appdata.DataExportDir == "" {
+		appdata.DataExportDir = filepath.Join(os.TempDir(), "users-api-exports")
+	}
+	if entropy := os.Getenv("TOKEN_ENTROPY_BYTES"); entropy != "" {
+		entropyBytes, err := strconv.ParseUint(entropy, 10, 32)
+		if err != nil || entropyBytes < 16 {
+			log.Fatal("TOKEN_ENTROPY_BYTES must be a number of at least 16")
+		}
+		appdata.TokenEntropyBytes = uint(entropyBytes)
+	}
+	if wpm := os.Getenv("READING_WORDS_PER_MINUTE"); wpm != "" {
+		wordsPerMinute, err := strconv.ParseUint(wpm, 10, 32)
+		if err != nil || wordsPerMinute == 0 {
+			log.Fatal("READING_WORDS_PER_MINUTE must be a positive number")
+		}
+		appdata.ReadingWordsPerMinute = uint(wordsPerMinute)
+	}
+
+	var err error
+	// Existing hashes are upgraded on login when these are raised
+	argon2Params := *utils.PasswordParams
+	if memory := os.Getenv("ARGON2_MEMORY_KIB"); memory != "" {
+		value, err := strconv.ParseUint(memory, 10, 32)
+		if err != nil || value < 19*1024 {
+			log.Fatal("ARGON2_MEMORY_KIB must be a number of at least 19456")
+		}
+		argon2Params.Memory = uint32(value)
+	}
+	if iterations := os.Getenv("ARGON2_ITERATIONS"); iterations != "" {
+		value, err := strconv.ParseUint(iterations, 10, 32)
+		if err != nil || value < 1 {
+			log.Fatal("ARGON2_ITERATIONS must be a number of at least 1")
+		}
+		argon2Params.Iterations = uint32(value)
+	}
+	if parallelism := os.Getenv("ARGON2_PARALLELISM"); parallelism != "" {
+		value, err := strconv.ParseUint(parallelism, 10, 8)
+		if err != nil || value < 1 {
+			log.Fatal("ARGON2_PARALLELISM must be a number from 1 to 255")
+		}
+		argon2Params.Parallelism = uint8(value)
+	}
+	utils.PasswordParams = &argon2Params
+
+	passwordMinLength, passwordMinScore := 10, 2
+	if minLength := os.Getenv("PASSWORD_MIN_LENGTH"); minLength != "" {
+		if passwordMinLength, err = strconv.Atoi(minLength); err != nil || passwordMinLength < 8 {
+			log.Fatal("PASSWORD_MIN_LENGTH must be a number of at least 8")
+		}
+	}
+	if minScore := os.Getenv("PASSWORD_MIN_SCORE"); minScore != "" {
+		if passwordMinScore, err = strconv.Atoi(minScore); err != nil || passwordMinScore < 0 || passwordMinScore > 4 {
+			log.Fatal("PASSWORD_MIN_SCORE must be a number from 0 to 4")
+		}
+	}
+	utils.Passwords, err = utils.NewPasswordPolicy(passwordMinLength, passwordMinScore, os.Getenv("PASSWORD_BANNED_LIST"))
+	if err != nil {
+		log.Fatal("Failed to load PASSWORD_BANNED_LIST: ", err)
+	}
+	if breachesDir := os.Getenv("BREACHED_PASSWORDS_DIR"); breachesDir != "" {
+		if utils.Passwords.Breaches, err = utils.LoadBreachedPasswords(breachesDir); err != nil {
+			log.Fatal("Failed to load BREACHED_PASSWORDS_DIR: ", err)
+		}
+	}
+
+	// Superseded keys verify for as long as the longest lived token signed with them
+	longestJwtExpiry := time.Duration(max(appdata.JwtExpiryMinutes, appdata.JwtExpiryNoRemember, appdata.DataExportValidMinutes)) * time.Minute
+	keys, err := utils.LoadKeyRing(appdata.JwtKeysDir, appdata.JwtSigningAlg, time.Duration(appdata.JwtKeyRotationMinutes)*time.Minute, longestJwtExpiry)
+	if err != nil {
+		log.Fatal("Failed to load JWT signing keys: ", err)
+	}
+	utils.Keys = keys
+	utils.Keys.StartRotation(time.Hour)
+
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		log.Fatal("Failed to load WEBAUTHN_RP_ID from .env")
+	}
+	rpName := os.Getenv("WEBAUTHN_RP_NAME")
+	if rpName == "" {
+		rpName = "VerseQuick"
+	}
+	var rpOrigins []string
+	for _, origin := range strings.Split(os.Getenv("WEBAUTHN_RP_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			rpOrigins = append(rpOrigins, origin)
+		}
+	}
+	utils.WebAuthn, err = webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: rpName,
+		RPOrigins:     rpOrigins,
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: 5 * time.Minute},
+			Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: 5 * time.Minute},
+		},
+	})
+	if err != nil {
+		log.Fatal("Failed to configure WebAuthn: ", err)
+	}
+
+	// Providers whose discovery document can't be fetched are left out rather
+	// than keeping the API from starting
+	oauthRedirectURL := strings.TrimSuffix(os.Getenv("OAUTH_REDIRECT_URL"), "/")
+	for _, name := range strings.Split(os.Getenv("OAUTH_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		envPrefix := "OAUTH_" + strings.ToUpper(name) + "_"
+		clientID := os.Getenv(envPrefix + "CLIENT_ID")
+		clientSecret := os.Getenv(envPrefix + "CLIENT_SECRET")
+		if clientID == "" || clientSecret == "" || oauthRedirectURL == "" {
+			log.Fatalf("Failed to load OAUTH_REDIRECT_URL, %sCLIENT_ID and %sCLIENT_SECRET from .env", envPrefix, envPrefix)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		provider, err := utils.NewOAuthProvider(ctx, name, clientID, clientSecret, os.Getenv(envPrefix+"ISSUER"), oauthRedirectURL+"/"+name)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to set up OAuth provider %s: %v", name, err)
+			continue
+		}
+		utils.OAuthProviders[name] = provider
+	}
 }
 
 func (app *App) InitializeDatabase() {
@@ -87,19 +230,150 @@ func (app *App) InitializeDatabase() {
 	modelsToMigrate := []interface{}{
 		&models.User{},
 		&models.RefreshToken{},
+		&models.SecurityEvent{},
+		&models.RevokedSession{},
+		&models.LoginAttempt{},
+		&models.TotpSecret{},
+		&models.RecoveryCode{},
+		&models.WebAuthnCredential{},
+		&models.WebAuthnChallenge{},
 		&models.ForgotPassword{},
 		&models.VerifyEmail{},
+		&models.MagicLink{},
+		&models.EmailChange{},
+		&models.AccountDeletion{},
+		&models.DataExport{},
+		&models.OAuthIdentity{},
+		&models.OAuthState{},
+		&models.OAuthClient{},
+		&models.OAuthAuthorizationCode{},
+		&models.ReadingCycle{},
 		&models.ReadHistory{},
+		&models.ReadHistoryChange{},
 		&models.UserPreference{},
+		&models.BookmarkFolder{},
 		&models.Bookmark{},
 		&models.Note{},
+		&models.Highlight{},
 		&models.ParallelTranslations{},
+		&models.ReadingPlan{},
+		&models.PlanEnrollment{},
 	}
 	for _, model := range modelsToMigrate {
 		if err := appdata.DB.AutoMigrate(model); err != nil {
 			log.Fatal("Failed to do database migrations")
 		}
 	}
+	utils.Revocations = utils.NewCachedRevocationStore(utils.DBRevocationStore{}, 30*time.Second)
+	// Refresh tokens issued before sessions were tracked each become their own session
+	if err := appdata.DB.Model(&models.RefreshToken{}).
+		Where("session_id IS NULL OR session_id = ''").
+		Updates(map[string]interface{}{
+			"session_id": gorm.Expr("'legacy-' || id"),
+			"started_at": gorm.Expr("created_at"),
+		}).Error; err != nil {
+		log.Fatal("Failed to backfill refresh token sessions")
+	}
+	if err := invalidatePlaintextTokens(); err != nil {
+		log.Fatal("Failed to invalidate plaintext tokens: ", err)
+	}
+	if err := assignReadingCycles(); err != nil {
+		log.Fatal("Failed to assign read history to reading cycles: ", err)
+	}
+	if err := migrateBookmarkRanges(); err != nil {
+		log.Fatal("Failed to migrate bookmarks to verse ranges: ", err)
+	}
+	if err := addNoteSearch(); err != nil {
+		log.Fatal("Failed to add full-text search of notes: ", err)
+	}
+	if err := utils.SeedReadingPlans(); err != nil {
+		log.Fatal("Failed to seed reading plans: ", err)
+	}
+	utils.StartAccountPurge(time.Hour)
+}
+
+// invalidatePlaintextTokens removes the token columns of the tables that now
+// only store hashes, together with every row that has no hash. The old tokens
+// came from math/rand, so they are thrown away rather than hashed: pending
+// emailed links stop working and every device has to log in again.
+func invalidatePlaintextTokens() error {
+	migrator := appdata.DB.Migrator()
+	if migrator.HasColumn(&models.RefreshToken{}, "token") {
+		var sessionIDs []string
+		if err := appdata.DB.Model(&models.RefreshToken{}).Where("token_hash IS NULL AND revoked = ?", false).Distinct().Pluck("session_id", &sessionIDs).Error; err != nil {
+			return err
+		}
+		for _, sessionID := range sessionIDs {
+			if err := utils.RevokeAccessTokens(sessionID); err != nil {
+				return err
+			}
+		}
+	}
+	for _, model := range []interface{}{&models.RefreshToken{}, &models.ForgotPassword{}, &models.VerifyEmail{}, &models.MagicLink{}} {
+		if !migrator.HasColumn(model, "token") {
+			continue
+		}
+		err := appdata.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("token_hash IS NULL").Delete(model).Error; err != nil {
+				return err
+			}
+			return tx.Migrator().DropColumn(model, "token")
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assignReadingCycles puts read history from before reading cycles existed
+// into a first cycle of each user, started when their first chapter was read.
+// The old unique index over user, book and chapter, which kept a chapter from
+// being read twice, is dropped.
+func assignReadingCycles() error {
+	return appdata.DB.Transaction(func(tx *gorm.DB) error {
+		if tx.Migrator().HasIndex(&models.ReadHistory{}, "unique_read_history") {
+			if err := tx.Migrator().DropIndex(&models.ReadHistory{}, "unique_read_history"); err != nil {
+				return err
+			}
+		}
+		err := tx.Exec(`INSERT INTO reading_cycles (user_id, name, started_at)
+			SELECT user_id, ?, min(created_at) FROM read_histories h
+			WHERE cycle_id = 0 AND NOT EXISTS (SELECT 1 FROM reading_cycles c WHERE c.user_id = h.user_id AND c.ended_at IS NULL)
+			GROUP BY user_id`, utils.CycleName(1)).Error
+		if err != nil {
+			return err
+		}
+		return tx.Exec(`UPDATE read_histories h SET cycle_id = c.id FROM reading_cycles c
+			WHERE h.cycle_id = 0 AND c.user_id = h.user_id AND c.ended_at IS NULL`).Error
+	})
+}
+
+// migrateBookmarkRanges turns bookmarks from before verse ranges into ranges
+// of their one verse and drops the unique index over the first verse only
+func migrateBookmarkRanges() error {
+	return appdata.DB.Transaction(func(tx *gorm.DB) error {
+		if tx.Migrator().HasIndex(&models.Bookmark{}, "unique_bookmark") {
+			if err := tx.Migrator().DropIndex(&models.Bookmark{}, "unique_bookmark"); err != nil {
+				return err
+			}
+		}
+		return tx.Model(&models.Bookmark{}).Where("end_chapter = 0").Updates(map[string]interface{}{
+			"end_chapter": gorm.Expr("chapter_number"),
+			"end_verse":   gorm.Expr("verse_number"),
+			"position":    gorm.Expr("id"),
+		}).Error
+	})
+}
+
+// addNoteSearch adds the tsvector of every note as a generated column with a
+// GIN index, which GORM can't declare
+func addNoteSearch() error {
+	if err := appdata.DB.Exec(`ALTER TABLE notes ADD COLUMN IF NOT EXISTS search tsvector
+		GENERATED ALWAYS AS (to_tsvector('` + utils.NoteSearchConfig + `', coalesce(note, ''))) STORED`).Error; err != nil {
+		return err
+	}
+	return appdata.DB.Exec("CREATE INDEX IF NOT EXISTS idx_notes_search ON notes USING GIN (search)").Error
 }
 
 func (app *App) SetupRoutes() {
@@ -114,38 +388,110 @@ func (app *App) SetupRoutes() {
     URL: "/users/swagger/doc.json",
 }))
 	app.Fiber.Get("/", routes.Home)
+	app.Fiber.Get("/.well-known/jwks.json", routes.GetJWKS)
 	app.Fiber.Get("/checkusernameavailability", routes.CheckIfUsernameAvailable)
 	app.Fiber.Post("/users", routes.CreateUser)
 	app.Fiber.Post("/login", routes.LoginUser)
+	app.Fiber.Post("/login/mfa", routes.LoginWithMfa)
+	app.Fiber.Post("/login/magiclink", routes.SendMagicLink)
+	app.Fiber.Post("/login/magiclink/verify", routes.LoginWithMagicLink)
+	app.Fiber.Get("/oauth/providers", routes.GetOAuthProviders)
+	app.Fiber.Post("/oauth/:provider/begin", routes.BeginOAuthLogin)
+	app.Fiber.Post("/oauth/:provider/finish", routes.FinishOAuthLogin)
+	app.Fiber.Post("/login/webauthn/begin", routes.BeginPasskeyLogin)
+	app.Fiber.Post("/login/webauthn/finish", routes.FinishPasskeyLogin)
 	app.Fiber.Post("/refreshtoken", routes.RefreshToken)
 	app.Fiber.Post("/sendforgotpasswordemail", routes.SendForgotPasswordEmail)
 	app.Fiber.Post("/resetpassword", routes.ResetPassword)
 	app.Fiber.Post("/verifyemail", routes.VerifyEmail)
+	app.Fiber.Post("/confirmemailchange", routes.ConfirmEmailChange)
+	app.Fiber.Post("/undoemailchange", routes.UndoEmailChange)
+	app.Fiber.Post("/cancelaccountdeletion", routes.CancelAccountDeletion)
+	app.Fiber.Get("/me/export/download", routes.DownloadDataExport)
 	app.Fiber.Post("/logout", routes.Logout)
+	app.Fiber.Post("/oauth/token", routes.IssueOAuthToken)
+	app.Fiber.Post("/oauth/revoke", routes.RevokeOAuthToken)
+	app.Fiber.Post("/oauth/introspect", routes.IntrospectOAuthToken)
+
+	// Routes third-party apps may call with an access token carrying the scope.
+	// They come before the general JWT middleware, which turns app tokens away.
+	scoped := func(scope string) fiber.Handler {
+		return jwtware.New(jwtware.Config{
+			KeyFunc:        utils.Keys.Keyfunc,
+			SuccessHandler: utils.AllowScope(scope),
+		})
+	}
+	app.Fiber.Get("/me", scoped(utils.ScopeProfileRead), routes.GetSelfInfo)
+	app.Fiber.Post("/markchapterasread", scoped(utils.ScopeHistoryWrite), routes.MarkChapterAsRead)
+	app.Fiber.Delete("/markchapterasread", scoped(utils.ScopeHistoryWrite), routes.MarkChapterAsUnread)
+	app.Fiber.Post("/markbookasread/:bookid", scoped(utils.ScopeHistoryWrite), routes.MarkBookAsRead)
+	app.Fiber.Delete("/markbookasread/:bookid", scoped(utils.ScopeHistoryWrite), routes.MarkBookAsUnread)
+	app.Fiber.Get("/readchaptersofbook/:bookid", scoped(utils.ScopeHistoryRead), routes.GetReadChaptersOfBook)
+	app.Fiber.Get("/readbooksstatus", scoped(utils.ScopeHistoryRead), routes.GetReadBooksStatus)
+	app.Fiber.Get("/readprogress", scoped(utils.ScopeHistoryRead), routes.GetReadProgress)
+	app.Fiber.Get("/readstats", scoped(utils.ScopeHistoryRead), routes.GetReadingStats)
+	app.Fiber.Post("/readhistory/sync", scoped(utils.ScopeHistoryWrite), routes.SyncReadHistory)
+	app.Fiber.Get("/cycles", scoped(utils.ScopeHistoryRead), routes.GetReadingCycles)
+	app.Fiber.Post("/cycles", scoped(utils.ScopeHistoryWrite), routes.StartReadingCycle)
+	app.Fiber.Patch("/cycles/:id", scoped(utils.ScopeHistoryWrite), routes.RenameReadingCycle)
+	app.Fiber.Get("/bookmark", scoped(utils.ScopeBookmarksRead), routes.GetBookmarks)
+	app.Fiber.Post("/bookmark", scoped(utils.ScopeBookmarksWrite), routes.AddBookmark)
+	app.Fiber.Delete("/bookmark", scoped(utils.ScopeBookmarksWrite), routes.DeleteBookmark)
+	app.Fiber.Post("/bookmark/reorder", scoped(utils.ScopeBookmarksWrite), routes.ReorderBookmarks)
+	app.Fiber.Get("/bookmark/folders", scoped(utils.ScopeBookmarksRead), routes.GetBookmarkFolders)
+	app.Fiber.Post("/bookmark/folders", scoped(utils.ScopeBookmarksWrite), routes.CreateBookmarkFolder)
+	app.Fiber.Put("/bookmark/folders/:id", scoped(utils.ScopeBookmarksWrite), routes.UpdateBookmarkFolder)
+	app.Fiber.Delete("/bookmark/folders/:id", scoped(utils.ScopeBookmarksWrite), routes.DeleteBookmarkFolder)
+	app.Fiber.Put("/bookmark/:id", scoped(utils.ScopeBookmarksWrite), routes.UpdateBookmark)
+	app.Fiber.Delete("/bookmark/:id", scoped(utils.ScopeBookmarksWrite), routes.DeleteBookmarkByID)
+	app.Fiber.Post("/note", scoped(utils.ScopeNotesWrite), routes.CreateNote)
+	app.Fiber.Delete("/note/:noteid", scoped(utils.ScopeNotesWrite), routes.DeleteNote)
+	app.Fiber.Put("/note/:noteid", scoped(utils.ScopeNotesWrite), routes.UpdateNote)
+	app.Fiber.Put("/note/:noteid/tags", scoped(utils.ScopeNotesWrite), routes.SetNoteTags)
+	app.Fiber.Get("/note", scoped(utils.ScopeNotesRead), routes.GetNotesOfUser)
+	app.Fiber.Get("/note/tags", scoped(utils.ScopeNotesRead), routes.GetNoteTags)
+	app.Fiber.Get("/note/search", scoped(utils.ScopeNotesRead), routes.SearchNotes)
+	app.Fiber.Get("/highlights", scoped(utils.ScopeHighlightsRead), routes.GetHighlights)
+	app.Fiber.Post("/highlights", scoped(utils.ScopeHighlightsWrite), routes.CreateHighlight)
+	app.Fiber.Post("/highlights/apply", scoped(utils.ScopeHighlightsWrite), routes.ApplyHighlights)
+	app.Fiber.Post("/highlights/clear", scoped(utils.ScopeHighlightsWrite), routes.ClearHighlights)
+	app.Fiber.Put("/highlights/:id", scoped(utils.ScopeHighlightsWrite), routes.UpdateHighlight)
+	app.Fiber.Delete("/highlights/:id", scoped(utils.ScopeHighlightsWrite), routes.DeleteHighlight)
 
 	app.Fiber.Use(jwtware.New(jwtware.Config{
-		SigningKey: jwtware.SigningKey{Key: appdata.JwtSecret},
+		KeyFunc:        utils.Keys.Keyfunc,
+		SuccessHandler: utils.RejectRevokedTokens,
 	}))
 
 	app.Fiber.Post("/sendemailverificationemail", routes.SendEmailVerificationEmail)
 	app.Fiber.Put("/users", routes.UpdateUser)
-	app.Fiber.Get("/me", routes.GetSelfInfo)
+	app.Fiber.Delete("/me", routes.DeleteAccount)
+	app.Fiber.Get("/me/export", routes.ExportData)
+	app.Fiber.Post("/import", routes.ImportData)
 	app.Fiber.Post("/logoutall", routes.LogoutAll)
+	app.Fiber.Get("/sessions", routes.GetSessions)
+	app.Fiber.Delete("/sessions/:id", routes.RevokeSession)
+	app.Fiber.Get("/mfa", routes.GetMfaStatus)
+	app.Fiber.Post("/mfa/totp", routes.EnrollTotp)
+	app.Fiber.Post("/mfa/totp/confirm", routes.ConfirmTotp)
+	app.Fiber.Delete("/mfa/totp", routes.DisableTotp)
+	app.Fiber.Post("/mfa/recoverycodes", routes.RegenerateRecoveryCodes)
+	app.Fiber.Post("/webauthn/register/begin", routes.BeginPasskeyRegistration)
+	app.Fiber.Post("/webauthn/register/finish", routes.FinishPasskeyRegistration)
+	app.Fiber.Get("/webauthn/credentials", routes.GetPasskeys)
+	app.Fiber.Delete("/webauthn/credentials/:id", routes.DeletePasskey)
+	app.Fiber.Get("/oauth/identities", routes.GetOAuthIdentities)
+	app.Fiber.Delete("/oauth/identities/:id", routes.DeleteOAuthIdentity)
+	app.Fiber.Post("/oauth/identities/:provider/begin", routes.BeginOAuthLink)
+	app.Fiber.Post("/oauth/identities/:provider/finish", routes.FinishOAuthLink)
+	app.Fiber.Post("/oauth/clients", routes.RegisterOAuthClient)
+	app.Fiber.Get("/oauth/clients", routes.GetOAuthClients)
+	app.Fiber.Delete("/oauth/clients/:id", routes.DeleteOAuthClient)
+	app.Fiber.Get("/oauth/authorize", routes.GetOAuthConsent)
+	app.Fiber.Post("/oauth/authorize", routes.AuthorizeOAuthClient)
 	app.Fiber.Post("/changepassword", routes.ChangePassword)
-	app.Fiber.Post("/markchapterasread", routes.MarkChapterAsRead)
-	app.Fiber.Delete("/markchapterasread", routes.MarkChapterAsUnread)
-	app.Fiber.Post("/markbookasread/:bookid", routes.MarkBookAsRead)
-	app.Fiber.Delete("/markbookasread/:bookid", routes.MarkBookAsUnread)
-	app.Fiber.Get("/readchaptersofbook/:bookid", routes.GetReadChaptersOfBook)
-	app.Fiber.Get("/readbooksstatus", routes.GetReadBooksStatus)
 	app.Fiber.Put("/userpreferences", routes.UpdateUserPreferences)
 	app.Fiber.Delete("/userpreferences", routes.DeleteUserPreferences)
-	app.Fiber.Post("/bookmark", routes.AddBookmark)
-	app.Fiber.Delete("/bookmark", routes.DeleteBookmark)
-	app.Fiber.Post("/note", routes.CreateNote)
-	app.Fiber.Delete("/note/:noteid", routes.DeleteNote)
-	app.Fiber.Put("/note/:noteid", routes.UpdateNote)
-	app.Fiber.Get("/note", routes.GetNotesOfUser)
 	app.Fiber.Post("/paralleltranslations", routes.SetParallelTranslations)
 	app.Fiber.Delete("/paralleltranslations", routes.DeleteAllParallelTranslations)
 	app.Fiber.Delete("/paralleltranslations/:translation", routes.DeleteParallelTranslations)
@@ -157,6 +503,13 @@ func (app *App) SetupRoutes() {
 	app.Fiber.Post("/decreasefontsize", routes.DecreaseFontSize)
 	app.Fiber.Post("/increasemarginsize", routes.IncreaseMarginSize)
 	app.Fiber.Post("/decreasemarginsize", routes.DecreaseMarginSize)
+	app.Fiber.Get("/plans", routes.GetReadingPlans)
+	app.Fiber.Post("/plans", routes.CreateReadingPlan)
+	app.Fiber.Get("/plans/:id", routes.GetReadingPlan)
+	app.Fiber.Delete("/plans/:id", routes.DeleteReadingPlan)
+	app.Fiber.Post("/plans/:id/enroll", routes.EnrollInPlan)
+	app.Fiber.Delete("/plans/:id/enroll", routes.LeavePlan)
+	app.Fiber.Get("/plans/:id/today", routes.GetPlanToday)
 }
 
 func (app *App) Start() {
@@ -10,12 +10,28 @@ var SmtpUsername string
 var SmtpPassword string
 var SmtpPort uint
 var JwtExpiryMinutes uint
-var JwtSecret []byte
+var JwtKeysDir string
+var JwtSigningAlg string
+var JwtKeyRotationMinutes uint
 var RefreshExpiryMinutes uint
 var RefreshExpiryNoRemember uint
 var JwtExpiryNoRemember uint
 var ResetValidMinutes uint
+var MagicLinkValidMinutes uint
+var AccountDeletionGraceMinutes uint
+var DataExportDir string
+var DataExportValidMinutes uint
+
+// TokenEntropyBytes is how many random bytes go into refresh tokens, emailed
+// links and OAuth secrets
+var TokenEntropyBytes uint = 32
 var LogRequests bool
+var NotifyTokenReuse bool
+var TotpIssuer string
+
+// ReadingWordsPerMinute is the reading speed remaining reading times are
+// estimated with
+var ReadingWordsPerMinute uint = 200
 
 const BookCount uint = 66
 const OtCount uint = 39
@@ -16,6 +16,7 @@ type User struct {
 	Bio           string         `json:"bio"`
 	CreatedAt     time.Time      `json:"created_at"`
 	UpdatedAt     time.Time      `json:"updated_at"`
+	TokenVersion  uint           `json:"-" gorm:"not null;default:0"` // Access tokens carrying an older version are rejected
 	RefreshTokens []RefreshToken `json:"-" gorm:"foreignKey:UserID"`
 	Preference    UserPreference `json:"preference" gorm:"foreignKey:UserID"`
 }
@@ -40,26 +41,55 @@ type UserPreference struct {
 	CopyIncludesUrl         bool    `json:"copy_includes_url"`
 	MarkAsReadAutomatically bool    `json:"mark_as_read_automatically"`
 	UseAbbreviationsForNav  bool    `json:"use_abrbeviations_for_nav"`
+	// IANA time zone, like Europe/Paris, that reading days and streaks are counted in
+	Timezone *string `json:"timezone"`
 }
 
+// RefreshToken is one link in a session's chain of refresh tokens. Every
+// refresh revokes the current token and issues a new one carrying the same
+// SessionID and StartedAt, so CreatedAt of the active token is the time the
+// session was last refreshed. SessionID doubles as the token family: presenting
+// a revoked token of the family revokes the whole family.
 type RefreshToken struct {
 	ID        uint
 	UserID    uint
-	User      User `gorm:"constraint:OnDelete:CASCADE;"` // Reference to User with cascade delete
+	User      User   `gorm:"constraint:OnDelete:CASCADE;"` // Reference to User with cascade delete
+	SessionID string `gorm:"index"`
+	ParentID  *uint  // Token this one was rotated from, nil for the first token of a session
 	Device    *string
 	Location  *string
-	Token     string `gorm:"unique"`
+	Token     string `gorm:"-"` // Only set on a newly created token, to hand it out
+	TokenHash string `gorm:"unique"`
 	Remember  bool
 	Revoked   bool
+	ClientID  *string // Third-party app the session was authorized for, nil for our own apps
+	Scope     string  // Scopes granted to ClientID, space separated
+	StartedAt time.Time
 	ExpiresAt time.Time
 	CreatedAt time.Time
 }
 
+// RevokedSession keeps a logged out session on the access token denylist until
+// every access token issued for it has expired
+type RevokedSession struct {
+	SessionID string    `gorm:"primaryKey"`
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// LoginAttempt counts recent failed logins for an account or a client IP.
+// Key is "account:<user id or unknown identifier>" or "ip:<address>".
+type LoginAttempt struct {
+	Key          string `gorm:"primaryKey"`
+	Failures     uint   `gorm:"not null;default:0"`
+	LastFailedAt time.Time
+	LockedUntil  *time.Time
+}
+
 type ForgotPassword struct {
 	ID        uint
 	UserID    uint
 	User      User   `gorm:"constraint:OnDelete:CASCADE;"`
-	Token     string `gorm:"unique"`
+	TokenHash string `gorm:"unique"`
 	ExpiresAt time.Time
 	CreatedAt time.Time
 }
@@ -68,28 +98,176 @@ type VerifyEmail struct {
 	ID        uint
 	UserID    uint
 	User      User   `gorm:"constraint:OnDelete:CASCADE;"`
-	Token     string `gorm:"unique"`
+	TokenHash string `gorm:"unique"`
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// EmailChange is a new email address waiting to be confirmed from a link sent
+// to it. The old address gets an undo link, which keeps working for a while
+// after the change is confirmed, so an account taken over this way can be
+// recovered.
+type EmailChange struct {
+	ID               uint
+	UserID           uint `gorm:"index"`
+	User             User `gorm:"constraint:OnDelete:CASCADE;"`
+	OldEmail         string
+	OldEmailVerified bool
+	NewEmail         string
+	ConfirmTokenHash string `gorm:"unique"`
+	UndoTokenHash    string `gorm:"unique"`
+	ExpiresAt        time.Time
+	UndoableUntil    time.Time
+	ConfirmedAt      *time.Time
+	CreatedAt        time.Time
+}
+
+// AccountDeletion schedules the purge of an account. Until PurgeAt the owner
+// can cancel it with the link emailed to them, and nobody can log in to it.
+type AccountDeletion struct {
+	UserID          uint      `gorm:"primaryKey"`
+	User            User      `gorm:"constraint:OnDelete:CASCADE;"`
+	CancelTokenHash string    `gorm:"unique"`
+	PurgeAt         time.Time `gorm:"index"`
+	CreatedAt       time.Time
+}
+
+// DataExport is the export of a large account being built in the background,
+// or the last one built. ReadyAt is nil while it is being built.
+type DataExport struct {
+	UserID      uint `gorm:"primaryKey"`
+	User        User `gorm:"constraint:OnDelete:CASCADE;"`
+	File        string
+	RequestedAt time.Time
+	ReadyAt     *time.Time
+}
+
+// MagicLink is a single use token emailed for logging in without a password
+type MagicLink struct {
+	ID        uint
+	UserID    uint
+	User      User   `gorm:"constraint:OnDelete:CASCADE;"`
+	TokenHash string `gorm:"unique"`
 	ExpiresAt time.Time
 	CreatedAt time.Time
 }
 
+// ReadHistory is a chapter read in a reading cycle
 type ReadHistory struct {
 	ID        uint      `json:"id"`
-	UserID    uint      `json:"user_id" gorm:"uniqueIndex:unique_read_history"`
+	UserID    uint      `json:"user_id" gorm:"uniqueIndex:unique_read_history_cycle"`
 	User      User      `json:"-" gorm:"constraint:OnDelete:CASCADE;"`
-	Book      uint      `json:"book" gorm:"uniqueIndex:unique_read_history"`
-	Chapter   uint      `json:"chapter" gorm:"uniqueIndex:unique_read_history"`
+	CycleID   uint      `json:"cycle_id" gorm:"not null;default:0;index;uniqueIndex:unique_read_history_cycle"`
+	Book      uint      `json:"book" gorm:"uniqueIndex:unique_read_history_cycle"`
+	Chapter   uint      `json:"chapter" gorm:"uniqueIndex:unique_read_history_cycle"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// ReadHistoryChange is the last change of a chapter in a reading cycle, to
+// read or to unread, for syncing offline clients. ChangedAt is when the
+// client made the change and decides which of two changes wins. A change
+// replaces the row of the chapter, so IDs only grow and serve as sync cursor.
+type ReadHistoryChange struct {
+	ID        uint      `json:"-"`
+	UserID    uint      `json:"-" gorm:"uniqueIndex:unique_read_history_change"`
+	User      User      `json:"-" gorm:"constraint:OnDelete:CASCADE;"`
+	CycleID   uint      `json:"cycle_id" gorm:"uniqueIndex:unique_read_history_change"`
+	Book      uint      `json:"book" gorm:"uniqueIndex:unique_read_history_change"`
+	Chapter   uint      `json:"chapter" gorm:"uniqueIndex:unique_read_history_change"`
+	Read      bool      `json:"read"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// ReadingCycle is one read-through of the Bible. The cycle without EndedAt is
+// the current one, which chapters are marked read in; starting a new cycle
+// ends it.
+type ReadingCycle struct {
+	ID        uint       `json:"id"`
+	UserID    uint       `json:"-" gorm:"index;uniqueIndex:unique_current_cycle,where:ended_at IS NULL"`
+	User      User       `json:"-" gorm:"constraint:OnDelete:CASCADE;"`
+	Name      string     `json:"name"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at"`
+}
+
+// Bookmark marks a range of verses in one book, from ChapterNumber:VerseNumber
+// to EndChapter:EndVerse. A single verse ends where it starts.
 type Bookmark struct {
-	ID            uint      `json:"id"`
-	UserID        uint      `json:"user_id" gorm:"uniqueIndex:unique_bookmark"`
-	User          User      `json:"-" gorm:"constraint:OnDelete:CASCADE;"`
-	Book          string    `json:"book" gorm:"uniqueIndex:unique_bookmark"`
-	ChapterNumber uint      `json:"chapter_number" gorm:"uniqueIndex:unique_bookmark"`
-	VerseNumber   uint      `json:"verse_number" gorm:"uniqueIndex:unique_bookmark"`
-	CreatedAt     time.Time `json:"created_at"`
+	ID            uint            `json:"id"`
+	UserID        uint            `json:"user_id" gorm:"uniqueIndex:unique_bookmark_range"`
+	User          User            `json:"-" gorm:"constraint:OnDelete:CASCADE;"`
+	Book          string          `json:"book" gorm:"uniqueIndex:unique_bookmark_range"`
+	ChapterNumber uint            `json:"chapter_number" gorm:"uniqueIndex:unique_bookmark_range"`
+	VerseNumber   uint            `json:"verse_number" gorm:"uniqueIndex:unique_bookmark_range"`
+	EndChapter    uint            `json:"end_chapter" gorm:"not null;default:0;uniqueIndex:unique_bookmark_range"`
+	EndVerse      uint            `json:"end_verse" gorm:"not null;default:0;uniqueIndex:unique_bookmark_range"`
+	Title         string          `json:"title"`
+	Color         *string         `json:"color" example:"#FFD54F"`
+	FolderID      *uint           `json:"folder_id" gorm:"index"`
+	Folder        *BookmarkFolder `json:"-" gorm:"constraint:OnDelete:SET NULL;"`
+	Position      int             `json:"position"`
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+}
+
+// BookmarkFolder is a collection of bookmarks. Deleting it leaves its
+// bookmarks outside any folder.
+type BookmarkFolder struct {
+	ID        uint      `json:"id"`
+	UserID    uint      `json:"-" gorm:"uniqueIndex:unique_bookmark_folder"`
+	User      User      `json:"-" gorm:"constraint:OnDelete:CASCADE;"`
+	Name      string    `json:"name" gorm:"uniqueIndex:unique_bookmark_folder"`
+	Position  int       `json:"position"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Highlight colours the verses from Chapter:Verse to EndChapter:EndVerse of a
+// book, numbered from 1 like in ReadHistory. Highlights of a user don't
+// overlap: highlighting verses replaces what they were highlighted with.
+type Highlight struct {
+	ID         uint      `json:"id"`
+	UserID     uint      `json:"-" gorm:"index:idx_highlights_chapter"`
+	User       User      `json:"-" gorm:"constraint:OnDelete:CASCADE;"`
+	Book       uint      `json:"book_id" gorm:"index:idx_highlights_chapter"`
+	Chapter    uint      `json:"chapter" gorm:"index:idx_highlights_chapter"`
+	Verse      uint      `json:"verse"`
+	EndChapter uint      `json:"end_chapter"`
+	EndVerse   uint      `json:"end_verse"`
+	Color      string    `json:"color" example:"#FFF176"`
+	Style      string    `json:"style" example:"highlight"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// ReadingPlan is an ordered list of chapters split into days. Built-in plans
+// have a slug and no owner, and are updated from the code on startup.
+type ReadingPlan struct {
+	ID          uint            `json:"id"`
+	Slug        *string         `json:"slug" gorm:"unique"`
+	OwnerID     *uint           `json:"-" gorm:"index"`
+	Owner       *User           `json:"-" gorm:"constraint:OnDelete:CASCADE;"`
+	Name        string          `json:"name" gorm:"not null"`
+	Description string          `json:"description"`
+	Days        [][]PlanChapter `json:"days" gorm:"serializer:json"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// PlanChapter is a chapter of a reading plan. Book is numbered from 1 like in
+// ReadHistory.
+type PlanChapter struct {
+	Book    uint `json:"book_id"`
+	Chapter uint `json:"chapter"`
+}
+
+// PlanEnrollment is a user following a reading plan from StartDate on
+type PlanEnrollment struct {
+	ID        uint        `json:"id"`
+	UserID    uint        `json:"-" gorm:"uniqueIndex:unique_plan_enrollment"`
+	User      User        `json:"-" gorm:"constraint:OnDelete:CASCADE;"`
+	PlanID    uint        `json:"plan_id" gorm:"uniqueIndex:unique_plan_enrollment"`
+	Plan      ReadingPlan `json:"-" gorm:"constraint:OnDelete:CASCADE;"`
+	StartDate time.Time   `json:"start_date" gorm:"type:date"`
+	CreatedAt time.Time   `json:"created_at"`
 }
 
 type ParallelTranslations struct {
@@ -101,6 +279,8 @@ type ParallelTranslations struct {
 	CreatedAt    time.Time `json:"created_at"`
 }
 
+// Note is a note on a verse. The notes table also has a generated search
+// column, the tsvector of Note, for full-text search.
 type Note struct {
 	ID            uint      `json:"id"`
 	UserID        uint      `json:"user_id"`
@@ -109,6 +289,127 @@ type Note struct {
 	ChapterNumber uint      `json:"chapter_number"`
 	VerseNumber   uint      `json:"verse_number"`
 	Note          string    `json:"note"`
+	Tags          []string  `json:"tags" gorm:"serializer:json;type:jsonb;not null;default:'[]';index:idx_notes_tags,type:gin"`
 	CreatedAt     time.Time `json:"created_at"`
 	UpdatedAt     time.Time `json:"updated_at"`
 }
+
+const (
+	SecurityEventRefreshTokenReuse = "refresh_token_reuse"
+	SecurityEventPasskeyCloned     = "passkey_cloned"
+	SecurityEventLoginLockout      = "login_lockout"
+	SecurityEventEmailChangeUndone = "email_change_undone"
+	SecurityEventPasskeyAdded      = "passkey_added"
+)
+
+// SecurityEvent is an audit record of suspicious or sensitive activity on an account
+type SecurityEvent struct {
+	ID        uint      `json:"id"`
+	UserID    uint      `json:"user_id" gorm:"index"`
+	User      User      `json:"-" gorm:"constraint:OnDelete:CASCADE;"`
+	Type      string    `json:"type"`
+	SessionID string    `json:"session_id"`
+	IP        string    `json:"ip"`
+	Detail    string    `json:"detail"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TotpSecret is the authenticator app enrolled by a user. Until Confirmed is
+// set the enrollment is pending and login doesn't ask for a code.
+type TotpSecret struct {
+	ID             uint
+	UserID         uint   `gorm:"unique"`
+	User           User   `gorm:"constraint:OnDelete:CASCADE;"`
+	Secret         string `gorm:"not null"`
+	Confirmed      bool
+	LastUsedStep   int64 // Time step of the last accepted code, older codes are rejected
+	FailedAttempts uint
+	LastFailedAt   *time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// RecoveryCode is a single use code that replaces a TOTP code when the
+// authenticator is lost. Only the SHA-256 of the code is stored.
+type RecoveryCode struct {
+	ID        uint
+	UserID    uint   `gorm:"index"`
+	User      User   `gorm:"constraint:OnDelete:CASCADE;"`
+	CodeHash  string `gorm:"not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// WebAuthnCredential is a passkey registered by a user. Data holds the
+// credential record (public key, sign count, flags) as JSON.
+type WebAuthnCredential struct {
+	ID           uint       `json:"id"`
+	UserID       uint       `json:"-" gorm:"index"`
+	User         User       `json:"-" gorm:"constraint:OnDelete:CASCADE;"`
+	CredentialID []byte     `json:"-" gorm:"unique;not null"`
+	Name         string     `json:"name"`
+	Data         []byte     `json:"-" gorm:"not null"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+}
+
+// WebAuthnChallenge is the server side state of a registration or login
+// ceremony between its begin and finish requests. It is deleted when used.
+type WebAuthnChallenge struct {
+	ID          string `gorm:"primaryKey"`
+	UserID      *uint  // nil for discoverable logins, where the passkey names the user
+	Ceremony    string
+	SessionData []byte
+	ExpiresAt   time.Time
+}
+
+// OAuthIdentity links an account at an external provider (Google, GitHub or
+// another OpenID Connect provider) to a user
+type OAuthIdentity struct {
+	ID          uint       `json:"id"`
+	UserID      uint       `json:"-" gorm:"index"`
+	User        User       `json:"-" gorm:"constraint:OnDelete:CASCADE;"`
+	Provider    string     `json:"provider" gorm:"not null;uniqueIndex:unique_oauth_identity"`
+	Subject     string     `json:"-" gorm:"not null;uniqueIndex:unique_oauth_identity"`
+	Email       string     `json:"email"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+}
+
+// OAuthState is a pending authorization request. UserID is set when a logged
+// in user is linking a provider rather than logging in.
+type OAuthState struct {
+	State        string `gorm:"primaryKey"`
+	Provider     string
+	CodeVerifier string
+	Nonce        string
+	UserID       *uint
+	ExpiresAt    time.Time `gorm:"index"`
+}
+
+// OAuthClient is a third-party app that users can authorize to access their
+// data. Public clients (mobile and browser apps) have no secret and rely on
+// PKCE alone.
+type OAuthClient struct {
+	ID           uint      `json:"id"`
+	OwnerID      uint      `json:"-" gorm:"index"`
+	Owner        User      `json:"-" gorm:"constraint:OnDelete:CASCADE;"`
+	ClientID     string    `json:"client_id" gorm:"unique;not null"`
+	SecretHash   string    `json:"-"` // SHA-256 of the secret, empty for public clients
+	Name         string    `json:"name" gorm:"not null"`
+	RedirectURIs []string  `json:"redirect_uris" gorm:"serializer:json"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// OAuthAuthorizationCode is issued when a user approves an app and redeemed
+// once at /oauth/token together with the PKCE verifier
+type OAuthAuthorizationCode struct {
+	Code          string `gorm:"primaryKey"` // SHA-256 of the code
+	ClientID      string
+	UserID        uint
+	User          User `gorm:"constraint:OnDelete:CASCADE;"`
+	RedirectURI   string
+	Scope         string
+	CodeChallenge string
+	ExpiresAt     time.Time `gorm:"index"`
+}
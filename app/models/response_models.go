@@ -1,6 +1,10 @@
 package models
 
-import "strings"
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
 
 type LoginRequest struct {
 	EmailOrUsername string  `json:"emailorusername"`
@@ -31,6 +35,23 @@ func NewInvalidRequestBodyError() ErrorResponse {
 	return ErrorResponse{Error: "Invalid request body."}
 }
 
+// PasswordViolation is a rule of the password policy a new password breaks
+type PasswordViolation struct {
+	Code    string `json:"code" example:"too_short"`
+	Message string `json:"message" example:"Use at least 10 characters"`
+}
+
+// PasswordPolicyError is returned when a new password is refused, with every
+// rule it breaks so clients can show them all at once
+type PasswordPolicyError struct {
+	Error      string              `json:"error"`
+	Violations []PasswordViolation `json:"violations"`
+}
+
+func NewPasswordPolicyError(violations []PasswordViolation) PasswordPolicyError {
+	return PasswordPolicyError{Error: "Password doesn't meet the password policy.", Violations: violations}
+}
+
 type SignupRequest struct {
 	Name     string `json:"name"`
 	Username string `json:"username"`
@@ -62,6 +83,7 @@ type ReadBook struct {
 	Book         string     `json:"book"`
 	Abbreviation string     `json:"abbreviation"`
 	Status       StatusType `json:"status"`
+	ReadProgress
 }
 
 type GenericMessage struct {
@@ -94,4 +116,461 @@ type BookReadChaptersResponse struct {
 	Book         string `json:"book"`
 	Abbreviation string `json:"abbreviation"`
 	ReadChapters []uint `json:"read_chapters"`
-}
\ No newline at end of file
+}
+
+type MagicLinkRequest struct {
+	Email string `json:"email"`
+}
+
+// MagicLinkLoginRequest exchanges an emailed magic link token for a token pair
+type MagicLinkLoginRequest struct {
+	Token    string  `json:"token"`
+	Remember bool    `json:"remember"`
+	Device   *string `json:"device"`
+	Location *string `json:"location"`
+}
+
+// EmailedTokenRequest carries the token of an emailed link
+type EmailedTokenRequest struct {
+	Token string `json:"token"`
+}
+
+// Session describes a device that is logged in with a refresh token
+type Session struct {
+	ID              string    `json:"id"`
+	Device          *string   `json:"device"`
+	Location        *string   `json:"location"`
+	Remember        bool      `json:"remember"`
+	CreatedAt       time.Time `json:"created_at"`
+	LastRefreshedAt time.Time `json:"last_refreshed_at"`
+	ExpiresAt       time.Time `json:"expires_at"`
+	Current         bool      `json:"current"`
+	ClientID        *string   `json:"client_id"` // Set for third-party apps
+	Scope           string    `json:"scope,omitempty"`
+}
+
+// NewSession describes the session of the latest refresh token of a session
+func NewSession(token *RefreshToken, currentSession string) Session {
+	return Session{
+		ID:              token.SessionID,
+		Device:          token.Device,
+		Location:        token.Location,
+		Remember:        token.Remember,
+		CreatedAt:       token.StartedAt,
+		LastRefreshedAt: token.CreatedAt,
+		ExpiresAt:       token.ExpiresAt,
+		Current:         token.SessionID == currentSession,
+		ClientID:        token.ClientID,
+		Scope:           token.Scope,
+	}
+}
+
+// MfaChallengeResponse is returned by /login instead of a token pair when the
+// account has two-factor authentication enabled
+type MfaChallengeResponse struct {
+	MfaRequired bool   `json:"mfa_required"`
+	MfaToken    string `json:"mfa_token"`
+	ExpiresIn   uint   `json:"expires_in"` // seconds
+}
+
+// MfaLoginRequest completes a login with either a TOTP code or a recovery code
+type MfaLoginRequest struct {
+	MfaToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type TotpEnrollResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"` // Encode as a QR code for authenticator apps
+}
+
+type TotpCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type PasswordRequest struct {
+	Password string `json:"password"`
+}
+
+type RecoveryCodesResponse struct {
+	Message       string   `json:"message"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type MfaStatusResponse struct {
+	TotpEnabled       bool `json:"totp_enabled"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+// WebAuthnBeginResponse starts a passkey ceremony. Options is passed to
+// navigator.credentials.create() or .get() after decoding its base64url fields,
+// ChallengeID is sent back with the result.
+type WebAuthnBeginResponse struct {
+	ChallengeID string      `json:"challenge_id"`
+	Options     interface{} `json:"options"`
+}
+
+type WebAuthnLoginBeginRequest struct {
+	EmailOrUsername string `json:"emailorusername"` // Leave empty to let the authenticator pick a passkey
+}
+
+// WebAuthnFinishRequest carries the PublicKeyCredential returned by the
+// browser. Name is used when registering, the login fields when logging in.
+type WebAuthnFinishRequest struct {
+	ChallengeID string          `json:"challenge_id"`
+	Credential  json.RawMessage `json:"credential" swaggertype:"object"`
+	Name        string          `json:"name"`
+	Remember    bool            `json:"remember"`
+	Device      *string         `json:"device"`
+	Location    *string         `json:"location"`
+}
+
+type OAuthBeginResponse struct {
+	AuthorizationURL string `json:"authorization_url"`
+	State            string `json:"state"`
+}
+
+// OAuthFinishRequest carries the code and state the provider redirected back
+// with. The login fields are ignored when linking a provider.
+type OAuthFinishRequest struct {
+	Code     string  `json:"code"`
+	State    string  `json:"state"`
+	Remember bool    `json:"remember"`
+	Device   *string `json:"device"`
+	Location *string `json:"location"`
+}
+
+type OAuthClientRequest struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Confidential bool     `json:"confidential"` // Server side apps that can keep a secret
+}
+
+// OAuthClientResponse is returned once when a client is registered, the
+// secret can't be retrieved later
+type OAuthClientResponse struct {
+	OAuthClient
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+type OAuthScope struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// OAuthConsentResponse is what the consent screen shows before the user
+// approves or denies an app
+type OAuthConsentResponse struct {
+	ClientID    string       `json:"client_id"`
+	ClientName  string       `json:"client_name"`
+	RedirectURI string       `json:"redirect_uri"`
+	Scopes      []OAuthScope `json:"scopes"`
+	State       string       `json:"state"`
+}
+
+// OAuthAuthorizeRequest is the user's decision on the consent screen, with the
+// parameters of the authorization request repeated
+type OAuthAuthorizeRequest struct {
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+	Approve             bool   `json:"approve"`
+}
+
+type OAuthRedirectResponse struct {
+	RedirectTo string `json:"redirect_to"`
+}
+
+// OAuthTokenResponse is the token endpoint response of RFC 6749
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    uint   `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+// OAuthErrorResponse is the error format of RFC 6749 used by the endpoints
+// third-party apps call
+type OAuthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// OAuthIntrospectionResponse follows RFC 7662, only Active is set for tokens
+// that are invalid or belong to another client
+type OAuthIntrospectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+}
+
+// ImportRowResult is what happened to one entry of an import: imported,
+// skipped because it exists already or rejected with a reason
+type ImportRowResult struct {
+	Location  string `json:"location" example:"line 2"`
+	Kind      string `json:"kind" example:"note"`
+	Reference string `json:"reference" example:"Gen.1.1"`
+	Status    string `json:"status" example:"imported"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+type ImportResponse struct {
+	Imported int               `json:"imported"`
+	Skipped  int               `json:"skipped"`
+	Rejected int               `json:"rejected"`
+	Rows     []ImportRowResult `json:"rows"`
+}
+
+// ReadingPlanSummary describes a reading plan without its chapters.
+// StartDate is set when the user is enrolled.
+type ReadingPlanSummary struct {
+	ID          uint       `json:"id"`
+	Slug        *string    `json:"slug"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Days        int        `json:"days"`
+	Chapters    int        `json:"chapters"`
+	BuiltIn     bool       `json:"built_in"`
+	StartDate   *time.Time `json:"start_date"`
+}
+
+// CreatePlanRequest defines a plan as chapters to spread over a number of
+// days. A chapter of 0 stands for every chapter of the book.
+type CreatePlanRequest struct {
+	Name        string               `json:"name" example:"Gospels in a month"`
+	Description string               `json:"description"`
+	Chapters    []PlanChapterRequest `json:"chapters"`
+	Days        int                  `json:"days" example:"30"`
+}
+
+type PlanChapterRequest struct {
+	Book    string `json:"book" example:"Matthew"`
+	Chapter uint   `json:"chapter"`
+}
+
+// EnrollPlanRequest starts a plan, today if StartDate is empty
+type EnrollPlanRequest struct {
+	StartDate string `json:"start_date" example:"2025-01-01"`
+}
+
+type PlanChapterStatus struct {
+	BookID       uint   `json:"book_id"`
+	Book         string `json:"book"`
+	Abbreviation string `json:"abbreviation"`
+	Chapter      uint   `json:"chapter"`
+	Read         bool   `json:"read"`
+}
+
+// PlanTodayResponse is the reading of the day in a plan and how far along the
+// user is. Status is not_started, behind, on_track, ahead or finished.
+type PlanTodayResponse struct {
+	PlanID           uint                `json:"plan_id"`
+	Date             string              `json:"date" example:"2025-03-14"`
+	Day              int                 `json:"day"`
+	TotalDays        int                 `json:"total_days"`
+	Status           string              `json:"status" example:"behind"`
+	Today            []PlanChapterStatus `json:"today"`
+	CatchUp          []PlanChapterStatus `json:"catch_up"`
+	ChaptersRead     int                 `json:"chapters_read"`
+	ChaptersExpected int                 `json:"chapters_expected"`
+	ChaptersTotal    int                 `json:"chapters_total"`
+}
+
+type ReadingCount struct {
+	Date  string `json:"date" example:"2025-03-14"`
+	Count int    `json:"count"`
+}
+
+// ReadingStatsResponse sums up when the user read. Days are counted in
+// Timezone. Daily has every day from From to To, days without reading
+// included, for drawing a calendar heatmap; Weekly starts weeks on Monday and
+// Monthly on the first of the month.
+type ReadingStatsResponse struct {
+	Timezone           string         `json:"timezone" example:"Europe/Paris"`
+	Today              string         `json:"today" example:"2025-03-14"`
+	CurrentStreak      int            `json:"current_streak"`
+	LongestStreak      int            `json:"longest_streak"`
+	LongestStreakStart *string        `json:"longest_streak_start" example:"2025-01-01"`
+	ChaptersRead       int            `json:"chapters_read"`
+	DaysRead           int            `json:"days_read"`
+	ChaptersToday      int            `json:"chapters_today"`
+	ChaptersThisWeek   int            `json:"chapters_this_week"`
+	ChaptersThisMonth  int            `json:"chapters_this_month"`
+	From               string         `json:"from" example:"2024-03-15"`
+	To                 string         `json:"to" example:"2025-03-14"`
+	Daily              []ReadingCount `json:"daily"`
+	Weekly             []ReadingCount `json:"weekly"`
+	Monthly            []ReadingCount `json:"monthly"`
+}
+
+// ReadProgress measures how much of a book, a testament or the Bible is read.
+// Percent is weighted by verses, so long chapters count more than short ones.
+type ReadProgress struct {
+	Chapters         uint    `json:"chapters"`
+	ChaptersRead     uint    `json:"chapters_read"`
+	Verses           uint    `json:"verses"`
+	VersesRead       uint    `json:"verses_read"`
+	Percent          float64 `json:"percent" example:"42.5"`
+	RemainingMinutes uint    `json:"remaining_minutes"`
+}
+
+type ReadProgressResponse struct {
+	Books        []ReadBook   `json:"books"`
+	OldTestament ReadProgress `json:"old_testament"`
+	NewTestament ReadProgress `json:"new_testament"`
+	Bible        ReadProgress `json:"bible"`
+}
+
+// ReadingCycleSummary is a reading cycle with its progress through the Bible.
+// FinishedAt is when the last unread chapter was read, once every chapter is.
+type ReadingCycleSummary struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name" example:"Read-through 2"`
+	Current    bool       `json:"current"`
+	StartedAt  time.Time  `json:"started_at"`
+	EndedAt    *time.Time `json:"ended_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	ReadProgress
+}
+
+// StartCycleRequest names a new reading cycle. It is numbered when Name is
+// empty.
+type StartCycleRequest struct {
+	Name string `json:"name" example:"2025 with the family"`
+}
+
+// ReadSyncRequest replays chapters marked read or unread offline. Cursor is
+// the one of the previous sync, empty on the first.
+type ReadSyncRequest struct {
+	Cursor     string              `json:"cursor" example:"12.3480"`
+	Operations []ReadSyncOperation `json:"operations"`
+}
+
+// ReadSyncOperation marks a chapter read or unread. At is when it happened on
+// the client.
+type ReadSyncOperation struct {
+	BookID  uint      `json:"book_id" example:"43"`
+	Chapter uint      `json:"chapter" example:"3"`
+	Read    bool      `json:"read"`
+	At      time.Time `json:"at"`
+}
+
+type ReadSyncChange struct {
+	BookID    uint      `json:"book_id"`
+	Book      string    `json:"book"`
+	Chapter   uint      `json:"chapter"`
+	Read      bool      `json:"read"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// ReadSyncResponse has the changes since the cursor of the request, or the
+// whole read history of the current cycle when Full is set. Operations that
+// lost to a later change made elsewhere are counted in Ignored.
+type ReadSyncResponse struct {
+	Cursor  string           `json:"cursor" example:"12.3512"`
+	CycleID uint             `json:"cycle_id"`
+	Full    bool             `json:"full"`
+	Applied int              `json:"applied"`
+	Ignored int              `json:"ignored"`
+	Changes []ReadSyncChange `json:"changes"`
+}
+
+// UpdateBookmarkRequest changes the fields that are set. An empty color and a
+// folder_id of 0 clear them.
+type UpdateBookmarkRequest struct {
+	Title      *string `json:"title" example:"The Beatitudes"`
+	Color      *string `json:"color" example:"#FFD54F"`
+	FolderID   *uint   `json:"folder_id"`
+	EndChapter *uint   `json:"end_chapter" example:"5"`
+	EndVerse   *uint   `json:"end_verse" example:"12"`
+}
+
+// ReorderRequest lists IDs in their new order
+type ReorderRequest struct {
+	IDs []uint `json:"ids"`
+}
+
+type BookmarkFolderRequest struct {
+	Name     string `json:"name" example:"Promises"`
+	Position *int   `json:"position"`
+}
+
+// VerseRange is a range of verses in one book. Book is a name, an
+// abbreviation like GEN or another common short form. The end defaults to the
+// verse the range starts at.
+type VerseRange struct {
+	Book       string `json:"book" example:"GEN"`
+	Chapter    uint   `json:"chapter" example:"1"`
+	Verse      uint   `json:"verse" example:"1"`
+	EndChapter uint   `json:"end_chapter" example:"1"`
+	EndVerse   uint   `json:"end_verse" example:"3"`
+}
+
+// HighlightRequest highlights a range. Style is highlight, the default, or
+// underline.
+type HighlightRequest struct {
+	VerseRange
+	Color string `json:"color" example:"#FFF176"`
+	Style string `json:"style" example:"highlight"`
+}
+
+// BulkHighlightRequest highlights several ranges alike, or clears them when
+// sent to /highlights/clear
+type BulkHighlightRequest struct {
+	Ranges []VerseRange `json:"ranges"`
+	Color  string       `json:"color" example:"#FFF176"`
+	Style  string       `json:"style" example:"highlight"`
+}
+
+type UpdateHighlightRequest struct {
+	Color *string `json:"color" example:"#FFF176"`
+	Style *string `json:"style" example:"underline"`
+}
+
+type VerseHighlight struct {
+	Verse       uint   `json:"verse"`
+	HighlightID uint   `json:"highlight_id"`
+	Color       string `json:"color"`
+	Style       string `json:"style"`
+}
+
+// HighlightsResponse has the highlights asked for. When a chapter is asked
+// for, Verses lists its highlighted verses for rendering.
+type HighlightsResponse struct {
+	Highlights []Highlight      `json:"highlights"`
+	Verses     []VerseHighlight `json:"verses,omitempty"`
+}
+
+type NoteTagsRequest struct {
+	Tags []string `json:"tags" example:"prayer,promises"`
+}
+
+type NoteTagCount struct {
+	Tag   string `json:"tag" example:"prayer"`
+	Count int    `json:"count"`
+}
+
+// NoteSearchResult is a matching note. When searching for text, Rank orders
+// the results and Snippet has the best passages of the note as HTML: the note
+// is escaped and the matches are between <mark> and </mark>.
+type NoteSearchResult struct {
+	Note
+	Rank    float64 `json:"rank"`
+	Snippet *string `json:"snippet"`
+}
+
+type NoteSearchResponse struct {
+	Total    int64              `json:"total"`
+	Page     int                `json:"page"`
+	PageSize int                `json:"page_size"`
+	Results  []NoteSearchResult `json:"results"`
+}
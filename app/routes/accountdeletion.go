@@ -0,0 +1,131 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"users-api/app/appdata"
+	"users-api/app/models"
+	"users-api/app/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// A session this young proves the user just logged in, which accounts without
+// a password use instead of it to confirm sensitive changes
+const recentLoginWindow = 10 * time.Minute
+
+// confirmRecentLogin checks that the caller is the account owner and not just
+// someone holding their token: either the password matches, or the session was
+// started within recentLoginWindow. Errors are meant for the client, together
+// with the status to send.
+func confirmRecentLogin(c *fiber.Ctx, user *models.User, password string) (int, error) {
+	if password != "" {
+		if !utils.CheckPassword(password, user.Password) {
+			return fiber.StatusBadRequest, errors.New("Wrong password")
+		}
+		return 0, nil
+	}
+	var session models.RefreshToken
+	err := appdata.DB.Where("session_id = ?", utils.GetSessionFromJwt(c)).First(&session).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fiber.StatusInternalServerError, errors.New(models.NewInternalError().Error)
+	}
+	if err != nil || session.StartedAt.Before(time.Now().Add(-recentLoginWindow)) {
+		return fiber.StatusForbidden, errors.New("Send your password, or log in again and retry within 10 minutes")
+	}
+	return 0, nil
+}
+
+// DeleteAccount godoc
+// @Summary      Delete the account
+// @Description  Schedules the account and all its data for deletion after a grace period. Needs the password, or a session started in the last 10 minutes for accounts without one. Every device is logged out and a link to cancel the deletion is emailed.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        password  body  models.PasswordRequest  false  "Password of the account"
+// @Success      202  {object}  models.GenericMessage
+// @Failure      400  {object}  models.ErrorResponse
+// @Failure      403  {object}  models.ErrorResponse
+// @Failure      409  {object}  models.ErrorResponse
+// @Router       /me [delete]
+func DeleteAccount(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	var req models.PasswordRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+		}
+	}
+	var user models.User
+	if err := appdata.DB.First(&user, userID).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if status, err := confirmRecentLogin(c, &user, req.Password); err != nil {
+		return c.Status(status).JSON(models.ErrorResponse{Error: err.Error()})
+	}
+
+	cancelToken, err := utils.GenerateToken()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	deletion := models.AccountDeletion{
+		UserID:          user.ID,
+		CancelTokenHash: utils.HashToken(cancelToken),
+		PurgeAt:         time.Now().Add(time.Duration(appdata.AccountDeletionGraceMinutes) * time.Minute),
+	}
+	if err := appdata.DB.Create(&deletion).Error; errors.Is(err, gorm.ErrDuplicatedKey) {
+		return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse{Error: "Account deletion is already scheduled"})
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if err := utils.LogoutEverywhere(user.ID, ""); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	appdata.DB.Where("user_id = ?", user.ID).Delete(&models.OAuthAuthorizationCode{})
+
+	purgeDate := deletion.PurgeAt.UTC().Format("January 2, 2006 15:04 MST")
+	cancelLink := fmt.Sprintf("https://versequick.com/cancelaccountdeletion/%s", cancelToken)
+	emailBody := fmt.Sprintf("Your account and all its reading history, bookmarks and notes will be deleted on %s.<br><br>"+
+		"To keep your account, visit <a href=%s>%s</a> before then.", purgeDate, cancelLink, cancelLink)
+	if err := utils.SendEmail(user.Email, "Your account will be deleted", emailBody, true); err != nil {
+		log.Printf("failed to send account deletion email to user %d: %v", user.ID, err)
+	}
+	return c.Status(fiber.StatusAccepted).JSON(models.GenericMessage{Message: fmt.Sprintf("Account will be deleted on %s. Use the emailed link to cancel.", purgeDate)})
+}
+
+// CancelAccountDeletion godoc
+// @Summary      Cancel account deletion
+// @Description  Keeps an account that is scheduled for deletion, using the token of the link emailed when the deletion was requested. The user has to log in again afterwards.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Param        token  body  models.EmailedTokenRequest  true  "Token of the cancellation link"
+// @Success      200  {object}  models.GenericMessage
+// @Failure      400  {object}  models.ErrorResponse
+// @Router       /cancelaccountdeletion [post]
+func CancelAccountDeletion(c *fiber.Ctx) error {
+	var req models.EmailedTokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+	}
+	result := appdata.DB.Where("cancel_token_hash = ? AND purge_at > ?", utils.HashToken(req.Token), time.Now()).Delete(&models.AccountDeletion{})
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Cancellation link invalid or the account is deleted already"})
+	}
+	return c.JSON(models.GenericMessage{Message: "Account deletion cancelled, you can log in again"})
+}
+
+// accountDeletionPending tells whether the user asked for their account to
+// be deleted, which keeps them from logging in until they cancel
+func accountDeletionPending(userID uint) (bool, error) {
+	var count int64
+	err := appdata.DB.Model(&models.AccountDeletion{}).Where("user_id = ?", userID).Count(&count).Error
+	return count > 0, err
+}
@@ -3,6 +3,10 @@ package routes
 import (
 	"errors"
 	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 	"users-api/app/appdata"
 	"users-api/app/models"
@@ -14,13 +18,15 @@ import (
 
 // LoginUser godoc
 // @Summary      Login a user
-// @Description  Authenticates a user with email and password and returns a JWT access token and a refresh token.
+// @Description  Authenticates a user with email and password and returns a JWT access token and a refresh token. Repeated failures lock the account and the client IP for a growing amount of time. If the user has two-factor authentication enabled, an MFA challenge token is returned instead, to be exchanged at /login/mfa.
 // @Tags         auth
 // @Accept       json
 // @Produce      json
 // @Param        credentials  body  models.LoginRequest  true  "User login credentials"
 // @Success      200  {object}  models.LoginResponse
+// @Success      202  {object}  models.MfaChallengeResponse
 // @Failure      401  {object}  models.ErrorResponse
+// @Failure      429  {object}  models.ErrorResponse
 // @Router       /login [post]
 func LoginUser(c *fiber.Ctx) error {
 	var req models.LoginRequest
@@ -35,27 +41,117 @@ func LoginUser(c *fiber.Ctx) error {
 	} else {
 		result = appdata.DB.Where("username = ?", req.EmailOrUsername).First(&user)
 	}
-	errorMessage := ""
-	var returnStatus int
-	if result.Error != nil {
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			errorMessage = "Email or Username not found"
-			returnStatus = fiber.StatusNotFound
-		} else {
-			errorMessage = "Something went wrong, try again later"
-			returnStatus = fiber.StatusInternalServerError
+	var found *models.User
+	if result.Error == nil {
+		found = &user
+	} else if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+
+	accountKey := utils.AccountLoginKey(found, strings.ToLower(req.EmailOrUsername))
+	ipKey := utils.IPLoginKey(c.IP())
+	lockedUntil, err := utils.LoginLockedUntil(accountKey, ipKey)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if !lockedUntil.IsZero() {
+		return loginLockedResponse(c, lockedUntil)
+	}
+
+	// Unknown accounts are checked against a dummy hash so the response time
+	// doesn't tell them apart from a wrong password
+	passwordHash := dummyPasswordHash()
+	if found != nil {
+		passwordHash = user.Password
+	}
+	match, needsRehash := utils.VerifyPassword(req.Password, passwordHash)
+	if !match || found == nil {
+		if _, _, err := utils.RecordLoginFailure(ipKey, true); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+		}
+		accountLockedUntil, newlyLocked, err := utils.RecordLoginFailure(accountKey, false)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
 		}
-		return c.Status(returnStatus).JSON(models.ErrorResponse{Error: errorMessage})
+		if newlyLocked && found != nil {
+			utils.RecordSecurityEvent(user.ID, models.SecurityEventLoginLockout, "", c.IP(), fmt.Sprintf("locked until %s", accountLockedUntil.Format(time.RFC3339)))
+			go utils.NotifyLoginLockout(&user, c.IP(), *accountLockedUntil)
+		}
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{Error: "Invalid credentials"})
+	}
+	utils.ClearLoginFailures(accountKey)
+	if needsRehash {
+		rehashPassword(&user, req.Password)
+	}
+	return completeLogin(c, &user, &req)
+}
+
+// completeLogin finishes a login whose first factor was verified: accounts with
+// two-factor authentication get an MFA challenge, others a token pair
+func completeLogin(c *fiber.Ctx, user *models.User, req *models.LoginRequest) error {
+	var totp models.TotpSecret
+	result := appdata.DB.Where("user_id = ? AND confirmed = ?", user.ID, true).First(&totp)
+	if result.Error == nil {
+		return c.Status(fiber.StatusAccepted).JSON(models.MfaChallengeResponse{
+			MfaRequired: true,
+			MfaToken:    utils.PrepareMfaToken(user, req),
+			ExpiresIn:   utils.MfaChallengeMinutes * 60,
+		})
+	} else if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return issueTokens(c, user, req)
+}
+
+// dummyPasswordHash is compared against when the account doesn't exist. It is
+// created on first use so that it gets the configured hashing parameters.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, err := utils.HashPassword("not a real password")
+	if err != nil {
+		log.Fatal("Failed to hash dummy password: ", err)
+	}
+	return hash
+})
+
+// rehashPassword replaces a hash made with weaker parameters or imported from
+// another system. Failing only means the old hash is kept for another login.
+func rehashPassword(user *models.User, password string) {
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		log.Printf("failed to rehash password of user %d: %v", user.ID, err)
+		return
+	}
+	// Only replaced if unchanged, in case the password was changed meanwhile
+	result := appdata.DB.Model(&models.User{}).Where("id = ? AND password = ?", user.ID, user.Password).Update("password", hash)
+	if result.Error != nil {
+		log.Printf("failed to rehash password of user %d: %v", user.ID, result.Error)
+		return
+	}
+	user.Password = hash
+}
+
+func loginLockedResponse(c *fiber.Ctx, lockedUntil time.Time) error {
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(time.Until(lockedUntil).Seconds())+1))
+	return c.Status(fiber.StatusTooManyRequests).JSON(models.ErrorResponse{Error: "Too many failed login attempts, try again later"})
+}
+
+// issueTokens starts a new session for the user and responds with its token pair
+func issueTokens(c *fiber.Ctx, user *models.User, req *models.LoginRequest) error {
+	pending, err := accountDeletionPending(user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if pending {
+		return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{Error: "This account is scheduled for deletion. Use the emailed link to cancel it before logging in."})
 	}
-	passwordCorrect := utils.CheckPassword(req.Password, user.Password)
-	if !passwordCorrect {
-		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Wrong password"})
+	refreshToken := utils.PrepareRefreshToken(user, req.Device, req.Location, req.Remember)
+	if refreshToken.Token == "" {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
 	}
-	jwtToken := utils.PrepareAccessToken(&user, req.Remember)
-	refreshToken := utils.PrepareRefreshToken(&user, req.Device, req.Location, req.Remember)
+	jwtToken := utils.PrepareAccessToken(user, refreshToken.SessionID, req.Remember)
 	return c.Status(fiber.StatusOK).JSON(models.LoginResponse{
 		AccessToken:  jwtToken,
-		RefreshToken: refreshToken,
+		RefreshToken: refreshToken.Token,
 	})
 }
 
@@ -74,34 +170,75 @@ func RefreshToken(c *fiber.Ctx) error {
 	if token == "" {
 		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "No refresh token in header. You'll have to send the previous refresh token to get a new set now"})
 	}
+	refresh, status, err := consumeRefreshToken(c, token, "")
+	if err != nil {
+		return c.Status(status).JSON(models.ErrorResponse{Error: err.Error()})
+	}
+	var user models.User
+	if err := appdata.DB.First(&user, refresh.UserID).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	newRefresh := utils.RotateRefreshToken(refresh)
+	if newRefresh.Token == "" {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	newJwtToken := utils.PrepareAccessToken(&user, newRefresh.SessionID, refresh.Remember)
+	return c.Status(fiber.StatusOK).JSON(models.LoginResponse{AccessToken: newJwtToken, RefreshToken: newRefresh.Token})
+}
+
+// consumeRefreshToken revokes a refresh token so that it can be rotated. A
+// token that was already rotated revokes its whole family instead. Errors are
+// meant for the client, together with the status to send. clientID is the
+// third-party app redeeming the token, or empty for our own apps.
+func consumeRefreshToken(c *fiber.Ctx, token string, clientID string) (*models.RefreshToken, int, error) {
 	var refresh models.RefreshToken
-	result := appdata.DB.Where("token = ?", token).First(&refresh)
+	result := appdata.DB.Where("token_hash = ?", utils.HashToken(token)).First(&refresh)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Refresh token not found in DB"})
+			return nil, fiber.StatusBadRequest, errors.New("Refresh token not found in DB")
 		} else {
-			return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+			return nil, fiber.StatusInternalServerError, errors.New(models.NewInternalError().Error)
 		}
 	}
+	if (refresh.ClientID == nil && clientID != "") || (refresh.ClientID != nil && *refresh.ClientID != clientID) {
+		return nil, fiber.StatusBadRequest, errors.New("Refresh token was issued to another app")
+	}
 	now := time.Now()
 	if refresh.ExpiresAt.Before(now) {
-		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Refresh token expired, login again."})
+		return nil, fiber.StatusBadRequest, errors.New("Refresh token expired, login again.")
+	}
+	if !refresh.Revoked {
+		// Conditional update so that two concurrent refreshes with the same token can't both succeed
+		result = appdata.DB.Model(&refresh).Where("revoked = ?", false).Update("revoked", true)
+		if result.Error != nil {
+			return nil, fiber.StatusInternalServerError, errors.New(models.NewInternalError().Error)
+		}
+		refresh.Revoked = result.RowsAffected == 0
 	}
 	if refresh.Revoked {
-		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Refresh token reuse detected, login again."})
+		// Either the client or an attacker holds a successor of this token, revoke the whole family
+		liveTokens, err := utils.RevokeRefreshTokenFamily(refresh.SessionID)
+		if err != nil {
+			return nil, fiber.StatusInternalServerError, errors.New(models.NewInternalError().Error)
+		}
+		if err := utils.RevokeAccessTokens(refresh.SessionID); err != nil {
+			return nil, fiber.StatusInternalServerError, errors.New(models.NewInternalError().Error)
+		}
+		utils.RecordSecurityEvent(refresh.UserID, models.SecurityEventRefreshTokenReuse, refresh.SessionID, c.IP(), fmt.Sprintf("revoked token %d replayed, %d live tokens revoked", refresh.ID, liveTokens))
+		if liveTokens > 0 {
+			var user models.User
+			if err := appdata.DB.First(&user, refresh.UserID).Error; err == nil {
+				go utils.NotifyRefreshTokenReuse(&user, &refresh, c.IP())
+			}
+		}
+		return nil, fiber.StatusBadRequest, errors.New("Refresh token reuse detected, login again.")
 	}
-	refresh.Revoked = true
-	appdata.DB.Save(&refresh)
-	var user models.User
-	appdata.DB.First(&user, refresh.UserID)
-	newJwtToken := utils.PrepareAccessToken(&user, refresh.Remember)
-	newRefresh := utils.PrepareRefreshToken(&user, refresh.Device, refresh.Location, refresh.Remember)
-	return c.Status(fiber.StatusOK).JSON(models.LoginResponse{AccessToken: newJwtToken, RefreshToken: newRefresh})
+	return &refresh, fiber.StatusOK, nil
 }
 
 // LogoutAll godoc
 // @Summary      Logout user from all devices
-// @Description  Logs out the user from all devices by invalidating all provided refresh tokens.
+// @Description  Logs out the user from all devices by deleting all refresh tokens and revoking every access token issued so far.
 // @Tags         auth
 // @Accept       json
 // @Produce      json
@@ -112,13 +249,15 @@ func RefreshToken(c *fiber.Ctx) error {
 // @Router       /logout/all [post]
 func LogoutAll(c *fiber.Ctx) error {
 	user_id := utils.GetUserFromJwt(c)
-	appdata.DB.Where("user_id = ?", user_id).Delete(&models.RefreshToken{})
-	return c.JSON(models.GenericMessage{Message: fmt.Sprintf("Logout successful, it might take upto %d minutes to log out of all devices completely.", appdata.JwtExpiryMinutes)})
+	if err := utils.LogoutEverywhere(user_id, ""); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.JSON(models.GenericMessage{Message: "Logout successful, all devices have been logged out."})
 }
 
 // Logout godoc
 // @Summary      Logout user from current device
-// @Description  Logs out the user from all devices by invalidating all refresh tokens associated with their account.
+// @Description  Logs out the current device by deleting the refresh tokens of its session and revoking its access tokens.
 // @Tags         auth
 // @Accept       json
 // @Produce      json
@@ -132,7 +271,7 @@ func Logout(c *fiber.Ctx) error {
 		c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Need refresh token in the header"})
 	}
 	var refreshToken models.RefreshToken
-	result := appdata.DB.Where("token = ?", token).First(&refreshToken)
+	result := appdata.DB.Where("token_hash = ?", utils.HashToken(token)).First(&refreshToken)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{Error: "Refresh token not found in DB"})
@@ -141,12 +280,13 @@ func Logout(c *fiber.Ctx) error {
 		}
 	}
 
-	// Delete the refresh token
-	if err := appdata.DB.Delete(&refreshToken).Error; err != nil {
+	// Delete every refresh token of the session, including revoked predecessors
+	if err := appdata.DB.Where("session_id = ?", refreshToken.SessionID).Delete(&models.RefreshToken{}).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if err := utils.RevokeAccessTokens(refreshToken.SessionID); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
 	}
 
-	return c.JSON(models.GenericMessage{
-		Message: fmt.Sprintf("Logout successful, it might take up to %d minutes to log out of the device completely.", appdata.JwtExpiryMinutes),
-	})
+	return c.JSON(models.GenericMessage{Message: "Logout successful"})
 }
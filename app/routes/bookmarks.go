@@ -1,25 +1,35 @@
 package routes
 
 import (
+	"errors"
+	"regexp"
 	"strconv"
+	"strings"
 	"users-api/app/appdata"
 	"users-api/app/models"
 	"users-api/app/utils"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
+// Longest title of a bookmark
+const bookmarkMaxTitle = 200
+
+// Colours are given as #RRGGBB
+var bookmarkColor = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
 func AddBookmark(c *fiber.Ctx) error {
 	user_id := utils.GetUserFromJwt(c)
 	book := c.FormValue("book")
-	var bookStruct appdata.Book
-	for _, b := range appdata.Books {
+	bookIndex := -1
+	for i, b := range appdata.Books {
 		if b.Book == book {
-			bookStruct = b
+			bookIndex = i
 			break
 		}
 	}
-	if bookStruct.Book == "" {
+	if bookIndex == -1 {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Book not valid",
 		})
@@ -38,10 +48,56 @@ func AddBookmark(c *fiber.Ctx) error {
 			"error": "Verse Number not a valid number",
 		})
 	}
-	var bookmark models.Bookmark = models.Bookmark{UserID: user_id, Book: book, ChapterNumber: uint(chapterInt), VerseNumber: uint(verseNumberInt)}
-	appdata.DB.Create(&bookmark)
+	// A range ends at end_chapter:end_verse, by default the verse it starts at
+	endChapter, endVerse, err := formVerseRangeEnd(c, uint(chapterInt), uint(verseNumberInt))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	start := utils.VerseRef{Book: bookIndex, Chapter: uint(chapterInt), Verse: uint(verseNumberInt)}
+	if err := utils.ValidateVerseRange(start, endChapter, endVerse); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	var bookmark models.Bookmark = models.Bookmark{UserID: user_id, Book: book, ChapterNumber: uint(chapterInt), VerseNumber: uint(verseNumberInt), EndChapter: endChapter, EndVerse: endVerse}
+	update := models.UpdateBookmarkRequest{}
+	if title := c.FormValue("title"); title != "" {
+		update.Title = &title
+	}
+	if color := c.FormValue("color"); color != "" {
+		update.Color = &color
+	}
+	if folderString := c.FormValue("folder_id"); folderString != "" {
+		folderID, err := strconv.Atoi(folderString)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Folder id not a valid number",
+			})
+		}
+		folder := uint(folderID)
+		update.FolderID = &folder
+	}
+	if status, err := applyBookmarkDetails(&bookmark, update); err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err := appdata.DB.Model(&models.Bookmark{}).Where("user_id = ?", user_id).Select("COALESCE(MAX(position), 0) + 1").Scan(&bookmark.Position).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if err := appdata.DB.Create(&bookmark).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "This bookmark exists already",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
 	return c.JSON(fiber.Map{
-		"message": "Created bookmark",
+		"message":  "Created bookmark",
+		"bookmark": bookmark,
 	})
 }
 
@@ -62,10 +118,392 @@ func DeleteBookmark(c *fiber.Ctx) error {
 			"error": "Verse Number not a valid number",
 		})
 	}
+	endChapter, endVerse, err := formVerseRangeEnd(c, uint(chapterInt), uint(verseNumberInt))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
 	var bookmark models.Bookmark
-	appdata.DB.Where("user_id = ? AND book = ? AND chapter_number = ? and verse_number = ?", user_id, book, uint(chapterInt), uint(verseNumberInt)).First(&bookmark)
+	appdata.DB.Where("user_id = ? AND book = ? AND chapter_number = ? and verse_number = ? AND end_chapter = ? AND end_verse = ?", user_id, book, uint(chapterInt), uint(verseNumberInt), endChapter, endVerse).First(&bookmark)
 	appdata.DB.Delete(&bookmark)
 	return c.JSON(fiber.Map{
 		"message": "Bookmark deleted",
 	})
 }
+
+// GetBookmarks godoc
+// @Summary      List bookmarks
+// @Description  Returns the bookmarks of the user in their order. Filters combine: book (or abbreviation) and chapter keep the bookmarks whose range covers the chapter, folder_id those of a folder ("none" for those outside folders), color those of a colour and q those whose title contains the text.
+// @Tags         bookmarks
+// @Produce      json
+// @Security     BearerAuth
+// @Param        book          query  string  false  "Name of the book"
+// @Param        abbreviation  query  string  false  "Abbreviation of the book"
+// @Param        chapter       query  int     false  "Chapter covered by the bookmarks"
+// @Param        folder_id     query  string  false  "Folder ID or none"
+// @Param        color         query  string  false  "Colour like #FFD54F"
+// @Param        q             query  string  false  "Text in the title"
+// @Success      200  {array}  models.Bookmark
+// @Failure      400  {object}  models.ErrorResponse
+// @Router       /bookmark [get]
+func GetBookmarks(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	query := appdata.DB.Where("user_id = ?", userID)
+
+	book := c.Query("book")
+	if abbreviation := strings.ToUpper(c.Query("abbreviation")); abbreviation != "" {
+		book = ""
+		for _, b := range appdata.Books {
+			if b.Abbreviation == abbreviation {
+				book = b.Book
+			}
+		}
+		if book == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Invalid book"})
+		}
+	}
+	if book != "" {
+		query = query.Where("book = ?", book)
+		if chapter := c.QueryInt("chapter"); chapter > 0 {
+			query = query.Where("chapter_number <= ? AND end_chapter >= ?", chapter, chapter)
+		}
+	}
+	switch folder := c.Query("folder_id"); folder {
+	case "":
+	case "none":
+		query = query.Where("folder_id IS NULL")
+	default:
+		folderID, err := strconv.Atoi(folder)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Wrong folder id"})
+		}
+		query = query.Where("folder_id = ?", folderID)
+	}
+	if color := c.Query("color"); color != "" {
+		query = query.Where("UPPER(color) = ?", strings.ToUpper(color))
+	}
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		query = query.Where("title ILIKE ?", "%"+escapeLike(q)+"%")
+	}
+
+	bookmarks := []models.Bookmark{}
+	if err := query.Order("position, created_at").Find(&bookmarks).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.JSON(bookmarks)
+}
+
+// UpdateBookmark godoc
+// @Summary      Update a bookmark
+// @Description  Changes the title, colour, folder or end of the range of a bookmark
+// @Tags         bookmarks
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id        path  int                           true  "Bookmark ID"
+// @Param        bookmark  body  models.UpdateBookmarkRequest  true  "Fields to change"
+// @Success      200  {object}  models.Bookmark
+// @Failure      400  {object}  models.ErrorResponse
+// @Failure      404  {object}  models.ErrorResponse
+// @Failure      409  {object}  models.ErrorResponse
+// @Router       /bookmark/{id} [put]
+func UpdateBookmark(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	var req models.UpdateBookmarkRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+	}
+	bookmarkID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Wrong bookmark id"})
+	}
+	var bookmark models.Bookmark
+	result := appdata.DB.Where("id = ? AND user_id = ?", bookmarkID, userID).First(&bookmark)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{Error: "Bookmark not found"})
+	} else if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+
+	if req.EndChapter != nil || req.EndVerse != nil {
+		if req.EndChapter != nil {
+			bookmark.EndChapter = *req.EndChapter
+		}
+		if req.EndVerse != nil {
+			bookmark.EndVerse = *req.EndVerse
+		}
+		book, _ := utils.FindBook(bookmark.Book)
+		start := utils.VerseRef{Book: book, Chapter: bookmark.ChapterNumber, Verse: bookmark.VerseNumber}
+		if err := utils.ValidateVerseRange(start, bookmark.EndChapter, bookmark.EndVerse); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: err.Error()})
+		}
+	}
+	if status, err := applyBookmarkDetails(&bookmark, req); err != nil {
+		return c.Status(status).JSON(models.ErrorResponse{Error: err.Error()})
+	}
+	if err := appdata.DB.Select("end_chapter", "end_verse", "title", "color", "folder_id", "updated_at").Updates(&bookmark).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse{Error: "A bookmark of this range exists already"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.JSON(bookmark)
+}
+
+// DeleteBookmarkByID godoc
+// @Summary      Delete a bookmark
+// @Tags         bookmarks
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path  int  true  "Bookmark ID"
+// @Success      200  {object}  models.GenericMessage
+// @Failure      404  {object}  models.ErrorResponse
+// @Router       /bookmark/{id} [delete]
+func DeleteBookmarkByID(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	bookmarkID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Wrong bookmark id"})
+	}
+	result := appdata.DB.Where("id = ? AND user_id = ?", bookmarkID, userID).Delete(&models.Bookmark{})
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{Error: "Bookmark not found"})
+	}
+	return c.JSON(models.GenericMessage{Message: "Bookmark deleted"})
+}
+
+// ReorderBookmarks godoc
+// @Summary      Reorder bookmarks
+// @Description  Puts the bookmarks in the order of the list, ahead of the bookmarks left out of it. To reorder a folder, send the IDs of its bookmarks.
+// @Tags         bookmarks
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        order  body  models.ReorderRequest  true  "Bookmark IDs in their new order"
+// @Success      200  {object}  models.GenericMessage
+// @Failure      400  {object}  models.ErrorResponse
+// @Router       /bookmark/reorder [post]
+func ReorderBookmarks(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	var req models.ReorderRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+	}
+	var count int64
+	if err := appdata.DB.Model(&models.Bookmark{}).Where("user_id = ? AND id IN ?", userID, req.IDs).Count(&count).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if len(req.IDs) == 0 || int(count) != len(req.IDs) {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "List each of your bookmarks once"})
+	}
+	err := appdata.DB.Transaction(func(tx *gorm.DB) error {
+		// Bookmarks left out move behind the listed ones, keeping their order
+		if err := tx.Model(&models.Bookmark{}).Where("user_id = ? AND id NOT IN ?", userID, req.IDs).
+			Update("position", gorm.Expr("position + ?", len(req.IDs))).Error; err != nil {
+			return err
+		}
+		for i, id := range req.IDs {
+			if err := tx.Model(&models.Bookmark{}).Where("id = ?", id).Update("position", i+1).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.JSON(models.GenericMessage{Message: "Bookmarks reordered"})
+}
+
+// GetBookmarkFolders godoc
+// @Summary      List bookmark folders
+// @Tags         bookmarks
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {array}  models.BookmarkFolder
+// @Router       /bookmark/folders [get]
+func GetBookmarkFolders(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	folders := []models.BookmarkFolder{}
+	if err := appdata.DB.Where("user_id = ?", userID).Order("position, name").Find(&folders).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.JSON(folders)
+}
+
+// CreateBookmarkFolder godoc
+// @Summary      Create a bookmark folder
+// @Tags         bookmarks
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        folder  body  models.BookmarkFolderRequest  true  "Name and position of the folder"
+// @Success      201  {object}  models.BookmarkFolder
+// @Failure      400  {object}  models.ErrorResponse
+// @Failure      409  {object}  models.ErrorResponse
+// @Router       /bookmark/folders [post]
+func CreateBookmarkFolder(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	var req models.BookmarkFolderRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+	}
+	folder := models.BookmarkFolder{UserID: userID, Name: strings.TrimSpace(req.Name)}
+	if folder.Name == "" || len(folder.Name) > bookmarkMaxTitle {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Folder names have 1 to " + strconv.Itoa(bookmarkMaxTitle) + " characters"})
+	}
+	if req.Position != nil {
+		folder.Position = *req.Position
+	} else if err := appdata.DB.Model(&models.BookmarkFolder{}).Where("user_id = ?", userID).Select("COALESCE(MAX(position), 0) + 1").Scan(&folder.Position).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if err := appdata.DB.Create(&folder).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse{Error: "You have a folder with this name already"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.Status(fiber.StatusCreated).JSON(folder)
+}
+
+// UpdateBookmarkFolder godoc
+// @Summary      Rename or move a bookmark folder
+// @Tags         bookmarks
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id      path  int                           true  "Folder ID"
+// @Param        folder  body  models.BookmarkFolderRequest  true  "New name and position, an empty name keeps the name"
+// @Success      200  {object}  models.BookmarkFolder
+// @Failure      400  {object}  models.ErrorResponse
+// @Failure      404  {object}  models.ErrorResponse
+// @Failure      409  {object}  models.ErrorResponse
+// @Router       /bookmark/folders/{id} [put]
+func UpdateBookmarkFolder(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	var req models.BookmarkFolderRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+	}
+	folderID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Wrong folder id"})
+	}
+	var folder models.BookmarkFolder
+	result := appdata.DB.Where("id = ? AND user_id = ?", folderID, userID).First(&folder)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{Error: "Folder not found"})
+	} else if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if name := strings.TrimSpace(req.Name); name != "" {
+		if len(name) > bookmarkMaxTitle {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Folder names have 1 to " + strconv.Itoa(bookmarkMaxTitle) + " characters"})
+		}
+		folder.Name = name
+	}
+	if req.Position != nil {
+		folder.Position = *req.Position
+	}
+	if err := appdata.DB.Select("name", "position").Updates(&folder).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse{Error: "You have a folder with this name already"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.JSON(folder)
+}
+
+// DeleteBookmarkFolder godoc
+// @Summary      Delete a bookmark folder
+// @Description  Deletes the folder. Its bookmarks are kept outside any folder.
+// @Tags         bookmarks
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path  int  true  "Folder ID"
+// @Success      200  {object}  models.GenericMessage
+// @Failure      404  {object}  models.ErrorResponse
+// @Router       /bookmark/folders/{id} [delete]
+func DeleteBookmarkFolder(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	folderID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Wrong folder id"})
+	}
+	result := appdata.DB.Where("id = ? AND user_id = ?", folderID, userID).Delete(&models.BookmarkFolder{})
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{Error: "Folder not found"})
+	}
+	return c.JSON(models.GenericMessage{Message: "Folder deleted"})
+}
+
+// formVerseRangeEnd reads the end_chapter and end_verse form values, which
+// default to the chapter and verse a range starts at
+func formVerseRangeEnd(c *fiber.Ctx, chapter uint, verse uint) (uint, uint, error) {
+	endChapter, endVerse := chapter, verse
+	if value := c.FormValue("end_chapter"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return 0, 0, errors.New("End chapter not a valid number")
+		}
+		endChapter = uint(n)
+	}
+	if value := c.FormValue("end_verse"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return 0, 0, errors.New("End verse not a valid number")
+		}
+		endVerse = uint(n)
+	}
+	return endChapter, endVerse, nil
+}
+
+// applyBookmarkDetails sets the title, colour and folder of the request that
+// are given, checking that the folder is one of the owner of the bookmark
+func applyBookmarkDetails(bookmark *models.Bookmark, req models.UpdateBookmarkRequest) (int, error) {
+	if req.Title != nil {
+		title := strings.TrimSpace(*req.Title)
+		if len(title) > bookmarkMaxTitle {
+			return fiber.StatusBadRequest, errors.New("Titles have at most " + strconv.Itoa(bookmarkMaxTitle) + " characters")
+		}
+		bookmark.Title = title
+	}
+	if req.Color != nil {
+		if *req.Color == "" {
+			bookmark.Color = nil
+		} else if !bookmarkColor.MatchString(*req.Color) {
+			return fiber.StatusBadRequest, errors.New("Colours look like #FFD54F")
+		} else {
+			color := strings.ToUpper(*req.Color)
+			bookmark.Color = &color
+		}
+	}
+	if req.FolderID != nil {
+		if *req.FolderID == 0 {
+			bookmark.FolderID = nil
+			return 0, nil
+		}
+		var count int64
+		if err := appdata.DB.Model(&models.BookmarkFolder{}).Where("id = ? AND user_id = ?", *req.FolderID, bookmark.UserID).Count(&count).Error; err != nil {
+			return fiber.StatusInternalServerError, errors.New(models.NewInternalError().Error)
+		}
+		if count == 0 {
+			return fiber.StatusBadRequest, errors.New("Folder not found")
+		}
+		folderID := *req.FolderID
+		bookmark.FolderID = &folderID
+	}
+	return 0, nil
+}
+
+// escapeLike escapes the wildcards of LIKE patterns in user input
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
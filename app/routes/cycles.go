@@ -0,0 +1,174 @@
+package routes
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"users-api/app/appdata"
+	"users-api/app/models"
+	"users-api/app/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// GetReadingCycles godoc
+// @Summary      List reading cycles
+// @Description  Returns every read-through of the Bible of the user, oldest first, with when it started, ended and was finished and how far it got
+// @Tags         read_history
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {array}  models.ReadingCycleSummary
+// @Failure      401  {object}  models.ErrorResponse
+// @Router       /cycles [get]
+func GetReadingCycles(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	if _, err := utils.CurrentCycle(userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	var cycles []models.ReadingCycle
+	if err := appdata.DB.Where("user_id = ?", userID).Order("started_at, id").Find(&cycles).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	var histories []models.ReadHistory
+	if err := appdata.DB.Where("user_id = ?", userID).Find(&histories).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+
+	type cycleTotals struct {
+		chapters, verses uint
+		lastRead         time.Time
+	}
+	totals := make(map[uint]*cycleTotals, len(cycles))
+	for i := range cycles {
+		totals[cycles[i].ID] = &cycleTotals{}
+	}
+	for i := range histories {
+		total, ok := totals[histories[i].CycleID]
+		verses := utils.VersesOfChapter(histories[i].Book, histories[i].Chapter)
+		if !ok || verses == 0 {
+			continue
+		}
+		total.chapters++
+		total.verses += verses
+		if histories[i].CreatedAt.After(total.lastRead) {
+			total.lastRead = histories[i].CreatedAt
+		}
+	}
+
+	var bibleChapters, bibleVerses uint
+	for _, book := range appdata.Books {
+		bibleChapters += book.Chapters
+		bibleVerses += book.Verses
+	}
+	summaries := make([]models.ReadingCycleSummary, 0, len(cycles))
+	for _, cycle := range cycles {
+		total := totals[cycle.ID]
+		summary := models.ReadingCycleSummary{
+			ID:           cycle.ID,
+			Name:         cycle.Name,
+			Current:      cycle.EndedAt == nil,
+			StartedAt:    cycle.StartedAt,
+			EndedAt:      cycle.EndedAt,
+			ReadProgress: utils.NewReadProgress(bibleChapters, total.chapters, bibleVerses, total.verses),
+		}
+		if total.chapters == bibleChapters {
+			finishedAt := total.lastRead
+			summary.FinishedAt = &finishedAt
+		}
+		summaries = append(summaries, summary)
+	}
+	return c.JSON(summaries)
+}
+
+// StartReadingCycle godoc
+// @Summary      Start a new reading cycle
+// @Description  Ends the current read-through of the Bible and starts a new one with nothing read. The history of the ended cycle is kept and can still be queried with its ID.
+// @Tags         read_history
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        cycle  body  models.StartCycleRequest  false  "Name of the cycle"
+// @Success      201  {object}  models.ReadingCycle
+// @Failure      400  {object}  models.ErrorResponse
+// @Router       /cycles [post]
+func StartReadingCycle(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	var req models.StartCycleRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+		}
+	}
+	cycle, err := utils.StartCycle(userID, strings.TrimSpace(req.Name))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.Status(fiber.StatusCreated).JSON(cycle)
+}
+
+// RenameReadingCycle godoc
+// @Summary      Rename a reading cycle
+// @Tags         read_history
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id     path  int                       true  "Cycle ID"
+// @Param        cycle  body  models.StartCycleRequest  true  "New name"
+// @Success      200  {object}  models.ReadingCycle
+// @Failure      400  {object}  models.ErrorResponse
+// @Failure      404  {object}  models.ErrorResponse
+// @Router       /cycles/{id} [patch]
+func RenameReadingCycle(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	var req models.StartCycleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Name the cycle"})
+	}
+	cycleID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Wrong cycle id"})
+	}
+	var cycle models.ReadingCycle
+	result := appdata.DB.Where("id = ? AND user_id = ?", cycleID, userID).First(&cycle)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{Error: "Cycle not found"})
+	} else if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	cycle.Name = req.Name
+	if err := appdata.DB.Model(&cycle).Update("name", cycle.Name).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.JSON(cycle)
+}
+
+// requestedCycle returns the cycle of the user given in the cycle query
+// parameter, or their current cycle
+func requestedCycle(c *fiber.Ctx, userID uint) (models.ReadingCycle, int, error) {
+	param := c.Query("cycle")
+	if param == "" {
+		cycle, err := utils.CurrentCycle(userID)
+		if err != nil {
+			return cycle, fiber.StatusInternalServerError, errors.New(models.NewInternalError().Error)
+		}
+		return cycle, 0, nil
+	}
+	var cycle models.ReadingCycle
+	cycleID, err := strconv.Atoi(param)
+	if err != nil {
+		return cycle, fiber.StatusBadRequest, errors.New("Wrong cycle id")
+	}
+	result := appdata.DB.Where("id = ? AND user_id = ?", cycleID, userID).First(&cycle)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return cycle, fiber.StatusNotFound, errors.New("Cycle not found")
+	} else if result.Error != nil {
+		return cycle, fiber.StatusInternalServerError, errors.New(models.NewInternalError().Error)
+	}
+	return cycle, 0, nil
+}
@@ -0,0 +1,188 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"users-api/app/appdata"
+	"users-api/app/models"
+	"users-api/app/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// How long the old address can undo a change, confirmed or not
+const emailChangeUndoWindow = 7 * 24 * time.Hour
+
+// requestEmailChange records newEmail as pending and emails a confirmation
+// link to it and an undo link to the current address. An earlier change that
+// wasn't confirmed yet is replaced.
+func requestEmailChange(user *models.User, newEmail string) error {
+	confirmToken, err := utils.GenerateToken()
+	if err != nil {
+		return err
+	}
+	undoToken, err := utils.GenerateToken()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	emailChange := models.EmailChange{
+		UserID:           user.ID,
+		OldEmail:         user.Email,
+		OldEmailVerified: user.IsActivated,
+		NewEmail:         newEmail,
+		ConfirmTokenHash: utils.HashToken(confirmToken),
+		UndoTokenHash:    utils.HashToken(undoToken),
+		ExpiresAt:        now.Add(time.Duration(appdata.ResetValidMinutes) * time.Minute),
+		UndoableUntil:    now.Add(emailChangeUndoWindow),
+	}
+	appdata.DB.Where("undoable_until < ?", now).Delete(&models.EmailChange{})
+	appdata.DB.Where("user_id = ? AND confirmed_at IS NULL", user.ID).Delete(&models.EmailChange{})
+	if err := appdata.DB.Create(&emailChange).Error; err != nil {
+		return err
+	}
+
+	confirmLink := fmt.Sprintf("https://versequick.com/confirmemailchange/%s", confirmToken)
+	emailBody := fmt.Sprintf("Visit <a href=%s>%s</a> to use this address for your account. The link is valid for %d minutes.", confirmLink, confirmLink, appdata.ResetValidMinutes)
+	if err := utils.SendEmail(newEmail, "Confirm your new email", emailBody, true); err != nil {
+		return err
+	}
+	undoLink := fmt.Sprintf("https://versequick.com/undoemailchange/%s", undoToken)
+	emailBody = fmt.Sprintf("Someone asked to change the email of your account to %s. It changes once the new address is confirmed.<br><br>"+
+		"If this wasn't you, visit <a href=%s>%s</a> to keep this address and log out every device. The link works for %d days.",
+		newEmail, undoLink, undoLink, int(emailChangeUndoWindow.Hours()/24))
+	if err := utils.SendEmail(user.Email, "Your email is being changed", emailBody, true); err != nil {
+		log.Printf("failed to send email change notice to user %d: %v", user.ID, err)
+	}
+	return nil
+}
+
+// ConfirmEmailChange godoc
+// @Summary      Confirm a new email
+// @Description  Switches the account to the new address of a pending email change, using the token of the link sent to that address. The address counts as verified.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Param        token  body  models.EmailedTokenRequest  true  "Token of the confirmation link"
+// @Success      200  {object}  models.GenericMessage
+// @Failure      400  {object}  models.ErrorResponse
+// @Router       /confirmemailchange [post]
+func ConfirmEmailChange(c *fiber.Ctx) error {
+	var req models.EmailedTokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+	}
+	var emailChange models.EmailChange
+	result := appdata.DB.Where("confirm_token_hash = ? AND confirmed_at IS NULL", utils.HashToken(req.Token)).First(&emailChange)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Confirmation link invalid, change your email again to get a new one"})
+	} else if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if emailChange.ExpiresAt.Before(time.Now()) {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Confirmation link expired, change your email again to get a new one"})
+	}
+
+	err := appdata.DB.Transaction(func(tx *gorm.DB) error {
+		// Checking confirmed_at again keeps parallel requests from both confirming
+		result := tx.Model(&emailChange).Where("confirmed_at IS NULL").Update("confirmed_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		err := tx.Model(&models.User{}).Where("id = ?", emailChange.UserID).Updates(map[string]interface{}{"email": emailChange.NewEmail, "is_activated": true}).Error
+		if err != nil {
+			return err
+		}
+		return deletePendingEmailLinks(tx, emailChange.UserID)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Confirmation link invalid, change your email again to get a new one"})
+	} else if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Another account uses this email now"})
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.JSON(models.GenericMessage{Message: "Email changed successfully"})
+}
+
+// UndoEmailChange godoc
+// @Summary      Undo an email change
+// @Description  Cancels an email change with the token of the link sent to the old address. If the change was confirmed already, the old address is restored and every device is logged out.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Param        token  body  models.EmailedTokenRequest  true  "Token of the undo link"
+// @Success      200  {object}  models.GenericMessage
+// @Failure      400  {object}  models.ErrorResponse
+// @Router       /undoemailchange [post]
+func UndoEmailChange(c *fiber.Ctx) error {
+	var req models.EmailedTokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+	}
+	var emailChange models.EmailChange
+	result := appdata.DB.Where("undo_token_hash = ?", utils.HashToken(req.Token)).First(&emailChange)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Undo link invalid or used already"})
+	} else if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if emailChange.UndoableUntil.Before(time.Now()) {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Undo link expired"})
+	}
+
+	err := appdata.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&emailChange)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if emailChange.ConfirmedAt == nil {
+			return nil
+		}
+		// Later changes were made by whoever made this one
+		if err := tx.Where("user_id = ?", emailChange.UserID).Delete(&models.EmailChange{}).Error; err != nil {
+			return err
+		}
+		err := tx.Model(&models.User{}).Where("id = ?", emailChange.UserID).Updates(map[string]interface{}{"email": emailChange.OldEmail, "is_activated": emailChange.OldEmailVerified}).Error
+		if err != nil {
+			return err
+		}
+		return deletePendingEmailLinks(tx, emailChange.UserID)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Undo link invalid or used already"})
+	} else if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Another account uses the old email now"})
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if emailChange.ConfirmedAt == nil {
+		return c.JSON(models.GenericMessage{Message: "Email change cancelled"})
+	}
+
+	if err := utils.LogoutEverywhere(emailChange.UserID, ""); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	utils.RecordSecurityEvent(emailChange.UserID, models.SecurityEventEmailChangeUndone, "", c.IP(), fmt.Sprintf("restored %s, was changed to %s", emailChange.OldEmail, emailChange.NewEmail))
+	return c.JSON(models.GenericMessage{Message: "Email change undone and every device logged out. Change your password if someone else made the change."})
+}
+
+// deletePendingEmailLinks drops the emailed links of the user, which went to
+// an address the account no longer uses
+func deletePendingEmailLinks(tx *gorm.DB, userID uint) error {
+	for _, model := range []interface{}{&models.ForgotPassword{}, &models.VerifyEmail{}, &models.MagicLink{}} {
+		if err := tx.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
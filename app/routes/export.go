@@ -0,0 +1,114 @@
+package routes
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+	"users-api/app/appdata"
+	"users-api/app/models"
+	"users-api/app/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const dataExportFileName = "versequick-export.zip"
+
+// ExportData godoc
+// @Summary      Export personal data
+// @Description  Returns a ZIP archive of everything stored about the user: profile and preferences, reading history, bookmarks, notes, parallel translations and active sessions, as JSON and CSV. Large accounts get 202 instead and a download link by email once the archive is ready. Until that link expires, asking again doesn't start another export.
+// @Tags         users
+// @Produce      application/zip
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {file}    file
+// @Success      202  {object}  models.GenericMessage
+// @Failure      401  {object}  models.ErrorResponse
+// @Router       /me/export [get]
+func ExportData(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	rows, err := utils.CountDataExportRows(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+
+	if rows <= utils.DataExportInlineRows {
+		var archive bytes.Buffer
+		if err := utils.WriteDataExport(userID, &archive); err != nil {
+			log.Printf("failed to export data of user %d: %v", userID, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+		}
+		c.Attachment(dataExportFileName)
+		return c.Send(archive.Bytes())
+	}
+
+	var user models.User
+	if err := appdata.DB.First(&user, userID).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	claimed, existing, err := utils.ClaimDataExport(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if !claimed {
+		if existing.ReadyAt == nil {
+			return c.Status(fiber.StatusAccepted).JSON(models.GenericMessage{Message: "Your export is already being prepared, we'll email you a download link when it's ready"})
+		}
+		return c.Status(fiber.StatusAccepted).JSON(models.GenericMessage{Message: "We've recently emailed you a download link to your export, it's valid until " + existing.ReadyAt.Add(time.Duration(appdata.DataExportValidMinutes)*time.Minute).UTC().Format(time.RFC3339)})
+	}
+	go emailDataExport(user)
+	return c.Status(fiber.StatusAccepted).JSON(models.GenericMessage{Message: "Your export is being prepared, we'll email you a download link when it's ready"})
+}
+
+// emailDataExport builds the export of a large account in the background and
+// emails the user a signed link to it
+func emailDataExport(user models.User) {
+	file, err := utils.SaveDataExport(user.ID)
+	if err != nil {
+		log.Printf("failed to export data of user %d: %v", user.ID, err)
+		utils.FinishDataExport(user.ID, "")
+		return
+	}
+	token := utils.PrepareDataExportToken(&user, file)
+	if token == "" {
+		log.Printf("failed to sign data export link of user %d", user.ID)
+		utils.FinishDataExport(user.ID, "")
+		return
+	}
+	if err := utils.FinishDataExport(user.ID, file); err != nil {
+		log.Printf("failed to record data export of user %d: %v", user.ID, err)
+	}
+	downloadLink := fmt.Sprintf("https://versequick.com/export/%s", token)
+	emailBody := fmt.Sprintf("The copy of your data you asked for is ready. Download it from <a href=%s>%s</a>, the link is valid for %d minutes.",
+		downloadLink, downloadLink, appdata.DataExportValidMinutes)
+	if err := utils.SendEmail(user.Email, "Your data export is ready", emailBody, true); err != nil {
+		log.Printf("failed to send data export email to user %d: %v", user.ID, err)
+	}
+}
+
+// DownloadDataExport godoc
+// @Summary      Download a data export
+// @Description  Downloads an export prepared by /me/export, using the token of the emailed link. The token expires and stops working when the user logs out everywhere.
+// @Tags         users
+// @Produce      application/zip
+// @Produce      json
+// @Param        token  query  string  true  "Token of the download link"
+// @Success      200  {file}    file
+// @Failure      400  {object}  models.ErrorResponse
+// @Router       /me/export/download [get]
+func DownloadDataExport(c *fiber.Ctx) error {
+	userID, name, err := utils.ParseDataExportToken(c.Query("token"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Download link invalid or expired, request a new export"})
+	}
+	file, err := utils.OpenDataExport(userID, name)
+	if errors.Is(err, os.ErrNotExist) {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Download link invalid or expired, request a new export"})
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	c.Attachment(dataExportFileName)
+	return c.SendStream(file)
+}
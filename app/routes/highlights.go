@@ -0,0 +1,313 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"users-api/app/appdata"
+	"users-api/app/models"
+	"users-api/app/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Most ranges one bulk request may have
+const highlightMaxRanges = 500
+
+// GetHighlights godoc
+// @Summary      Get highlights
+// @Description  Returns the highlights of the user, of a book if one is given. With a chapter, returns the highlights covering it and every highlighted verse of the chapter with its colour and style, ready for rendering.
+// @Tags         highlights
+// @Produce      json
+// @Security     BearerAuth
+// @Param        book     query  string  false  "Book name or abbreviation like GEN"
+// @Param        chapter  query  int     false  "Chapter of the book"
+// @Success      200  {object}  models.HighlightsResponse
+// @Failure      400  {object}  models.ErrorResponse
+// @Router       /highlights [get]
+func GetHighlights(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	query := appdata.DB.Where("user_id = ?", userID)
+	var book int
+	chapter := uint(c.QueryInt("chapter"))
+	if name := c.Query("book"); name != "" {
+		var ok bool
+		if book, ok = utils.FindBook(name); !ok {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Invalid book"})
+		}
+		query = query.Where("book = ?", book+1)
+		if chapter != 0 {
+			if chapter > appdata.Books[book].Chapters {
+				return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Invalid chapter number"})
+			}
+			query = query.Where("chapter <= ? AND end_chapter >= ?", chapter, chapter)
+		}
+	} else if chapter != 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Give the book of the chapter"})
+	}
+
+	response := models.HighlightsResponse{Highlights: []models.Highlight{}}
+	if err := query.Order("book, chapter, verse").Find(&response.Highlights).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if chapter == 0 {
+		return c.JSON(response)
+	}
+	response.Verses = []models.VerseHighlight{}
+	for _, highlight := range response.Highlights {
+		first, last := uint(1), appdata.ChapterVerses[book][chapter-1]
+		if highlight.Chapter == chapter {
+			first = highlight.Verse
+		}
+		if highlight.EndChapter == chapter {
+			last = highlight.EndVerse
+		}
+		for verse := first; verse <= last; verse++ {
+			response.Verses = append(response.Verses, models.VerseHighlight{Verse: verse, HighlightID: highlight.ID, Color: highlight.Color, Style: highlight.Style})
+		}
+	}
+	return c.JSON(response)
+}
+
+// CreateHighlight godoc
+// @Summary      Highlight verses
+// @Description  Highlights a range of verses. Verses of the range that were highlighted already take the new colour and style; highlights reaching beyond the range keep their other verses.
+// @Tags         highlights
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        highlight  body  models.HighlightRequest  true  "Range, colour and style"
+// @Success      201  {object}  models.Highlight
+// @Failure      400  {object}  models.ErrorResponse
+// @Router       /highlights [post]
+func CreateHighlight(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	var req models.HighlightRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+	}
+	highlight, err := newHighlight(userID, req.VerseRange)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: err.Error()})
+	}
+	if highlight.Color, highlight.Style, err = highlightLook(req.Color, req.Style); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: err.Error()})
+	}
+	if err := appdata.DB.Transaction(func(tx *gorm.DB) error {
+		return utils.ApplyHighlight(tx, &highlight)
+	}); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.Status(fiber.StatusCreated).JSON(highlight)
+}
+
+// ApplyHighlights godoc
+// @Summary      Highlight several ranges
+// @Description  Highlights every range with the same colour and style in one transaction, like POST /highlights for each. The ranges must not overlap.
+// @Tags         highlights
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        highlights  body  models.BulkHighlightRequest  true  "Ranges, colour and style"
+// @Success      201  {array}  models.Highlight
+// @Failure      400  {object}  models.ErrorResponse
+// @Router       /highlights/apply [post]
+func ApplyHighlights(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	var req models.BulkHighlightRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+	}
+	if len(req.Ranges) == 0 || len(req.Ranges) > highlightMaxRanges {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: fmt.Sprintf("Send 1 to %d ranges", highlightMaxRanges)})
+	}
+	color, style, err := highlightLook(req.Color, req.Style)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: err.Error()})
+	}
+	highlights := make([]models.Highlight, 0, len(req.Ranges))
+	for i, verseRange := range req.Ranges {
+		highlight, err := newHighlight(userID, verseRange)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: fmt.Sprintf("ranges[%d]: %v", i, err)})
+		}
+		highlight.Color, highlight.Style = color, style
+		highlights = append(highlights, highlight)
+	}
+	// A later range would cut into an earlier one, which is then no longer
+	// what gets returned
+	for i := range highlights {
+		for j := i + 1; j < len(highlights); j++ {
+			if utils.HighlightsOverlap(highlights[i], highlights[j]) {
+				return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: fmt.Sprintf("ranges[%d] and ranges[%d] overlap, join them into one range", i, j)})
+			}
+		}
+	}
+	err = appdata.DB.Transaction(func(tx *gorm.DB) error {
+		for i := range highlights {
+			if err := utils.ApplyHighlight(tx, &highlights[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.Status(fiber.StatusCreated).JSON(highlights)
+}
+
+// ClearHighlights godoc
+// @Summary      Clear highlights of ranges
+// @Description  Removes the highlighting of every verse in the ranges. Highlights reaching beyond a range keep their other verses. Colour and style are ignored.
+// @Tags         highlights
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        highlights  body  models.BulkHighlightRequest  true  "Ranges to clear"
+// @Success      200  {object}  models.GenericMessage
+// @Failure      400  {object}  models.ErrorResponse
+// @Router       /highlights/clear [post]
+func ClearHighlights(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	var req models.BulkHighlightRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+	}
+	if len(req.Ranges) == 0 || len(req.Ranges) > highlightMaxRanges {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: fmt.Sprintf("Send 1 to %d ranges", highlightMaxRanges)})
+	}
+	ranges := make([]models.Highlight, 0, len(req.Ranges))
+	for i, verseRange := range req.Ranges {
+		highlight, err := newHighlight(userID, verseRange)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: fmt.Sprintf("ranges[%d]: %v", i, err)})
+		}
+		ranges = append(ranges, highlight)
+	}
+	err := appdata.DB.Transaction(func(tx *gorm.DB) error {
+		for _, r := range ranges {
+			if err := utils.ClearHighlights(tx, userID, r.Book, r.Chapter, r.Verse, r.EndChapter, r.EndVerse); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.JSON(models.GenericMessage{Message: "Highlights cleared"})
+}
+
+// UpdateHighlight godoc
+// @Summary      Change a highlight
+// @Description  Changes the colour or style of a highlight
+// @Tags         highlights
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id         path  int                            true  "Highlight ID"
+// @Param        highlight  body  models.UpdateHighlightRequest  true  "Colour and style"
+// @Success      200  {object}  models.Highlight
+// @Failure      400  {object}  models.ErrorResponse
+// @Failure      404  {object}  models.ErrorResponse
+// @Router       /highlights/{id} [put]
+func UpdateHighlight(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	var req models.UpdateHighlightRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+	}
+	highlightID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Wrong highlight id"})
+	}
+	var highlight models.Highlight
+	result := appdata.DB.Where("id = ? AND user_id = ?", highlightID, userID).First(&highlight)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{Error: "Highlight not found"})
+	} else if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	color, style := highlight.Color, highlight.Style
+	if req.Color != nil {
+		color = *req.Color
+	}
+	if req.Style != nil {
+		style = *req.Style
+	}
+	if highlight.Color, highlight.Style, err = highlightLook(color, style); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: err.Error()})
+	}
+	if err := appdata.DB.Select("color", "style", "updated_at").Updates(&highlight).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.JSON(highlight)
+}
+
+// DeleteHighlight godoc
+// @Summary      Delete a highlight
+// @Tags         highlights
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path  int  true  "Highlight ID"
+// @Success      200  {object}  models.GenericMessage
+// @Failure      404  {object}  models.ErrorResponse
+// @Router       /highlights/{id} [delete]
+func DeleteHighlight(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	highlightID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Wrong highlight id"})
+	}
+	result := appdata.DB.Where("id = ? AND user_id = ?", highlightID, userID).Delete(&models.Highlight{})
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{Error: "Highlight not found"})
+	}
+	return c.JSON(models.GenericMessage{Message: "Highlight deleted"})
+}
+
+// newHighlight checks a range and makes a highlight of it, without colour
+func newHighlight(userID uint, verseRange models.VerseRange) (models.Highlight, error) {
+	book, ok := utils.FindBook(verseRange.Book)
+	if !ok {
+		return models.Highlight{}, fmt.Errorf("invalid book %q", verseRange.Book)
+	}
+	if verseRange.EndChapter == 0 && verseRange.EndVerse == 0 {
+		verseRange.EndChapter, verseRange.EndVerse = verseRange.Chapter, verseRange.Verse
+	} else if verseRange.EndChapter == 0 {
+		verseRange.EndChapter = verseRange.Chapter
+	}
+	start := utils.VerseRef{Book: book, Chapter: verseRange.Chapter, Verse: verseRange.Verse}
+	if err := utils.ValidateVerseRange(start, verseRange.EndChapter, verseRange.EndVerse); err != nil {
+		return models.Highlight{}, err
+	}
+	return models.Highlight{
+		UserID:     userID,
+		Book:       uint(book + 1),
+		Chapter:    verseRange.Chapter,
+		Verse:      verseRange.Verse,
+		EndChapter: verseRange.EndChapter,
+		EndVerse:   verseRange.EndVerse,
+	}, nil
+}
+
+// highlightLook checks the colour and style of a highlight
+func highlightLook(color string, style string) (string, string, error) {
+	if !bookmarkColor.MatchString(color) {
+		return "", "", errors.New("colours look like #FFF176")
+	}
+	switch style {
+	case "":
+		style = utils.HighlightStyleHighlight
+	case utils.HighlightStyleHighlight, utils.HighlightStyleUnderline:
+	default:
+		return "", "", fmt.Errorf("style is %s or %s", utils.HighlightStyleHighlight, utils.HighlightStyleUnderline)
+	}
+	return strings.ToUpper(color), style, nil
+}
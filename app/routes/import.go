@@ -0,0 +1,144 @@
+package routes
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"users-api/app/appdata"
+	"users-api/app/models"
+	"users-api/app/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	importStatusImported = "imported"
+	importStatusSkipped  = "skipped"
+	importStatusRejected = "rejected"
+)
+
+// ImportData godoc
+// @Summary      Import bookmarks and notes
+// @Description  Imports bookmarks and notes exported from other Bible apps. Accepts CSV with a reference column (OSIS, USFM or "Genesis 1:1") or book, chapter and verse columns, the generic JSON schema {"bookmarks": [...], "notes": [...]}, and YouVersion style {"moments": [...]} exports. Entries that already exist are skipped, and the response reports what happened to every entry.
+// @Tags         bookmarks
+// @Accept       multipart/form-data
+// @Produce      json
+// @Security     BearerAuth
+// @Param        file    formData  file    true   "Exported file"
+// @Param        format  formData  string  false  "csv, json or youversion, guessed from the file when left out"
+// @Success      200  {object}  models.ImportResponse
+// @Failure      400  {object}  models.ErrorResponse
+// @Router       /import [post]
+func ImportData(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	header, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Upload the export in the file field"})
+	}
+	file, err := header.Open()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	format := strings.ToLower(c.FormValue("format"))
+	if format == "" {
+		format = utils.DetectImportFormat(header.Filename, data)
+	}
+	items, err := utils.ParseImport(format, data)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: fmt.Sprintf("Can't read the file: %v", err)})
+	}
+
+	var existingBookmarks []models.Bookmark
+	if err := appdata.DB.Where("user_id = ?", userID).Find(&existingBookmarks).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	var existingNotes []models.Note
+	if err := appdata.DB.Where("user_id = ?", userID).Find(&existingNotes).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	seen := make(map[string]bool, len(existingBookmarks)+len(existingNotes))
+	for _, b := range existingBookmarks {
+		if b.EndChapter == b.ChapterNumber && b.EndVerse == b.VerseNumber {
+			seen[importKey(utils.ImportKindBookmark, b.Book, b.ChapterNumber, b.VerseNumber, "")] = true
+		}
+	}
+	for _, n := range existingNotes {
+		seen[importKey(utils.ImportKindNote, n.Book, n.ChapterNumber, n.VerseNumber, n.Note)] = true
+	}
+
+	// Imported bookmarks go behind the existing ones
+	position := 0
+	for _, b := range existingBookmarks {
+		position = max(position, b.Position)
+	}
+
+	response := models.ImportResponse{Rows: make([]models.ImportRowResult, 0, len(items))}
+	var bookmarks []models.Bookmark
+	var notes []models.Note
+	for _, item := range items {
+		row := models.ImportRowResult{Location: item.Location, Kind: item.Kind, Reference: item.Reference}
+		if item.Err != nil {
+			row.Status, row.Reason = importStatusRejected, item.Err.Error()
+			response.Rejected++
+			response.Rows = append(response.Rows, row)
+			continue
+		}
+		book := item.Ref.BookName()
+		key := importKey(item.Kind, book, item.Ref.Chapter, item.Ref.Verse, item.Note)
+		if seen[key] {
+			row.Status, row.Reason = importStatusSkipped, fmt.Sprintf("%s at %s exists already", item.Kind, item.Ref)
+			response.Skipped++
+			response.Rows = append(response.Rows, row)
+			continue
+		}
+		seen[key] = true
+		if item.Kind == utils.ImportKindBookmark {
+			position++
+			bookmark := models.Bookmark{UserID: userID, Book: book, ChapterNumber: item.Ref.Chapter, VerseNumber: item.Ref.Verse, EndChapter: item.Ref.Chapter, EndVerse: item.Ref.Verse, Position: position}
+			if item.CreatedAt != nil {
+				bookmark.CreatedAt = *item.CreatedAt
+			}
+			bookmarks = append(bookmarks, bookmark)
+		} else {
+			note := models.Note{UserID: userID, Book: book, ChapterNumber: item.Ref.Chapter, VerseNumber: item.Ref.Verse, Note: item.Note, Tags: []string{}}
+			if item.CreatedAt != nil {
+				note.CreatedAt, note.UpdatedAt = *item.CreatedAt, *item.CreatedAt
+			}
+			notes = append(notes, note)
+		}
+		row.Status = importStatusImported
+		response.Imported++
+		response.Rows = append(response.Rows, row)
+	}
+
+	err = appdata.DB.Transaction(func(tx *gorm.DB) error {
+		if len(bookmarks) > 0 {
+			if err := tx.CreateInBatches(&bookmarks, 500).Error; err != nil {
+				return err
+			}
+		}
+		if len(notes) > 0 {
+			return tx.CreateInBatches(&notes, 500).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.JSON(response)
+}
+
+// importKey identifies a bookmark or a note for finding duplicates. Notes on
+// the same verse are duplicates only if their text is the same.
+func importKey(kind string, book string, chapter uint, verse uint, note string) string {
+	if kind == utils.ImportKindBookmark {
+		note = ""
+	}
+	return fmt.Sprintf("%s|%s|%d|%d|%s", kind, book, chapter, verse, strings.TrimSpace(note))
+}
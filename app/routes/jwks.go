@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"users-api/app/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetJWKS godoc
+// @Summary      Public keys for access tokens
+// @Description  Returns the JSON Web Key Set with every key that currently verifies access tokens. Tokens name their key in the kid header.
+// @Tags         auth
+// @Produce      json
+// @Success      200  {object}  utils.JWKS
+// @Router       /.well-known/jwks.json [get]
+func GetJWKS(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(utils.Keys.JWKS())
+}
@@ -0,0 +1,112 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"users-api/app/appdata"
+	"users-api/app/models"
+	"users-api/app/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// SendMagicLink godoc
+// @Summary      Email a login link
+// @Description  Emails a single use link that logs the user in without a password. The response is the same whether or not the email belongs to an account.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        email  body  models.MagicLinkRequest  true  "Email of the account"
+// @Success      200  {object}  models.GenericMessage
+// @Failure      400  {object}  models.ErrorResponse
+// @Router       /login/magiclink [post]
+func SendMagicLink(c *fiber.Ctx) error {
+	var req models.MagicLinkRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+	}
+	req.Email = strings.TrimSpace(req.Email)
+	if !utils.IsEmail(req.Email) {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Invalid email"})
+	}
+	response := models.GenericMessage{Message: fmt.Sprintf("If an account uses this email, a login link was sent to it. The link is valid for %d minutes.", appdata.MagicLinkValidMinutes)}
+
+	var user models.User
+	result := appdata.DB.Where("email = ?", req.Email).First(&user)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.JSON(response)
+	} else if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+
+	randString, err := utils.GenerateToken()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	now := time.Now()
+	magicLink := models.MagicLink{UserID: user.ID, TokenHash: utils.HashToken(randString), ExpiresAt: now.Add(time.Duration(appdata.MagicLinkValidMinutes) * time.Minute)}
+	appdata.DB.Where("expires_at < ?", now).Delete(&models.MagicLink{})
+	appdata.DB.Where("user_id = ?", user.ID).Delete(&models.MagicLink{})
+	if err := appdata.DB.Create(&magicLink).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	loginLink := fmt.Sprintf("https://versequick.com/magiclink/%s", randString)
+	emailBody := fmt.Sprintf("Visit <a href=%s>%s</a> to log in. If you didn't ask for this link, you can ignore this email.", loginLink, loginLink)
+	if err := utils.SendEmail(user.Email, "Your login link", emailBody, true); err != nil {
+		log.Printf("failed to send magic link to user %d: %v", user.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.JSON(response)
+}
+
+// LoginWithMagicLink godoc
+// @Summary      Log in with an emailed link
+// @Description  Exchanges the token of a magic link for a JWT access token and a refresh token, like /login. The link proves ownership of the email, so the email is marked verified.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        token  body  models.MagicLinkLoginRequest  true  "Magic link token and login options"
+// @Success      200  {object}  models.LoginResponse
+// @Success      202  {object}  models.MfaChallengeResponse
+// @Failure      400  {object}  models.ErrorResponse
+// @Router       /login/magiclink/verify [post]
+func LoginWithMagicLink(c *fiber.Ctx) error {
+	var req models.MagicLinkLoginRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+	}
+	var magicLink models.MagicLink
+	if err := appdata.DB.Where("token_hash = ?", utils.HashToken(req.Token)).First(&magicLink).Error; err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Login link invalid, get a new one at /login"})
+	}
+	// Deleting first makes sure a link can't be used twice by parallel requests
+	result := appdata.DB.Delete(&magicLink)
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Login link invalid, get a new one at /login"})
+	}
+	if magicLink.ExpiresAt.Before(time.Now()) {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Login link expired, get a new one at /login"})
+	}
+
+	var user models.User
+	if err := appdata.DB.First(&user, magicLink.UserID).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if !user.IsActivated {
+		user.IsActivated = true
+		if err := appdata.DB.Model(&user).Update("is_activated", true).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+		}
+		appdata.DB.Where("user_id = ?", user.ID).Delete(&models.VerifyEmail{})
+	}
+
+	loginReq := models.LoginRequest{Remember: req.Remember, Device: req.Device, Location: req.Location}
+	return completeLogin(c, &user, &loginReq)
+}
@@ -0,0 +1,315 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"users-api/app/appdata"
+	"users-api/app/models"
+	"users-api/app/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const recoveryCodeCount = 10
+
+// After maxMfaAttempts wrong codes in a row the second factor is locked for
+// mfaLockoutMinutes, so the 10^6 possible codes can't be tried out
+const maxMfaAttempts = 5
+const mfaLockoutMinutes = 15
+
+// LoginWithMfa godoc
+// @Summary      Complete a login with a second factor
+// @Description  Exchanges the MFA challenge token returned by /login and a TOTP code (or an unused recovery code) for a JWT access token and a refresh token.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        challenge  body  models.MfaLoginRequest  true  "Challenge token and code"
+// @Success      200  {object}  models.LoginResponse
+// @Failure      401  {object}  models.ErrorResponse
+// @Failure      429  {object}  models.ErrorResponse
+// @Router       /login/mfa [post]
+func LoginWithMfa(c *fiber.Ctx) error {
+	var req models.MfaLoginRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+	}
+	claims, err := utils.ParseToken(req.MfaToken, utils.TokenTypeMfa)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{Error: "MFA token invalid or expired, login again."})
+	}
+	userID := uint(claims["id"].(float64))
+
+	var user models.User
+	if err := appdata.DB.First(&user, userID).Error; err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{Error: "MFA token invalid or expired, login again."})
+	}
+	var totp models.TotpSecret
+	result := appdata.DB.Where("user_id = ? AND confirmed = ?", userID, true).First(&totp)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Two-factor authentication is not enabled"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+
+	now := time.Now()
+	if totp.FailedAttempts >= maxMfaAttempts && totp.LastFailedAt != nil {
+		unlockAt := totp.LastFailedAt.Add(mfaLockoutMinutes * time.Minute)
+		if now.Before(unlockAt) {
+			return c.Status(fiber.StatusTooManyRequests).JSON(models.ErrorResponse{Error: fmt.Sprintf("Too many wrong codes, try again in %d minutes.", int(unlockAt.Sub(now).Minutes())+1)})
+		}
+	}
+
+	accepted := false
+	if req.RecoveryCode != "" {
+		result = appdata.DB.Model(&models.RecoveryCode{}).
+			Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, utils.HashRecoveryCode(req.RecoveryCode)).
+			Update("used_at", now)
+		if result.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+		}
+		accepted = result.RowsAffected == 1
+	} else if step, ok := utils.ValidateTotp(totp.Secret, req.Code, totp.LastUsedStep); ok {
+		// Conditional update so the same code can't complete two logins at once
+		result = appdata.DB.Model(&totp).Where("last_used_step < ?", step).Update("last_used_step", step)
+		if result.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+		}
+		accepted = result.RowsAffected == 1
+	}
+
+	if !accepted {
+		appdata.DB.Model(&totp).Updates(map[string]interface{}{
+			"failed_attempts": gorm.Expr("failed_attempts + 1"),
+			"last_failed_at":  now,
+		})
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{Error: "Wrong code"})
+	}
+	appdata.DB.Model(&totp).Update("failed_attempts", 0)
+
+	loginReq := utils.LoginRequestFromMfaClaims(claims)
+	return issueTokens(c, &user, &loginReq)
+}
+
+// GetMfaStatus godoc
+// @Summary      Two-factor authentication status
+// @Description  Returns whether TOTP is enabled and how many unused recovery codes are left.
+// @Tags         mfa
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  models.MfaStatusResponse
+// @Failure      401  {object}  models.ErrorResponse
+// @Router       /mfa [get]
+func GetMfaStatus(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	var enabled, codesLeft int64
+	if err := appdata.DB.Model(&models.TotpSecret{}).Where("user_id = ? AND confirmed = ?", userID, true).Count(&enabled).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if err := appdata.DB.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&codesLeft).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.JSON(models.MfaStatusResponse{TotpEnabled: enabled > 0, RecoveryCodesLeft: int(codesLeft)})
+}
+
+// EnrollTotp godoc
+// @Summary      Start TOTP enrollment
+// @Description  Generates a new TOTP secret and returns it with an otpauth:// provisioning URI for a QR code. The secret is only used once it is confirmed with a code at /mfa/totp/confirm.
+// @Tags         mfa
+// @Produce      json
+// @Security     BearerAuth
+// @Success      201  {object}  models.TotpEnrollResponse
+// @Failure      400  {object}  models.ErrorResponse
+// @Router       /mfa/totp [post]
+func EnrollTotp(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	var user models.User
+	if err := appdata.DB.First(&user, userID).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+
+	var existing models.TotpSecret
+	result := appdata.DB.Where("user_id = ?", userID).First(&existing)
+	if result.Error == nil && existing.Confirmed {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Two-factor authentication is already enabled, disable it first"})
+	} else if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+
+	secret, err := utils.GenerateTotpSecret()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	// Starting over replaces a pending enrollment
+	existing.UserID = userID
+	existing.Secret = secret
+	existing.LastUsedStep = 0
+	existing.FailedAttempts = 0
+	if err := appdata.DB.Save(&existing).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(models.TotpEnrollResponse{
+		Secret:          secret,
+		ProvisioningURI: utils.TotpProvisioningURI(secret, appdata.TotpIssuer, user.Email),
+	})
+}
+
+// ConfirmTotp godoc
+// @Summary      Confirm TOTP enrollment
+// @Description  Enables two-factor authentication once a code from the authenticator app is verified, and returns single use recovery codes. The recovery codes are shown only this once.
+// @Tags         mfa
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        code  body  models.TotpCodeRequest  true  "Code from the authenticator app"
+// @Success      200  {object}  models.RecoveryCodesResponse
+// @Failure      400  {object}  models.ErrorResponse
+// @Router       /mfa/totp/confirm [post]
+func ConfirmTotp(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	var req models.TotpCodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+	}
+
+	var totp models.TotpSecret
+	result := appdata.DB.Where("user_id = ? AND confirmed = ?", userID, false).First(&totp)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "No pending enrollment, start one at /mfa/totp"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	step, ok := utils.ValidateTotp(totp.Secret, req.Code, totp.LastUsedStep)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Wrong code, check the time on your device"})
+	}
+
+	var codes []string
+	err := appdata.DB.Transaction(func(tx *gorm.DB) error {
+		totp.Confirmed = true
+		totp.LastUsedStep = step
+		if err := tx.Save(&totp).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.JSON(models.RecoveryCodesResponse{
+		Message:       "Two-factor authentication enabled. Store the recovery codes somewhere safe, each can be used once.",
+		RecoveryCodes: codes,
+	})
+}
+
+// DisableTotp godoc
+// @Summary      Disable TOTP
+// @Description  Turns off two-factor authentication and deletes the recovery codes. Requires the current password.
+// @Tags         mfa
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        password  body  models.PasswordRequest  true  "Current password"
+// @Success      200  {object}  models.GenericMessage
+// @Failure      400  {object}  models.ErrorResponse
+// @Router       /mfa/totp [delete]
+func DisableTotp(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	var req models.PasswordRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+	}
+	var user models.User
+	if err := appdata.DB.First(&user, userID).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if !utils.CheckPassword(req.Password, user.Password) {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Wrong password"})
+	}
+
+	err := appdata.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.TotpSecret{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.JSON(models.GenericMessage{Message: "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary      Regenerate recovery codes
+// @Description  Replaces all recovery codes with new ones. Requires a current TOTP code.
+// @Tags         mfa
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        code  body  models.TotpCodeRequest  true  "Code from the authenticator app"
+// @Success      200  {object}  models.RecoveryCodesResponse
+// @Failure      400  {object}  models.ErrorResponse
+// @Router       /mfa/recoverycodes [post]
+func RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	var req models.TotpCodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+	}
+
+	var totp models.TotpSecret
+	result := appdata.DB.Where("user_id = ? AND confirmed = ?", userID, true).First(&totp)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Two-factor authentication is not enabled"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	step, ok := utils.ValidateTotp(totp.Secret, req.Code, totp.LastUsedStep)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Wrong code"})
+	}
+
+	var codes []string
+	err := appdata.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&totp).Update("last_used_step", step).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.JSON(models.RecoveryCodesResponse{
+		Message:       "New recovery codes generated, the old ones no longer work.",
+		RecoveryCodes: codes,
+	})
+}
+
+// replaceRecoveryCodes deletes the user's recovery codes and stores new ones,
+// returning them in plain text for the one time they are shown
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	codes, err := utils.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	rows := make([]models.RecoveryCode, 0, len(codes))
+	for _, code := range codes {
+		rows = append(rows, models.RecoveryCode{UserID: userID, CodeHash: utils.HashRecoveryCode(code)})
+	}
+	if err := tx.Create(&rows).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
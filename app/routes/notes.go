@@ -1,12 +1,16 @@
 package routes
 
 import (
+	"encoding/json"
+	"errors"
 	"strconv"
+	"strings"
 	"users-api/app/appdata"
 	"users-api/app/models"
 	"users-api/app/utils"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
 func CreateNote(c *fiber.Ctx) error {
@@ -44,7 +48,13 @@ func CreateNote(c *fiber.Ctx) error {
 			"error": "Note empty",
 		})
 	}
-	note := models.Note{UserID: user_id, Book: book, ChapterNumber: uint(chapterInt), VerseNumber: uint(verseNumberInt), Note: noteString}
+	tags, err := utils.NormalizeTags(strings.Split(c.FormValue("tags"), ","))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	note := models.Note{UserID: user_id, Book: book, ChapterNumber: uint(chapterInt), VerseNumber: uint(verseNumberInt), Note: noteString, Tags: tags}
 	appdata.DB.Create(&note)
 	return c.JSON(note)
 }
@@ -90,6 +100,18 @@ func UpdateNote(c *fiber.Ctx) error {
 	noteString := c.FormValue("note")
 	if noteString != "" {
 		note.Note = noteString
+	}
+	// Tags are replaced when given, PUT /note/{noteid}/tags also clears them
+	if tagsString := c.FormValue("tags"); tagsString != "" {
+		tags, err := utils.NormalizeTags(strings.Split(tagsString, ","))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		note.Tags = tags
+	}
+	if noteString != "" || c.FormValue("tags") != "" {
 		appdata.DB.Save(&note)
 	}
 	return c.JSON(note)
@@ -130,3 +152,147 @@ func GetNotesOfUser(c *fiber.Ctx) error {
 	}
 	return c.JSON(notes)
 }
+
+// Default and largest page of search results
+const (
+	noteSearchPageSize    = 20
+	noteSearchMaxPageSize = 100
+)
+
+// SetNoteTags godoc
+// @Summary      Set the tags of a note
+// @Description  Replaces the tags of a note. Tags are lowercased; an empty list clears them.
+// @Tags         notes
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        noteid  path  int                     true  "Note ID"
+// @Param        tags    body  models.NoteTagsRequest  true  "Tags of the note"
+// @Success      200  {object}  models.Note
+// @Failure      400  {object}  models.ErrorResponse
+// @Failure      404  {object}  models.ErrorResponse
+// @Router       /note/{noteid}/tags [put]
+func SetNoteTags(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	var req models.NoteTagsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+	}
+	tags, err := utils.NormalizeTags(req.Tags)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: err.Error()})
+	}
+	noteID, err := strconv.Atoi(c.Params("noteid"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Wrong note id"})
+	}
+	var note models.Note
+	result := appdata.DB.Where("id = ? AND user_id = ?", noteID, userID).First(&note)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{Error: "Note not found or it doesn't belong to you."})
+	} else if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	note.Tags = tags
+	if err := appdata.DB.Select("tags", "updated_at").Updates(&note).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.JSON(note)
+}
+
+// GetNoteTags godoc
+// @Summary      List note tags
+// @Description  Returns the tags the user gave notes, with how many notes have each
+// @Tags         notes
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {array}  models.NoteTagCount
+// @Router       /note/tags [get]
+func GetNoteTags(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	tags := []models.NoteTagCount{}
+	err := appdata.DB.Raw(`SELECT tag, count(*) AS count FROM notes, jsonb_array_elements_text(tags) AS tag
+		WHERE user_id = ? GROUP BY tag ORDER BY tag`, userID).Scan(&tags).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.JSON(tags)
+}
+
+// SearchNotes godoc
+// @Summary      Search notes
+// @Description  Full-text search over the notes of the user. q takes web search syntax: words, "quoted phrases", or and -excluded words; the results are then ranked by relevance and come with snippets of HTML, where the note is escaped and matches are in <mark> tags. Without q the notes are listed in Bible order. The filters combine: tags keeps the notes with all the given tags, book those of a book and from and to those between two references, like from=Matthew&to=John 3 or from=GEN.1.1&to=GEN.11.9.
+// @Tags         notes
+// @Produce      json
+// @Security     BearerAuth
+// @Param        q          query  string  false  "Search text"
+// @Param        tags       query  string  false  "Comma-separated tags"
+// @Param        book       query  string  false  "Book name or abbreviation"
+// @Param        from       query  string  false  "First reference of the range"
+// @Param        to         query  string  false  "Last reference of the range"
+// @Param        page       query  int     false  "Page, from 1"
+// @Param        page_size  query  int     false  "Results per page, 20 by default and 100 at most"
+// @Success      200  {object}  models.NoteSearchResponse
+// @Failure      400  {object}  models.ErrorResponse
+// @Router       /note/search [get]
+func SearchNotes(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	page := c.QueryInt("page", 1)
+	pageSize := c.QueryInt("page_size", noteSearchPageSize)
+	if page < 1 || pageSize < 1 || pageSize > noteSearchMaxPageSize {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Pages start at 1 and have 1 to " + strconv.Itoa(noteSearchMaxPageSize) + " results"})
+	}
+	query := appdata.DB.Model(&models.Note{}).Where("user_id = ?", userID)
+
+	q := strings.TrimSpace(c.Query("q"))
+	if q != "" {
+		query = query.Where("search @@ websearch_to_tsquery(?, ?)", utils.NoteSearchConfig, q)
+	}
+	if tagsString := c.Query("tags"); tagsString != "" {
+		tags, err := utils.NormalizeTags(strings.Split(tagsString, ","))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: err.Error()})
+		}
+		if len(tags) > 0 {
+			tagsJSON, _ := json.Marshal(tags)
+			query = query.Where("tags @> ?", string(tagsJSON))
+		}
+	}
+	if name := c.Query("book"); name != "" {
+		book, ok := utils.FindBook(name)
+		if !ok {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Invalid book"})
+		}
+		query = query.Where("book = ?", appdata.Books[book].Book)
+	}
+	if from := c.Query("from"); from != "" {
+		ref, err := utils.ParseRangeBound(from, false)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "from: " + err.Error()})
+		}
+		query = query.Where("("+utils.BookOrderSQL+", chapter_number, verse_number) >= (?, ?, ?)", ref.Book+1, ref.Chapter, ref.Verse)
+	}
+	if to := c.Query("to"); to != "" {
+		ref, err := utils.ParseRangeBound(to, true)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "to: " + err.Error()})
+		}
+		query = query.Where("("+utils.BookOrderSQL+", chapter_number, verse_number) <= (?, ?, ?)", ref.Book+1, ref.Chapter, ref.Verse)
+	}
+
+	response := models.NoteSearchResponse{Page: page, PageSize: pageSize, Results: []models.NoteSearchResult{}}
+	if err := query.Count(&response.Total).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if q != "" {
+		query = query.Select("notes.*, ts_rank(search, websearch_to_tsquery(?, ?)) AS rank, ts_headline(?, "+utils.NoteHTMLSQL+", websearch_to_tsquery(?, ?), ?) AS snippet",
+			utils.NoteSearchConfig, q, utils.NoteSearchConfig, utils.NoteSearchConfig, q, "StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=30, MinWords=10").
+			Order("rank DESC, updated_at DESC")
+	} else {
+		query = query.Order(utils.BookOrderSQL + ", chapter_number, verse_number, created_at")
+	}
+	if err := query.Offset((page - 1) * pageSize).Limit(pageSize).Scan(&response.Results).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.JSON(response)
+}
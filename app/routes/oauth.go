@@ -0,0 +1,326 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/mail"
+	"sort"
+	"strconv"
+	"time"
+	"users-api/app/appdata"
+	"users-api/app/models"
+	"users-api/app/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const oauthStateMinutes = 10
+
+// GetOAuthProviders godoc
+// @Summary      List social login providers
+// @Description  Returns the names of the configured external login providers.
+// @Tags         oauth
+// @Produce      json
+// @Success      200  {array}  string
+// @Router       /oauth/providers [get]
+func GetOAuthProviders(c *fiber.Ctx) error {
+	names := make([]string, 0, len(utils.OAuthProviders))
+	for name := range utils.OAuthProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return c.JSON(names)
+}
+
+// BeginOAuthLogin godoc
+// @Summary      Start a social login
+// @Description  Returns the provider's authorization URL to redirect the user to. The provider redirects back with a code and the state, which are sent to /oauth/{provider}/finish.
+// @Tags         oauth
+// @Produce      json
+// @Param        provider  path  string  true  "Provider name"
+// @Success      200  {object}  models.OAuthBeginResponse
+// @Failure      404  {object}  models.ErrorResponse
+// @Router       /oauth/{provider}/begin [post]
+func BeginOAuthLogin(c *fiber.Ctx) error {
+	return beginOAuth(c, nil)
+}
+
+// FinishOAuthLogin godoc
+// @Summary      Finish a social login
+// @Description  Redeems the code from the provider and returns a JWT access token and a refresh token, like /login. An unknown identity is linked to the account with the same email if both the provider and the account verified it, or gets a new account with a generated username when no account has that email. The provider has to have verified the email.
+// @Tags         oauth
+// @Accept       json
+// @Produce      json
+// @Param        provider  path  string                     true  "Provider name"
+// @Param        result    body  models.OAuthFinishRequest  true  "Code, state and login options"
+// @Success      200  {object}  models.LoginResponse
+// @Success      202  {object}  models.MfaChallengeResponse
+// @Failure      400  {object}  models.ErrorResponse
+// @Failure      409  {object}  models.ErrorResponse
+// @Router       /oauth/{provider}/finish [post]
+func FinishOAuthLogin(c *fiber.Ctx) error {
+	var req models.OAuthFinishRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+	}
+	state, identity, err := finishOAuth(c, &req)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: err.Error()})
+	}
+	if state.UserID != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Login expired or not found, start again"})
+	}
+
+	var user models.User
+	var linked models.OAuthIdentity
+	result := appdata.DB.Where("provider = ? AND subject = ?", state.Provider, identity.Subject).First(&linked)
+	if result.Error == nil {
+		if err := appdata.DB.First(&user, linked.UserID).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+		}
+		now := time.Now()
+		appdata.DB.Model(&linked).Updates(models.OAuthIdentity{Email: identity.Email, LastLoginAt: &now})
+	} else if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	} else {
+		if identity.Email == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "The provider didn't share an email address"})
+		}
+		result = appdata.DB.Where("email = ?", identity.Email).First(&user)
+		if result.Error == nil && (!identity.EmailVerified || !user.IsActivated) {
+			// The email has to be verified on both sides. Anyone can sign up
+			// with an address they don't own, so an unverified account could
+			// belong to someone waiting for the real owner to link it.
+			return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse{Error: "An account with this email already exists, log in and link the provider from your settings"})
+		} else if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			// A new account owns its email, so it has to be proven first
+			if !identity.EmailVerified {
+				return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Verify your email address with the provider before signing up with it"})
+			}
+			if err := createOAuthUser(&user, identity); err != nil {
+				if errors.Is(err, errOAuthEmailTaken) {
+					return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse{Error: "An account with this email already exists, log in and link the provider from your settings"})
+				}
+				return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+			}
+		} else if result.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+		}
+		now := time.Now()
+		linked = models.OAuthIdentity{UserID: user.ID, Provider: state.Provider, Subject: identity.Subject, Email: identity.Email, LastLoginAt: &now}
+		if err := appdata.DB.Create(&linked).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+		}
+	}
+
+	loginReq := models.LoginRequest{Remember: req.Remember, Device: req.Device, Location: req.Location}
+	return completeLogin(c, &user, &loginReq)
+}
+
+// BeginOAuthLink godoc
+// @Summary      Start linking a provider
+// @Description  Like /oauth/{provider}/begin, but the identity is linked to the logged in user. Send the result to /oauth/identities/{provider}/finish.
+// @Tags         oauth
+// @Produce      json
+// @Security     BearerAuth
+// @Param        provider  path  string  true  "Provider name"
+// @Success      200  {object}  models.OAuthBeginResponse
+// @Failure      404  {object}  models.ErrorResponse
+// @Router       /oauth/identities/{provider}/begin [post]
+func BeginOAuthLink(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	return beginOAuth(c, &userID)
+}
+
+// FinishOAuthLink godoc
+// @Summary      Finish linking a provider
+// @Description  Redeems the code from the provider and links the identity to the logged in user.
+// @Tags         oauth
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        provider  path  string                     true  "Provider name"
+// @Param        result    body  models.OAuthFinishRequest  true  "Code and state"
+// @Success      201  {object}  models.OAuthIdentity
+// @Failure      400  {object}  models.ErrorResponse
+// @Failure      409  {object}  models.ErrorResponse
+// @Router       /oauth/identities/{provider}/finish [post]
+func FinishOAuthLink(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	var req models.OAuthFinishRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+	}
+	state, identity, err := finishOAuth(c, &req)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: err.Error()})
+	}
+	if state.UserID == nil || *state.UserID != userID {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Linking expired or not found, start again"})
+	}
+	linked := models.OAuthIdentity{UserID: userID, Provider: state.Provider, Subject: identity.Subject, Email: identity.Email}
+	if err := appdata.DB.Create(&linked).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse{Error: "This account is already linked to a user"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.Status(fiber.StatusCreated).JSON(linked)
+}
+
+// GetOAuthIdentities godoc
+// @Summary      List linked providers
+// @Description  Returns the external accounts linked to the user.
+// @Tags         oauth
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {array}   models.OAuthIdentity
+// @Failure      401  {object}  models.ErrorResponse
+// @Router       /oauth/identities [get]
+func GetOAuthIdentities(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	var identities []models.OAuthIdentity
+	if err := appdata.DB.Where("user_id = ?", userID).Order("created_at").Find(&identities).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.JSON(identities)
+}
+
+// DeleteOAuthIdentity godoc
+// @Summary      Unlink a provider
+// @Description  Removes a linked external account. The last way to log in can't be removed, set a password first.
+// @Tags         oauth
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path  int  true  "Identity ID"
+// @Success      200  {object}  models.GenericMessage
+// @Failure      400  {object}  models.ErrorResponse
+// @Failure      404  {object}  models.ErrorResponse
+// @Router       /oauth/identities/{id} [delete]
+func DeleteOAuthIdentity(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Invalid identity id"})
+	}
+	var user models.User
+	if err := appdata.DB.First(&user, userID).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if user.Password == "" {
+		var identities, passkeys int64
+		appdata.DB.Model(&models.OAuthIdentity{}).Where("user_id = ?", userID).Count(&identities)
+		appdata.DB.Model(&models.WebAuthnCredential{}).Where("user_id = ?", userID).Count(&passkeys)
+		if identities <= 1 && passkeys == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "This is your only way to log in, set a password before unlinking it"})
+		}
+	}
+	result := appdata.DB.Where("id = ? AND user_id = ?", id, userID).Delete(&models.OAuthIdentity{})
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{Error: "Linked account not found"})
+	}
+	return c.JSON(models.GenericMessage{Message: "Account unlinked"})
+}
+
+func beginOAuth(c *fiber.Ctx, userID *uint) error {
+	provider, ok := utils.OAuthProviders[c.Params("provider")]
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{Error: "Unknown provider"})
+	}
+	state, err1 := utils.GenerateToken()
+	verifier, err2 := utils.GenerateToken()
+	nonce, err3 := utils.GenerateToken()
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	now := time.Now()
+	appdata.DB.Where("expires_at < ?", now).Delete(&models.OAuthState{})
+	pending := models.OAuthState{
+		State:        state,
+		Provider:     provider.Name,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		UserID:       userID,
+		ExpiresAt:    now.Add(oauthStateMinutes * time.Minute),
+	}
+	if err := appdata.DB.Create(&pending).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.JSON(models.OAuthBeginResponse{AuthorizationURL: provider.AuthCodeURL(state, verifier, nonce), State: state})
+}
+
+// finishOAuth consumes the pending state and redeems the code. Errors are
+// meant to be shown to the user.
+func finishOAuth(c *fiber.Ctx, req *models.OAuthFinishRequest) (*models.OAuthState, *utils.ExternalIdentity, error) {
+	provider, ok := utils.OAuthProviders[c.Params("provider")]
+	if !ok {
+		return nil, nil, errors.New("Unknown provider")
+	}
+	var state models.OAuthState
+	if err := appdata.DB.Where("state = ? AND provider = ?", req.State, provider.Name).First(&state).Error; err != nil {
+		return nil, nil, errors.New("Login expired or not found, start again")
+	}
+	if result := appdata.DB.Delete(&state); result.Error != nil || result.RowsAffected == 0 || state.ExpiresAt.Before(time.Now()) {
+		return nil, nil, errors.New("Login expired or not found, start again")
+	}
+	ctx, cancel := context.WithTimeout(c.UserContext(), 10*time.Second)
+	defer cancel()
+	identity, err := provider.Exchange(ctx, req.Code, state.CodeVerifier, state.Nonce)
+	if err != nil {
+		log.Printf("OAuth exchange with %s failed: %v", provider.Name, err)
+		return nil, nil, errors.New("The provider rejected the login, try again")
+	}
+	return &state, identity, nil
+}
+
+// oauthUsernameAttempts is how many usernames are tried for a new account. The
+// suffix gets longer halfway so that a crowded base name can't run out.
+const oauthUsernameAttempts = 10
+
+var errOAuthEmailTaken = errors.New("email already belongs to an account")
+
+// createOAuthUser creates an account for a new external identity with a
+// verified email. It has no password until the user sets one through the
+// forgot password flow.
+func createOAuthUser(user *models.User, identity *utils.ExternalIdentity) error {
+	address, err := mail.ParseAddress(identity.Email)
+	if err != nil {
+		return err
+	}
+	base := utils.UsernameBase(identity)
+	username := base
+	for attempt := 1; ; attempt++ {
+		*user = models.User{
+			Email:       address.Address,
+			Username:    username,
+			Name:        identity.Name,
+			PhotoUrl:    utils.GetAvatarURL(address.Address, identity.Name),
+			IsActivated: true,
+		}
+		err := appdata.DB.Create(user).Error
+		if !errors.Is(err, gorm.ErrDuplicatedKey) {
+			return err
+		}
+		// The email may have been taken since it was looked up
+		var taken int64
+		if err := appdata.DB.Model(&models.User{}).Where("email = ?", address.Address).Count(&taken).Error; err != nil {
+			return err
+		}
+		if taken > 0 {
+			return errOAuthEmailTaken
+		}
+		if attempt == oauthUsernameAttempts {
+			return err
+		}
+		if attempt < oauthUsernameAttempts/2 {
+			username = base + "_" + utils.GenerateNumeric(4)
+		} else {
+			username = base + "_" + utils.GenerateNumeric(8)
+		}
+	}
+}
@@ -0,0 +1,441 @@
+package routes
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"users-api/app/appdata"
+	"users-api/app/models"
+	"users-api/app/utils"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/coreos/go-oidc/v3/oidc/oidctest"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	testOAuthProvider = "fakeoidc"
+	testOAuthClientID = "users-api"
+	testOAuthKeyID    = "test-key"
+)
+
+// fakeIssuer is a local OpenID Connect provider. oidctest serves discovery
+// and the JWKS; the token endpoint is added here. Codes come from authorize
+// in place of a browser visiting the authorization endpoint.
+type fakeIssuer struct {
+	*httptest.Server
+	key   *rsa.PrivateKey
+	oidc  *oidctest.Server
+	mu    sync.Mutex
+	codes map[string]fakeGrant
+}
+
+type fakeGrant struct {
+	challenge string
+	idToken   string
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &fakeIssuer{
+		key: key,
+		oidc: &oidctest.Server{
+			PublicKeys: []oidctest.PublicKey{{PublicKey: key.Public(), KeyID: testOAuthKeyID, Algorithm: oidc.RS256}},
+		},
+		codes: map[string]fakeGrant{},
+	}
+	issuer.Server = httptest.NewServer(issuer)
+	issuer.oidc.SetIssuer(issuer.URL)
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+func (f *fakeIssuer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/token" {
+		f.oidc.ServeHTTP(w, r)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	clientID, _, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+	}
+	f.mu.Lock()
+	grant, found := f.codes[r.PostForm.Get("code")]
+	delete(f.codes, r.PostForm.Get("code"))
+	f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if !found || clientID != testOAuthClientID || r.PostForm.Get("grant_type") != "authorization_code" || pkceChallenge(r.PostForm.Get("code_verifier")) != grant.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "access-" + grant.challenge,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     grant.idToken,
+	})
+}
+
+// authorize approves the authorization request in authURL for an account with
+// the given claims and returns the code. The nonce of the request is signed
+// into the ID token unless claims sets another one.
+func (f *fakeIssuer) authorize(t *testing.T, authURL string, claims map[string]interface{}) string {
+	t.Helper()
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("client_id") != testOAuthClientID {
+		t.Fatalf("unexpected authorization request %s", authURL)
+	}
+	idClaims := map[string]interface{}{
+		"iss":   f.URL,
+		"aud":   testOAuthClientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": query.Get("nonce"),
+	}
+	for name, value := range claims {
+		idClaims[name] = value
+	}
+	rawClaims, err := json.Marshal(idClaims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := randomTestString(t)
+	f.mu.Lock()
+	f.codes[code] = fakeGrant{
+		challenge: query.Get("code_challenge"),
+		idToken:   oidctest.SignIDToken(f.key, testOAuthKeyID, oidc.RS256, string(rawClaims)),
+	}
+	f.mu.Unlock()
+	return code
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func randomTestString(t *testing.T) string {
+	t.Helper()
+	value, err := utils.GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func newTestOAuthProvider(t *testing.T, issuer *fakeIssuer) *utils.OAuthProvider {
+	t.Helper()
+	provider, err := utils.NewOAuthProvider(context.Background(), testOAuthProvider, testOAuthClientID, "secret", issuer.URL, "https://versequick.com/oauth/callback/"+testOAuthProvider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func TestOAuthExchange(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	provider := newTestOAuthProvider(t, issuer)
+	verifier, nonce := randomTestString(t), randomTestString(t)
+	code := issuer.authorize(t, provider.AuthCodeURL("state", verifier, nonce), map[string]interface{}{
+		"sub":                "subject-1",
+		"email":              "reader@example.com",
+		"email_verified":     true,
+		"name":               "Reader",
+		"preferred_username": "reader",
+	})
+
+	identity, err := provider.Exchange(context.Background(), code, verifier, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := utils.ExternalIdentity{Subject: "subject-1", Email: "reader@example.com", EmailVerified: true, Name: "Reader", Username: "reader"}
+	if *identity != want {
+		t.Errorf("got identity %+v, want %+v", *identity, want)
+	}
+}
+
+func TestOAuthExchangeRejectsWrongVerifier(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	provider := newTestOAuthProvider(t, issuer)
+	nonce := randomTestString(t)
+	code := issuer.authorize(t, provider.AuthCodeURL("state", randomTestString(t), nonce), map[string]interface{}{"sub": "subject-1"})
+
+	if _, err := provider.Exchange(context.Background(), code, randomTestString(t), nonce); err == nil {
+		t.Error("code was redeemed with another PKCE verifier")
+	}
+}
+
+func TestOAuthExchangeRejectsWrongNonce(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	provider := newTestOAuthProvider(t, issuer)
+	verifier := randomTestString(t)
+	code := issuer.authorize(t, provider.AuthCodeURL("state", verifier, randomTestString(t)), map[string]interface{}{"sub": "subject-1", "nonce": "replayed"})
+
+	if _, err := provider.Exchange(context.Background(), code, verifier, randomTestString(t)); err == nil {
+		t.Error("ID token with another nonce was accepted")
+	}
+}
+
+var setupOAuthDB sync.Once
+
+// oauthTestApp serves the social login routes against the database in
+// TEST_DATABASE_URL and the fake issuer. The tests are skipped without one.
+func oauthTestApp(t *testing.T) (*fiber.App, *fakeIssuer) {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	setupOAuthDB.Do(func() {
+		db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, model := range []interface{}{&models.User{}, &models.RefreshToken{}, &models.TotpSecret{}, &models.AccountDeletion{}, &models.OAuthIdentity{}, &models.OAuthState{}} {
+			if err := db.AutoMigrate(model); err != nil {
+				t.Fatal(err)
+			}
+		}
+		appdata.DB = db
+		appdata.JwtExpiryMinutes, appdata.JwtExpiryNoRemember = 15, 15
+		appdata.RefreshExpiryMinutes, appdata.RefreshExpiryNoRemember = 60, 60
+		keysDir, err := os.MkdirTemp("", "users-api-test-keys")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if utils.Keys, err = utils.LoadKeyRing(keysDir, "RS256", time.Hour, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if appdata.DB == nil {
+		t.Fatal("test database setup failed")
+	}
+
+	issuer := newFakeIssuer(t)
+	utils.OAuthProviders[testOAuthProvider] = newTestOAuthProvider(t, issuer)
+	t.Cleanup(func() { delete(utils.OAuthProviders, testOAuthProvider) })
+	app := fiber.New()
+	app.Post("/oauth/:provider/begin", BeginOAuthLogin)
+	app.Post("/oauth/:provider/finish", FinishOAuthLogin)
+	return app, issuer
+}
+
+// testEmail returns an address no other test run uses and removes its
+// account afterwards
+func testEmail(t *testing.T) string {
+	t.Helper()
+	email := strings.ToLower(utils.GenerateAlphanumeric(12)) + "@oauth-test.example.com"
+	t.Cleanup(func() { appdata.DB.Where("email = ?", email).Delete(&models.User{}) })
+	return email
+}
+
+func createTestUser(t *testing.T, email string, username string, activated bool) models.User {
+	t.Helper()
+	user := models.User{Email: email, Username: username, Password: "not a hash", IsActivated: activated}
+	if err := appdata.DB.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func beginTestOAuthLogin(t *testing.T, app *fiber.App) models.OAuthBeginResponse {
+	t.Helper()
+	resp, err := app.Test(httptest.NewRequest(http.MethodPost, "/oauth/"+testOAuthProvider+"/begin", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("begin returned %d", resp.StatusCode)
+	}
+	var begin models.OAuthBeginResponse
+	if err := json.NewDecoder(resp.Body).Decode(&begin); err != nil {
+		t.Fatal(err)
+	}
+	return begin
+}
+
+func finishTestOAuthLogin(t *testing.T, app *fiber.App, state string, code string) (int, models.ErrorResponse) {
+	t.Helper()
+	body, _ := json.Marshal(models.OAuthFinishRequest{Code: code, State: state})
+	req := httptest.NewRequest(http.MethodPost, "/oauth/"+testOAuthProvider+"/finish", strings.NewReader(string(body)))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var errorResponse models.ErrorResponse
+	json.NewDecoder(resp.Body).Decode(&errorResponse)
+	return resp.StatusCode, errorResponse
+}
+
+// oauthLogin goes through a whole social login for an account with the given
+// claims
+func oauthLogin(t *testing.T, app *fiber.App, issuer *fakeIssuer, claims map[string]interface{}) (int, models.ErrorResponse) {
+	t.Helper()
+	begin := beginTestOAuthLogin(t, app)
+	return finishTestOAuthLogin(t, app, begin.State, issuer.authorize(t, begin.AuthorizationURL, claims))
+}
+
+func linkedUserID(t *testing.T, subject string) uint {
+	t.Helper()
+	var identity models.OAuthIdentity
+	if err := appdata.DB.Where("provider = ? AND subject = ?", testOAuthProvider, subject).First(&identity).Error; err != nil {
+		t.Fatalf("identity %s was not linked: %v", subject, err)
+	}
+	return identity.UserID
+}
+
+func TestOAuthLoginLinksVerifiedEmail(t *testing.T) {
+	app, issuer := oauthTestApp(t)
+	email := testEmail(t)
+	user := createTestUser(t, email, "u"+utils.GenerateNumeric(12), true)
+	subject := randomTestString(t)
+
+	status, body := oauthLogin(t, app, issuer, map[string]interface{}{"sub": subject, "email": email, "email_verified": true})
+	if status != http.StatusOK {
+		t.Fatalf("got %d %q, want 200", status, body.Error)
+	}
+	if linked := linkedUserID(t, subject); linked != user.ID {
+		t.Errorf("identity linked to user %d, want %d", linked, user.ID)
+	}
+	// The next login finds the identity rather than the email
+	if status, body := oauthLogin(t, app, issuer, map[string]interface{}{"sub": subject, "email": email, "email_verified": true}); status != http.StatusOK {
+		t.Errorf("second login got %d %q, want 200", status, body.Error)
+	}
+}
+
+func TestOAuthLoginRefusesUnverifiedEmailOfAccount(t *testing.T) {
+	app, issuer := oauthTestApp(t)
+	tests := []struct {
+		name             string
+		providerVerified bool
+		accountVerified  bool
+	}{
+		{"provider didn't verify", false, true},
+		{"account didn't verify", true, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			email := testEmail(t)
+			createTestUser(t, email, "u"+utils.GenerateNumeric(12), test.accountVerified)
+			subject := randomTestString(t)
+
+			status, _ := oauthLogin(t, app, issuer, map[string]interface{}{"sub": subject, "email": email, "email_verified": test.providerVerified})
+			if status != http.StatusConflict {
+				t.Errorf("got %d, want 409", status)
+			}
+			var count int64
+			appdata.DB.Model(&models.OAuthIdentity{}).Where("provider = ? AND subject = ?", testOAuthProvider, subject).Count(&count)
+			if count != 0 {
+				t.Error("identity was linked")
+			}
+		})
+	}
+}
+
+func TestOAuthLoginCreatesUser(t *testing.T) {
+	app, issuer := oauthTestApp(t)
+	email := testEmail(t)
+	base := "reader" + utils.GenerateNumeric(8)
+	subject := randomTestString(t)
+
+	status, body := oauthLogin(t, app, issuer, map[string]interface{}{"sub": subject, "email": email, "email_verified": true, "name": "New Reader", "preferred_username": strings.ToUpper(base)})
+	if status != http.StatusOK {
+		t.Fatalf("got %d %q, want 200", status, body.Error)
+	}
+	var user models.User
+	if err := appdata.DB.First(&user, linkedUserID(t, subject)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != email || user.Username != base || user.Name != "New Reader" || !user.IsActivated || user.Password != "" {
+		t.Errorf("unexpected user %+v", user)
+	}
+}
+
+func TestOAuthLoginCreatesUserWithTakenUsername(t *testing.T) {
+	app, issuer := oauthTestApp(t)
+	base := "reader" + utils.GenerateNumeric(8)
+	createTestUser(t, testEmail(t), base, true)
+	email := testEmail(t)
+	subject := randomTestString(t)
+
+	status, body := oauthLogin(t, app, issuer, map[string]interface{}{"sub": subject, "email": email, "email_verified": true, "preferred_username": base})
+	if status != http.StatusOK {
+		t.Fatalf("got %d %q, want 200", status, body.Error)
+	}
+	var user models.User
+	if err := appdata.DB.First(&user, linkedUserID(t, subject)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(user.Username, base+"_") {
+		t.Errorf("got username %q, want %s_ and a number", user.Username, base)
+	}
+}
+
+func TestOAuthLoginRefusesNewUserWithUnverifiedEmail(t *testing.T) {
+	app, issuer := oauthTestApp(t)
+	email := testEmail(t)
+
+	status, _ := oauthLogin(t, app, issuer, map[string]interface{}{"sub": randomTestString(t), "email": email, "email_verified": false})
+	if status != http.StatusBadRequest {
+		t.Errorf("got %d, want 400", status)
+	}
+	var count int64
+	appdata.DB.Model(&models.User{}).Where("email = ?", email).Count(&count)
+	if count != 0 {
+		t.Error("account was created")
+	}
+}
+
+func TestOAuthLoginRejectsWrongNonce(t *testing.T) {
+	app, issuer := oauthTestApp(t)
+	email := testEmail(t)
+
+	status, _ := oauthLogin(t, app, issuer, map[string]interface{}{"sub": randomTestString(t), "email": email, "email_verified": true, "nonce": "replayed"})
+	if status != http.StatusBadRequest {
+		t.Errorf("got %d, want 400", status)
+	}
+}
+
+func TestOAuthLoginRejectsConsumedState(t *testing.T) {
+	app, issuer := oauthTestApp(t)
+	email := testEmail(t)
+	claims := map[string]interface{}{"sub": randomTestString(t), "email": email, "email_verified": true}
+	begin := beginTestOAuthLogin(t, app)
+
+	if status, body := finishTestOAuthLogin(t, app, begin.State, issuer.authorize(t, begin.AuthorizationURL, claims)); status != http.StatusOK {
+		t.Fatalf("got %d %q, want 200", status, body.Error)
+	}
+	// A fresh code for the same authorization request must not get past the used state
+	if status, _ := finishTestOAuthLogin(t, app, begin.State, issuer.authorize(t, begin.AuthorizationURL, claims)); status != http.StatusBadRequest {
+		t.Errorf("replayed state got %d, want 400", status)
+	}
+}
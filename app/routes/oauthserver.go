@@ -0,0 +1,463 @@
+package routes
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"users-api/app/appdata"
+	"users-api/app/models"
+	"users-api/app/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// Authorization codes are exchanged by the app right after the redirect
+const oauthCodeMinutes = 1
+
+// RegisterOAuthClient godoc
+// @Summary      Register a third-party app
+// @Description  Registers an app that can ask users for access to their data. Confidential apps get a client secret, which is only shown in this response.
+// @Tags         oauth server
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        client  body  models.OAuthClientRequest  true  "App name and redirect URIs"
+// @Success      201  {object}  models.OAuthClientResponse
+// @Failure      400  {object}  models.ErrorResponse
+// @Router       /oauth/clients [post]
+func RegisterOAuthClient(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	var req models.OAuthClientRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.RedirectURIs) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Name and at least one redirect URI are required"})
+	}
+	for _, redirectURI := range req.RedirectURIs {
+		if !validRedirectURI(redirectURI) {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Invalid redirect URI " + redirectURI})
+		}
+	}
+	clientID, err := utils.GenerateToken()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	response := models.OAuthClientResponse{OAuthClient: models.OAuthClient{OwnerID: userID, ClientID: clientID, Name: req.Name, RedirectURIs: req.RedirectURIs}}
+	if req.Confidential {
+		if response.ClientSecret, err = utils.GenerateToken(); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+		}
+		response.SecretHash = utils.HashToken(response.ClientSecret)
+	}
+	if err := appdata.DB.Create(&response.OAuthClient).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.Status(fiber.StatusCreated).JSON(response)
+}
+
+// GetOAuthClients godoc
+// @Summary      List registered apps
+// @Description  Returns the third-party apps registered by the user.
+// @Tags         oauth server
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {array}   models.OAuthClient
+// @Failure      401  {object}  models.ErrorResponse
+// @Router       /oauth/clients [get]
+func GetOAuthClients(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	var clients []models.OAuthClient
+	if err := appdata.DB.Where("owner_id = ?", userID).Order("created_at").Find(&clients).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.JSON(clients)
+}
+
+// DeleteOAuthClient godoc
+// @Summary      Delete a registered app
+// @Description  Deletes an app registered by the user and revokes every token issued to it.
+// @Tags         oauth server
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path  int  true  "App ID"
+// @Success      200  {object}  models.GenericMessage
+// @Failure      404  {object}  models.ErrorResponse
+// @Router       /oauth/clients/{id} [delete]
+func DeleteOAuthClient(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Invalid app id"})
+	}
+	var client models.OAuthClient
+	if err := appdata.DB.Where("id = ? AND owner_id = ?", id, userID).First(&client).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{Error: "App not found"})
+	}
+	var sessionIDs []string
+	if err := appdata.DB.Model(&models.RefreshToken{}).Where("client_id = ?", client.ClientID).Distinct().Pluck("session_id", &sessionIDs).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	for _, sessionID := range sessionIDs {
+		if err := utils.RevokeAccessTokens(sessionID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+		}
+	}
+	err = appdata.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("client_id = ?", client.ClientID).Delete(&models.RefreshToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("client_id = ?", client.ClientID).Delete(&models.OAuthAuthorizationCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&client).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.JSON(models.GenericMessage{Message: "App deleted, all of its tokens were revoked"})
+}
+
+// GetOAuthConsent godoc
+// @Summary      Get consent screen data
+// @Description  Validates an authorization request of a third-party app and returns what to show on the consent screen. The app must use PKCE with S256.
+// @Tags         oauth server
+// @Produce      json
+// @Security     BearerAuth
+// @Param        client_id              query  string  true  "Client ID"
+// @Param        redirect_uri           query  string  true  "Redirect URI registered for the app"
+// @Param        response_type          query  string  true  "Must be code"
+// @Param        scope                  query  string  true  "Space separated scopes"
+// @Param        state                  query  string  false "Opaque value returned to the app"
+// @Param        code_challenge         query  string  true  "PKCE code challenge"
+// @Param        code_challenge_method  query  string  true  "Must be S256"
+// @Success      200  {object}  models.OAuthConsentResponse
+// @Failure      400  {object}  models.ErrorResponse
+// @Router       /oauth/authorize [get]
+func GetOAuthConsent(c *fiber.Ctx) error {
+	if c.Query("response_type") != "code" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Only the code response type is supported"})
+	}
+	req := models.OAuthAuthorizeRequest{
+		ClientID:            c.Query("client_id"),
+		RedirectURI:         c.Query("redirect_uri"),
+		Scope:               c.Query("scope"),
+		State:               c.Query("state"),
+		CodeChallenge:       c.Query("code_challenge"),
+		CodeChallengeMethod: c.Query("code_challenge_method"),
+	}
+	client, scopes, err := validateAuthorizationRequest(&req)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: err.Error()})
+	}
+	response := models.OAuthConsentResponse{ClientID: client.ClientID, ClientName: client.Name, RedirectURI: req.RedirectURI, State: req.State}
+	for _, scope := range scopes {
+		response.Scopes = append(response.Scopes, models.OAuthScope{Name: scope, Description: utils.ScopeDescriptions[scope]})
+	}
+	return c.JSON(response)
+}
+
+// AuthorizeOAuthClient godoc
+// @Summary      Approve or deny a third-party app
+// @Description  Records the user's decision on the consent screen and returns where to redirect the browser: back to the app with an authorization code, or with error=access_denied.
+// @Tags         oauth server
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        decision  body  models.OAuthAuthorizeRequest  true  "Authorization request and decision"
+// @Success      200  {object}  models.OAuthRedirectResponse
+// @Failure      400  {object}  models.ErrorResponse
+// @Router       /oauth/authorize [post]
+func AuthorizeOAuthClient(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	var req models.OAuthAuthorizeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+	}
+	client, scopes, err := validateAuthorizationRequest(&req)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: err.Error()})
+	}
+	params := url.Values{}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	if !req.Approve {
+		params.Set("error", "access_denied")
+		return c.JSON(models.OAuthRedirectResponse{RedirectTo: appendQuery(req.RedirectURI, params)})
+	}
+
+	code, err := utils.GenerateToken()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	now := time.Now()
+	appdata.DB.Where("expires_at < ?", now).Delete(&models.OAuthAuthorizationCode{})
+	authorization := models.OAuthAuthorizationCode{
+		Code:          utils.HashToken(code),
+		ClientID:      client.ClientID,
+		UserID:        userID,
+		RedirectURI:   req.RedirectURI,
+		Scope:         strings.Join(scopes, " "),
+		CodeChallenge: req.CodeChallenge,
+		ExpiresAt:     now.Add(oauthCodeMinutes * time.Minute),
+	}
+	if err := appdata.DB.Create(&authorization).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	params.Set("code", code)
+	return c.JSON(models.OAuthRedirectResponse{RedirectTo: appendQuery(req.RedirectURI, params)})
+}
+
+// IssueOAuthToken godoc
+// @Summary      OAuth2 token endpoint
+// @Description  Exchanges an authorization code (with the PKCE verifier) or a refresh token for an access token and a new refresh token. Confidential apps authenticate with HTTP Basic or client_secret. Access tokens only work on routes covered by their scopes.
+// @Tags         oauth server
+// @Accept       x-www-form-urlencoded
+// @Produce      json
+// @Param        grant_type     formData  string  true   "authorization_code or refresh_token"
+// @Param        client_id      formData  string  false  "Client ID, unless sent with HTTP Basic"
+// @Param        client_secret  formData  string  false  "Client secret of confidential apps"
+// @Param        code           formData  string  false  "Authorization code"
+// @Param        redirect_uri   formData  string  false  "Redirect URI used for the code"
+// @Param        code_verifier  formData  string  false  "PKCE code verifier"
+// @Param        refresh_token  formData  string  false  "Refresh token"
+// @Success      200  {object}  models.OAuthTokenResponse
+// @Failure      400  {object}  models.OAuthErrorResponse
+// @Failure      401  {object}  models.OAuthErrorResponse
+// @Router       /oauth/token [post]
+func IssueOAuthToken(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	client, err := authenticateOAuthClient(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.OAuthErrorResponse{Error: "invalid_client", ErrorDescription: err.Error()})
+	}
+
+	var user models.User
+	var refresh models.RefreshToken
+	switch c.FormValue("grant_type") {
+	case "authorization_code":
+		var authorization models.OAuthAuthorizationCode
+		if err := appdata.DB.Where("code = ? AND client_id = ?", utils.HashToken(c.FormValue("code")), client.ClientID).First(&authorization).Error; err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.OAuthErrorResponse{Error: "invalid_grant", ErrorDescription: "Unknown authorization code"})
+		}
+		if result := appdata.DB.Delete(&authorization); result.Error != nil || result.RowsAffected == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(models.OAuthErrorResponse{Error: "invalid_grant", ErrorDescription: "Unknown authorization code"})
+		}
+		if authorization.ExpiresAt.Before(time.Now()) || authorization.RedirectURI != c.FormValue("redirect_uri") {
+			return c.Status(fiber.StatusBadRequest).JSON(models.OAuthErrorResponse{Error: "invalid_grant", ErrorDescription: "Authorization code expired or redirect URI mismatch"})
+		}
+		challenge := sha256.Sum256([]byte(c.FormValue("code_verifier")))
+		if subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(challenge[:])), []byte(authorization.CodeChallenge)) != 1 {
+			return c.Status(fiber.StatusBadRequest).JSON(models.OAuthErrorResponse{Error: "invalid_grant", ErrorDescription: "Code verifier doesn't match the code challenge"})
+		}
+		if err := appdata.DB.First(&user, authorization.UserID).Error; err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.OAuthErrorResponse{Error: "invalid_grant", ErrorDescription: "User no longer exists"})
+		}
+		refresh = utils.PrepareClientRefreshToken(&user, client, authorization.Scope)
+	case "refresh_token":
+		previous, _, err := consumeRefreshToken(c, c.FormValue("refresh_token"), client.ClientID)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.OAuthErrorResponse{Error: "invalid_grant", ErrorDescription: err.Error()})
+		}
+		if err := appdata.DB.First(&user, previous.UserID).Error; err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.OAuthErrorResponse{Error: "invalid_grant", ErrorDescription: "User no longer exists"})
+		}
+		refresh = utils.RotateRefreshToken(previous)
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(models.OAuthErrorResponse{Error: "unsupported_grant_type"})
+	}
+	if refresh.Token == "" {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.OAuthErrorResponse{Error: "server_error"})
+	}
+
+	return c.JSON(models.OAuthTokenResponse{
+		AccessToken:  utils.PrepareClientAccessToken(&user, refresh.SessionID, client.ClientID, refresh.Scope),
+		TokenType:    "Bearer",
+		ExpiresIn:    appdata.JwtExpiryMinutes * 60,
+		RefreshToken: refresh.Token,
+		Scope:        refresh.Scope,
+	})
+}
+
+// RevokeOAuthToken godoc
+// @Summary      Revoke an app token
+// @Description  Revokes an access or refresh token issued to the calling app, ending its session (RFC 7009). Unknown tokens are ignored.
+// @Tags         oauth server
+// @Accept       x-www-form-urlencoded
+// @Param        token          formData  string  true   "Access or refresh token"
+// @Param        client_id      formData  string  false  "Client ID, unless sent with HTTP Basic"
+// @Param        client_secret  formData  string  false  "Client secret of confidential apps"
+// @Success      200
+// @Failure      401  {object}  models.OAuthErrorResponse
+// @Router       /oauth/revoke [post]
+func RevokeOAuthToken(c *fiber.Ctx) error {
+	client, err := authenticateOAuthClient(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.OAuthErrorResponse{Error: "invalid_client", ErrorDescription: err.Error()})
+	}
+	sessionID := ""
+	token := c.FormValue("token")
+	var refresh models.RefreshToken
+	if err := appdata.DB.Where("token_hash = ? AND client_id = ?", utils.HashToken(token), client.ClientID).First(&refresh).Error; err == nil {
+		sessionID = refresh.SessionID
+	} else if claims, err := utils.ParseToken(token, utils.TokenTypeClientAccess); err == nil && claims["cid"] == client.ClientID {
+		sessionID, _ = claims["sid"].(string)
+	}
+	if sessionID != "" {
+		if _, err := utils.RevokeRefreshTokenFamily(sessionID); err != nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(models.OAuthErrorResponse{Error: "temporarily_unavailable"})
+		}
+		if err := utils.RevokeAccessTokens(sessionID); err != nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(models.OAuthErrorResponse{Error: "temporarily_unavailable"})
+		}
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// IntrospectOAuthToken godoc
+// @Summary      Introspect an app token
+// @Description  Tells the calling app whether one of its access or refresh tokens is still active, and what it grants (RFC 7662).
+// @Tags         oauth server
+// @Accept       x-www-form-urlencoded
+// @Produce      json
+// @Param        token          formData  string  true   "Access or refresh token"
+// @Param        client_id      formData  string  false  "Client ID, unless sent with HTTP Basic"
+// @Param        client_secret  formData  string  false  "Client secret of confidential apps"
+// @Success      200  {object}  models.OAuthIntrospectionResponse
+// @Failure      401  {object}  models.OAuthErrorResponse
+// @Router       /oauth/introspect [post]
+func IntrospectOAuthToken(c *fiber.Ctx) error {
+	client, err := authenticateOAuthClient(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.OAuthErrorResponse{Error: "invalid_client", ErrorDescription: err.Error()})
+	}
+	inactive := models.OAuthIntrospectionResponse{Active: false}
+	token := c.FormValue("token")
+
+	var userID uint
+	response := models.OAuthIntrospectionResponse{Active: true, ClientID: client.ClientID}
+	var refresh models.RefreshToken
+	if err := appdata.DB.Where("token_hash = ? AND client_id = ?", utils.HashToken(token), client.ClientID).First(&refresh).Error; err == nil {
+		if refresh.Revoked || refresh.ExpiresAt.Before(time.Now()) {
+			return c.JSON(inactive)
+		}
+		userID = refresh.UserID
+		response.Scope = refresh.Scope
+		response.TokenType = "refresh_token"
+		response.Exp = refresh.ExpiresAt.Unix()
+	} else if claims, err := utils.ParseToken(token, utils.TokenTypeClientAccess); err == nil && claims["cid"] == client.ClientID {
+		if !clientAccessTokenActive(claims) {
+			return c.JSON(inactive)
+		}
+		id, _ := claims["id"].(float64)
+		exp, _ := claims["exp"].(float64)
+		userID = uint(id)
+		response.Scope, _ = claims["scope"].(string)
+		response.TokenType = "access_token"
+		response.Exp = int64(exp)
+	} else {
+		return c.JSON(inactive)
+	}
+
+	var user models.User
+	if err := appdata.DB.First(&user, userID).Error; err != nil {
+		return c.JSON(inactive)
+	}
+	response.Username = user.Username
+	response.Sub = strconv.FormatUint(uint64(user.ID), 10)
+	return c.JSON(response)
+}
+
+// validateAuthorizationRequest checks the client, redirect URI, scopes and
+// PKCE parameters. Errors are meant for the user, since a bad redirect URI
+// must not be redirected to.
+func validateAuthorizationRequest(req *models.OAuthAuthorizeRequest) (*models.OAuthClient, []string, error) {
+	var client models.OAuthClient
+	if err := appdata.DB.Where("client_id = ?", req.ClientID).First(&client).Error; err != nil {
+		return nil, nil, errors.New("Unknown app")
+	}
+	if !slices.Contains(client.RedirectURIs, req.RedirectURI) {
+		return nil, nil, errors.New("The redirect URI is not registered for this app")
+	}
+	scopes, err := utils.ParseScopes(req.Scope)
+	if err != nil || len(scopes) == 0 {
+		return nil, nil, errors.New("Invalid or missing scope")
+	}
+	if req.CodeChallengeMethod != "S256" || len(req.CodeChallenge) < 43 || len(req.CodeChallenge) > 128 {
+		return nil, nil, errors.New("A PKCE code challenge with the S256 method is required")
+	}
+	return &client, scopes, nil
+}
+
+// authenticateOAuthClient identifies the calling app from HTTP Basic or the
+// client_id and client_secret form fields. Public apps only send client_id.
+func authenticateOAuthClient(c *fiber.Ctx) (*models.OAuthClient, error) {
+	clientID, clientSecret := c.FormValue("client_id"), c.FormValue("client_secret")
+	if auth := c.Get(fiber.HeaderAuthorization); strings.HasPrefix(auth, "Basic ") {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(auth, "Basic "))
+		if err != nil {
+			return nil, errors.New("Malformed Basic authorization")
+		}
+		id, secret, _ := strings.Cut(string(decoded), ":")
+		clientID, _ = url.QueryUnescape(id)
+		clientSecret, _ = url.QueryUnescape(secret)
+	}
+	var client models.OAuthClient
+	if err := appdata.DB.Where("client_id = ?", clientID).First(&client).Error; err != nil {
+		return nil, errors.New("Unknown client")
+	}
+	if client.SecretHash != "" && subtle.ConstantTimeCompare([]byte(utils.HashToken(clientSecret)), []byte(client.SecretHash)) != 1 {
+		return nil, errors.New("Wrong client secret")
+	}
+	return &client, nil
+}
+
+// clientAccessTokenActive applies the checks of checkTokenRevocation to a token
+// that didn't come through the JWT middleware
+func clientAccessTokenActive(claims jwt.MapClaims) bool {
+	id, _ := claims["id"].(float64)
+	version, _ := claims["ver"].(float64)
+	currentVersion, err := utils.Revocations.TokenVersion(uint(id))
+	if err != nil || uint(version) < currentVersion {
+		return false
+	}
+	sessionID, _ := claims["sid"].(string)
+	revoked, err := utils.Revocations.IsSessionRevoked(sessionID)
+	return err == nil && !revoked
+}
+
+// validRedirectURI accepts absolute URIs without a fragment. Plain http is
+// only allowed for local development, native apps use a reverse domain name
+// scheme like com.example.app (RFC 8252).
+func validRedirectURI(redirectURI string) bool {
+	parsed, err := url.Parse(redirectURI)
+	if err != nil || parsed.Scheme == "" || parsed.Fragment != "" {
+		return false
+	}
+	switch parsed.Scheme {
+	case "https":
+		return parsed.Host != ""
+	case "http":
+		host := parsed.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	default:
+		return strings.Contains(parsed.Scheme, ".")
+	}
+}
+
+func appendQuery(redirectURI string, params url.Values) string {
+	if strings.Contains(redirectURI, "?") {
+		return redirectURI + "&" + params.Encode()
+	}
+	return redirectURI + "?" + params.Encode()
+}
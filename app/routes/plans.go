@@ -0,0 +1,366 @@
+package routes
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"users-api/app/appdata"
+	"users-api/app/models"
+	"users-api/app/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Bounds of user-defined plans
+const (
+	planMaxDays     = 3650
+	planMaxChapters = 5000
+)
+
+const (
+	PlanStatusNotStarted = "not_started"
+	PlanStatusBehind     = "behind"
+	PlanStatusOnTrack    = "on_track"
+	PlanStatusAhead      = "ahead"
+	PlanStatusFinished   = "finished"
+)
+
+// GetReadingPlans godoc
+// @Summary      List reading plans
+// @Description  Returns the built-in reading plans and the plans the user made, with the start date of those the user follows
+// @Tags         plans
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {array}  models.ReadingPlanSummary
+// @Router       /plans [get]
+func GetReadingPlans(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	var plans []models.ReadingPlan
+	if err := appdata.DB.Where("owner_id IS NULL OR owner_id = ?", userID).Order("owner_id NULLS FIRST, id").Find(&plans).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	var enrollments []models.PlanEnrollment
+	if err := appdata.DB.Where("user_id = ?", userID).Find(&enrollments).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	startDates := make(map[uint]time.Time, len(enrollments))
+	for _, enrollment := range enrollments {
+		startDates[enrollment.PlanID] = enrollment.StartDate
+	}
+
+	summaries := make([]models.ReadingPlanSummary, 0, len(plans))
+	for i := range plans {
+		summary := planSummary(&plans[i])
+		if startDate, ok := startDates[plans[i].ID]; ok {
+			summary.StartDate = &startDate
+		}
+		summaries = append(summaries, summary)
+	}
+	return c.JSON(summaries)
+}
+
+// CreateReadingPlan godoc
+// @Summary      Create a reading plan
+// @Description  Creates a plan that spreads the given chapters over a number of days, in their order
+// @Tags         plans
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        plan  body  models.CreatePlanRequest  true  "Name, chapters and length of the plan"
+// @Success      201  {object}  models.ReadingPlan
+// @Failure      400  {object}  models.ErrorResponse
+// @Router       /plans [post]
+func CreateReadingPlan(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	var req models.CreatePlanRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Name the plan"})
+	}
+	if req.Days < 1 || req.Days > planMaxDays {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Plans last from 1 to " + strconv.Itoa(planMaxDays) + " days"})
+	}
+	var chapters []models.PlanChapter
+	for _, requested := range req.Chapters {
+		book, ok := utils.FindBook(requested.Book)
+		if !ok {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Invalid book " + requested.Book})
+		}
+		if requested.Chapter == 0 {
+			chapters = append(chapters, utils.BookChapters(uint(book+1), uint(book+1))...)
+			continue
+		}
+		if requested.Chapter > appdata.Books[book].Chapters {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Invalid chapter number " + appdata.Books[book].Book + " " + strconv.Itoa(int(requested.Chapter))})
+		}
+		chapters = append(chapters, models.PlanChapter{Book: uint(book + 1), Chapter: requested.Chapter})
+	}
+	if len(chapters) == 0 || len(chapters) > planMaxChapters {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Plans have from 1 to " + strconv.Itoa(planMaxChapters) + " chapters"})
+	}
+
+	plan := models.ReadingPlan{OwnerID: &userID, Name: req.Name, Description: strings.TrimSpace(req.Description), Days: utils.SplitIntoDays(chapters, req.Days)}
+	if err := appdata.DB.Create(&plan).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.Status(fiber.StatusCreated).JSON(plan)
+}
+
+// GetReadingPlan godoc
+// @Summary      Get a reading plan
+// @Description  Returns a built-in plan or a plan of the user with the chapters of every day
+// @Tags         plans
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path  int  true  "Plan ID"
+// @Success      200  {object}  models.ReadingPlan
+// @Failure      404  {object}  models.ErrorResponse
+// @Router       /plans/{id} [get]
+func GetReadingPlan(c *fiber.Ctx) error {
+	plan, status, err := findReadingPlan(c)
+	if err != nil {
+		return c.Status(status).JSON(models.ErrorResponse{Error: err.Error()})
+	}
+	return c.JSON(plan)
+}
+
+// DeleteReadingPlan godoc
+// @Summary      Delete a reading plan
+// @Description  Deletes a plan the user made. Built-in plans can't be deleted.
+// @Tags         plans
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path  int  true  "Plan ID"
+// @Success      200  {object}  models.GenericMessage
+// @Failure      404  {object}  models.ErrorResponse
+// @Router       /plans/{id} [delete]
+func DeleteReadingPlan(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	planID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Wrong plan id"})
+	}
+	result := appdata.DB.Where("id = ? AND owner_id = ?", planID, userID).Delete(&models.ReadingPlan{})
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{Error: "Plan not found or it doesn't belong to you"})
+	}
+	return c.JSON(models.GenericMessage{Message: "Plan deleted"})
+}
+
+// EnrollInPlan godoc
+// @Summary      Follow a reading plan
+// @Description  Starts following a plan on the given date, today in the time zone of the user's preferences when left out. Following a plan again moves its start date.
+// @Tags         plans
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id    path  int                       true   "Plan ID"
+// @Param        plan  body  models.EnrollPlanRequest  false  "Start date"
+// @Success      200  {object}  models.PlanEnrollment
+// @Failure      400  {object}  models.ErrorResponse
+// @Failure      404  {object}  models.ErrorResponse
+// @Router       /plans/{id}/enroll [post]
+func EnrollInPlan(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	plan, status, err := findReadingPlan(c)
+	if err != nil {
+		return c.Status(status).JSON(models.ErrorResponse{Error: err.Error()})
+	}
+	var req models.EnrollPlanRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+		}
+	}
+	startDate := utils.Date(time.Now().In(utils.UserLocation(userID)))
+	if req.StartDate != "" {
+		if startDate, err = time.Parse(time.DateOnly, req.StartDate); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Start date must look like 2025-01-31"})
+		}
+	}
+
+	var enrollment models.PlanEnrollment
+	err = appdata.DB.Where(models.PlanEnrollment{UserID: userID, PlanID: plan.ID}).
+		Assign(models.PlanEnrollment{StartDate: startDate}).
+		FirstOrCreate(&enrollment).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.JSON(enrollment)
+}
+
+// LeavePlan godoc
+// @Summary      Stop following a reading plan
+// @Tags         plans
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path  int  true  "Plan ID"
+// @Success      200  {object}  models.GenericMessage
+// @Failure      404  {object}  models.ErrorResponse
+// @Router       /plans/{id}/enroll [delete]
+func LeavePlan(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	planID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Wrong plan id"})
+	}
+	result := appdata.DB.Where("user_id = ? AND plan_id = ?", userID, planID).Delete(&models.PlanEnrollment{})
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{Error: "You don't follow this plan"})
+	}
+	return c.JSON(models.GenericMessage{Message: "Left the plan"})
+}
+
+// GetPlanToday godoc
+// @Summary      Today's reading of a plan
+// @Description  Returns the chapters of the day in a plan the user follows, the unread chapters of earlier days to catch up on and whether the user is ahead of or behind schedule. Chapters count as read when they are in the reading history of the current reading cycle. The day is taken from the date parameter, so clients can pass their local date; it defaults to today in the time zone of the user's preferences, UTC when none is set.
+// @Tags         plans
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id    path   int     true   "Plan ID"
+// @Param        date  query  string  false  "Local date of the user, like 2025-03-14"
+// @Success      200  {object}  models.PlanTodayResponse
+// @Failure      400  {object}  models.ErrorResponse
+// @Failure      404  {object}  models.ErrorResponse
+// @Router       /plans/{id}/today [get]
+func GetPlanToday(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	plan, status, err := findReadingPlan(c)
+	if err != nil {
+		return c.Status(status).JSON(models.ErrorResponse{Error: err.Error()})
+	}
+	today := utils.Date(time.Now().In(utils.UserLocation(userID)))
+	if date := c.Query("date"); date != "" {
+		if today, err = time.Parse(time.DateOnly, date); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Date must look like 2025-01-31"})
+		}
+	}
+	var enrollment models.PlanEnrollment
+	result := appdata.DB.Where("user_id = ? AND plan_id = ?", userID, plan.ID).First(&enrollment)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{Error: "You don't follow this plan, enroll first"})
+	} else if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	read, err := readChapters(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.JSON(planToday(plan, utils.Date(enrollment.StartDate), today, read))
+}
+
+// planToday works out where the user stands in the plan on the given day.
+// Day 1 is the start date.
+func planToday(plan *models.ReadingPlan, startDate time.Time, today time.Time, read map[models.PlanChapter]bool) models.PlanTodayResponse {
+	response := models.PlanTodayResponse{
+		PlanID:    plan.ID,
+		Date:      today.Format(time.DateOnly),
+		Day:       int(today.Sub(startDate).Hours()/24) + 1,
+		TotalDays: len(plan.Days),
+		Today:     []models.PlanChapterStatus{},
+		CatchUp:   []models.PlanChapterStatus{},
+	}
+	if response.Day < 1 {
+		response.Day = 0
+	}
+	for day, chapters := range plan.Days {
+		for _, chapter := range chapters {
+			response.ChaptersTotal++
+			if read[chapter] {
+				response.ChaptersRead++
+			}
+			switch {
+			case day+1 < response.Day:
+				response.ChaptersExpected++
+				if !read[chapter] {
+					response.CatchUp = append(response.CatchUp, planChapterStatus(chapter, false))
+				}
+			case day+1 == response.Day:
+				response.ChaptersExpected++
+				response.Today = append(response.Today, planChapterStatus(chapter, read[chapter]))
+			}
+		}
+	}
+
+	switch {
+	case response.ChaptersRead == response.ChaptersTotal:
+		response.Status = PlanStatusFinished
+	case response.Day == 0:
+		response.Status = PlanStatusNotStarted
+	case len(response.CatchUp) > 0:
+		response.Status = PlanStatusBehind
+	case response.ChaptersRead > response.ChaptersExpected:
+		response.Status = PlanStatusAhead
+	default:
+		response.Status = PlanStatusOnTrack
+	}
+	return response
+}
+
+func planChapterStatus(chapter models.PlanChapter, read bool) models.PlanChapterStatus {
+	book := appdata.Books[chapter.Book-1]
+	return models.PlanChapterStatus{BookID: chapter.Book, Book: book.Book, Abbreviation: book.Abbreviation, Chapter: chapter.Chapter, Read: read}
+}
+
+// readChapters returns the chapters read in the current reading cycle of the
+// user
+func readChapters(userID uint) (map[models.PlanChapter]bool, error) {
+	cycle, err := utils.CurrentCycle(userID)
+	if err != nil {
+		return nil, err
+	}
+	var history []models.ReadHistory
+	if err := appdata.DB.Where("user_id = ? AND cycle_id = ?", userID, cycle.ID).Find(&history).Error; err != nil {
+		return nil, err
+	}
+	read := make(map[models.PlanChapter]bool, len(history))
+	for _, h := range history {
+		read[models.PlanChapter{Book: h.Book, Chapter: h.Chapter}] = true
+	}
+	return read, nil
+}
+
+// findReadingPlan loads the plan of the id parameter if it is built-in or
+// belongs to the user
+func findReadingPlan(c *fiber.Ctx) (*models.ReadingPlan, int, error) {
+	userID := utils.GetUserFromJwt(c)
+	planID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return nil, fiber.StatusBadRequest, errors.New("Wrong plan id")
+	}
+	var plan models.ReadingPlan
+	result := appdata.DB.Where("id = ? AND (owner_id IS NULL OR owner_id = ?)", planID, userID).First(&plan)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, fiber.StatusNotFound, errors.New("Plan not found")
+	} else if result.Error != nil {
+		return nil, fiber.StatusInternalServerError, errors.New(models.NewInternalError().Error)
+	}
+	return &plan, 0, nil
+}
+
+func planSummary(plan *models.ReadingPlan) models.ReadingPlanSummary {
+	chapters := 0
+	for _, day := range plan.Days {
+		chapters += len(day)
+	}
+	return models.ReadingPlanSummary{
+		ID:          plan.ID,
+		Slug:        plan.Slug,
+		Name:        plan.Name,
+		Description: plan.Description,
+		Days:        len(plan.Days),
+		Chapters:    chapters,
+		BuiltIn:     plan.OwnerID == nil,
+	}
+}
@@ -4,12 +4,14 @@ import (
 	"errors"
 	"strconv"
 	"strings"
+	"time"
 	"users-api/app/appdata"
 	"users-api/app/models"
 	"users-api/app/utils"
 
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // MarkChapterAsRead godoc
@@ -71,10 +73,23 @@ func MarkChapterAsRead(c *fiber.Ctx) error {
 	if req.Chapter > bookStruct.Chapters {
 		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Invalid chapter number"})
 	}
-	readHistory := models.ReadHistory{UserID: userID, Book: bookNum, Chapter: req.Chapter}
-	result := appdata.DB.Create(&readHistory)
-	if result.Error != nil {
-		if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+	cycle, err := utils.CurrentCycle(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	readHistory := models.ReadHistory{UserID: userID, CycleID: cycle.ID, Book: bookNum, Chapter: req.Chapter}
+	err = appdata.DB.Transaction(func(tx *gorm.DB) error {
+		if err := utils.LockReadHistory(tx, userID); err != nil {
+			return err
+		}
+		if err := tx.Create(&readHistory).Error; err != nil {
+			return err
+		}
+		changed := []models.PlanChapter{{Book: bookNum, Chapter: req.Chapter}}
+		return utils.RecordReadChanges(tx, userID, cycle.ID, changed, true, readHistory.CreatedAt)
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
 			// Successful response is intentional
 			return c.JSON(models.ErrorResponse{Error: "This chapter is already marked as read"})
 		} else {
@@ -141,16 +156,29 @@ func MarkChapterAsUnread(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Invalid book"})
 	}
 
-	var readHistory models.ReadHistory
-	result := appdata.DB.Where("user_id = ? AND book = ? AND chapter = ?", user_id, bookNum, req.Chapter).First(&readHistory)
-	if result.Error != nil {
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return c.JSON(models.ErrorResponse{Error: "This chapter is not marked as read"})
-		} else {
-			return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	cycle, err := utils.CurrentCycle(user_id)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	var deleted int64
+	err = appdata.DB.Transaction(func(tx *gorm.DB) error {
+		if err := utils.LockReadHistory(tx, user_id); err != nil {
+			return err
+		}
+		result := tx.Where("user_id = ? AND cycle_id = ? AND book = ? AND chapter = ?", user_id, cycle.ID, bookNum, req.Chapter).Delete(&models.ReadHistory{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
 		}
+		deleted = result.RowsAffected
+		changed := []models.PlanChapter{{Book: bookNum, Chapter: req.Chapter}}
+		return utils.RecordReadChanges(tx, user_id, cycle.ID, changed, false, time.Now())
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if deleted == 0 {
+		return c.JSON(models.ErrorResponse{Error: "This chapter is not marked as read"})
 	}
-	appdata.DB.Delete(&readHistory)
 
 	response := models.MarkChapterAsReadResponse{
 		Book:         appdata.Books[bookNum-1].Book,
@@ -206,24 +234,53 @@ func MarkBookAsRead(c *fiber.Ctx) error {
 
 	bookStruct := appdata.Books[bookIDUint64-1]
 
-	// reset history in this book
-	if err := appdata.DB.Where("user_id = ? AND book = ?", userID, uint(bookIDUint64)).
-		Delete(&models.ReadHistory{}).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{Error: "Invalid book id"})
+	cycle, err := utils.CurrentCycle(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
 	}
 
-	// construct read history entries
-	readHistories := make([]models.ReadHistory, 0, bookStruct.Chapters)
-	for ch := uint(1); ch <= bookStruct.Chapters; ch++ {
-		readHistories = append(readHistories, models.ReadHistory{
-			UserID:  userID,
-			Book:    uint(bookIDUint64),
-			Chapter: ch,
-		})
-	}
+	err = appdata.DB.Transaction(func(tx *gorm.DB) error {
+		if err := utils.LockReadHistory(tx, userID); err != nil {
+			return err
+		}
+		var readChapters []uint
+		if err := tx.Model(&models.ReadHistory{}).
+			Where("user_id = ? AND cycle_id = ? AND book = ?", userID, cycle.ID, uint(bookIDUint64)).
+			Pluck("chapter", &readChapters).Error; err != nil {
+			return err
+		}
+		alreadyRead := make(map[uint]bool, len(readChapters))
+		for _, ch := range readChapters {
+			alreadyRead[ch] = true
+		}
 
-	// insert in batches to avoid one-by-one inserts
-	if err := appdata.DB.CreateInBatches(readHistories, 100).Error; err != nil {
+		// only insert the chapters that aren't read yet, so their read dates are kept
+		var readHistories []models.ReadHistory
+		var changed []models.PlanChapter
+		for ch := uint(1); ch <= bookStruct.Chapters; ch++ {
+			if alreadyRead[ch] {
+				continue
+			}
+			readHistories = append(readHistories, models.ReadHistory{
+				UserID:  userID,
+				CycleID: cycle.ID,
+				Book:    uint(bookIDUint64),
+				Chapter: ch,
+			})
+			changed = append(changed, models.PlanChapter{Book: uint(bookIDUint64), Chapter: ch})
+		}
+		if len(readHistories) == 0 {
+			return nil
+		}
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "cycle_id"}, {Name: "book"}, {Name: "chapter"}},
+			DoNothing: true,
+		}).CreateInBatches(&readHistories, 100).Error; err != nil {
+			return err
+		}
+		return utils.RecordReadChanges(tx, userID, cycle.ID, changed, true, time.Now())
+	})
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
 	}
 
@@ -231,7 +288,7 @@ func MarkBookAsRead(c *fiber.Ctx) error {
 		Message:      "Book marked as read",
 		Book:         bookStruct.Book,
 		Abbreviation: bookStruct.Abbreviation,
-		Count:        len(readHistories),
+		Count:        int(bookStruct.Chapters),
 	}
 
 	return c.Status(fiber.StatusCreated).JSON(response)
@@ -278,9 +335,24 @@ func MarkBookAsUnread(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Invalid book id"})
 	}
 
-	// Delete entries history in this book
-	if err := appdata.DB.Where("user_id = ? AND book = ?", userID, uint(bookIDUint64)).
-		Delete(&models.ReadHistory{}).Error; err != nil {
+	cycle, err := utils.CurrentCycle(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+
+	err = appdata.DB.Transaction(func(tx *gorm.DB) error {
+		if err := utils.LockReadHistory(tx, userID); err != nil {
+			return err
+		}
+		// Delete entries history in this book
+		if err := tx.Where("user_id = ? AND cycle_id = ? AND book = ?", userID, cycle.ID, uint(bookIDUint64)).
+			Delete(&models.ReadHistory{}).Error; err != nil {
+			return err
+		}
+		changed := utils.BookChapters(uint(bookIDUint64), uint(bookIDUint64))
+		return utils.RecordReadChanges(tx, userID, cycle.ID, changed, false, time.Now())
+	})
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
 	}
 
@@ -296,11 +368,12 @@ func MarkBookAsUnread(c *fiber.Ctx) error {
 
 // GetReadChaptersOfBook godoc
 // @Summary      Get read chapter numbers for a book
-// @Description  Returns the list of chapters marked as read for the specified book.
+// @Description  Returns the list of chapters marked as read for the specified book in the current reading cycle, or in the cycle given.
 // @Tags         read_history
 // @Accept       json
 // @Produce      json
 // @Param        bookid   path  string  true  "ID of the book, can be the name of the book, abbreviation or the number (1-66)"
+// @Param        cycle    query int     false "ID of a reading cycle, the current one by default"
 // @Security     BearerAuth
 // @Success      200  {object}  models.BookReadChaptersResponse
 // @Failure      401  {object}  models.ErrorResponse
@@ -336,11 +409,15 @@ func GetReadChaptersOfBook(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Invalid book id"})
 	}
 	bookID := uint(bookIDUint64)
+	cycle, status, err := requestedCycle(c, userID)
+	if err != nil {
+		return c.Status(status).JSON(models.ErrorResponse{Error: err.Error()})
+	}
 
 	// Query DB for read chapters
 	var histories []models.ReadHistory
 	if err := appdata.DB.
-		Where("user_id = ? AND book = ?", userID, bookID).
+		Where("user_id = ? AND cycle_id = ? AND book = ?", userID, cycle.ID, bookID).
 		Find(&histories).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
 	}
@@ -364,41 +441,186 @@ func GetReadChaptersOfBook(c *fiber.Ctx) error {
 
 // GetReadBooksStatus godoc
 // @Summary      Get read progress of all Bible books
-// @Description  Returns the read status for each book in the Bible (complete, partial and not_started).
+// @Description  Returns the read status for each book in the Bible (complete, partial and not_started) in the current reading cycle, or in the cycle given, with the chapters and verses read, the percentage read weighted by verses and the estimated minutes left.
 // @Tags         read_history
 // @Accept       json
 // @Produce      json
+// @Param        cycle  query  int  false  "ID of a reading cycle, the current one by default"
 // @Security     BearerAuth
 // @Success 	 200 {array} models.ReadBook
 // @Failure      401  {object}  models.ErrorResponse
 // @Router 		 /readbooksstatus [get]
 func GetReadBooksStatus(c *fiber.Ctx) error {
 	userID := utils.GetUserFromJwt(c)
+	cycle, status, err := requestedCycle(c, userID)
+	if err != nil {
+		return c.Status(status).JSON(models.ErrorResponse{Error: err.Error()})
+	}
+	progress, err := readProgress(userID, cycle.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.Status(fiber.StatusOK).JSON(progress.Books)
+}
+
+// GetReadProgress godoc
+// @Summary      Get read progress of the Bible
+// @Description  Returns the progress of every book like /readbooksstatus, and of the Old Testament, the New Testament and the whole Bible, in the current reading cycle or in the cycle given. Percentages are weighted by verses, so reading Psalm 119 counts more than reading Psalm 117.
+// @Tags         read_history
+// @Produce      json
+// @Param        cycle  query  int  false  "ID of a reading cycle, the current one by default"
+// @Security     BearerAuth
+// @Success      200  {object}  models.ReadProgressResponse
+// @Failure      401  {object}  models.ErrorResponse
+// @Router       /readprogress [get]
+func GetReadProgress(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	cycle, status, err := requestedCycle(c, userID)
+	if err != nil {
+		return c.Status(status).JSON(models.ErrorResponse{Error: err.Error()})
+	}
+	progress, err := readProgress(userID, cycle.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.JSON(progress)
+}
 
-	// Fetch all read history for the user
+// readProgress works out the progress of the user through every book, each
+// testament and the Bible from the read history of a cycle
+func readProgress(userID uint, cycleID uint) (models.ReadProgressResponse, error) {
 	var histories []models.ReadHistory
 	if err := appdata.DB.
-		Where("user_id = ?", userID).
+		Where("user_id = ? AND cycle_id = ?", userID, cycleID).
 		Find(&histories).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+		return models.ReadProgressResponse{}, err
 	}
 
-	// Of the form {"book_id": count}, like {1: 10}, which means Genesis 10 chapters in read history
-	readHistories := make(map[uint]uint)
-
+	// Chapters and verses read per book, indexed like appdata.Books
+	chaptersRead := make([]uint, appdata.BookCount)
+	versesRead := make([]uint, appdata.BookCount)
 	for i := range histories {
-		readHistories[histories[i].Book] += 1
+		verses := utils.VersesOfChapter(histories[i].Book, histories[i].Chapter)
+		if verses == 0 {
+			continue
+		}
+		chaptersRead[histories[i].Book-1]++
+		versesRead[histories[i].Book-1] += verses
+	}
+
+	// Totals of the whole Bible and of the testaments, indexed by Testament
+	var totals [3]models.ReadProgress
+	response := models.ReadProgressResponse{Books: make([]models.ReadBook, 0, appdata.BookCount)}
+	for i, book := range appdata.Books {
+		readBook := models.ReadBook{
+			Book:         book.Book,
+			Abbreviation: book.Abbreviation,
+			Status:       models.StatusNotStarted,
+			ReadProgress: utils.NewReadProgress(book.Chapters, chaptersRead[i], book.Verses, versesRead[i]),
+		}
+		if chaptersRead[i] == book.Chapters {
+			readBook.Status = models.StatusComplete
+		} else if chaptersRead[i] > 0 {
+			readBook.Status = models.StatusPartial
+		}
+		response.Books = append(response.Books, readBook)
+		for _, t := range []uint8{0, book.Testament} {
+			totals[t].Chapters += book.Chapters
+			totals[t].ChaptersRead += chaptersRead[i]
+			totals[t].Verses += book.Verses
+			totals[t].VersesRead += versesRead[i]
+		}
+	}
+	for t, total := range totals {
+		totals[t] = utils.NewReadProgress(total.Chapters, total.ChaptersRead, total.Verses, total.VersesRead)
+	}
+	response.Bible, response.OldTestament, response.NewTestament = totals[0], totals[1], totals[2]
+	return response, nil
+}
+
+// Longest heatmap a client may ask for, in days
+const readingStatsMaxDays = 731
+
+// GetReadingStats godoc
+// @Summary      Get reading streaks and activity
+// @Description  Returns the current and longest daily reading streak, chapter counts for today, this week and this month, and chapters read per day, week and month over the last days for charts and a calendar heatmap. Days are counted in the time zone of the tz parameter, else the one in the user preferences, else UTC.
+// @Tags         read_history
+// @Produce      json
+// @Security     BearerAuth
+// @Param        tz    query  string  false  "IANA time zone like Europe/Paris"
+// @Param        days  query  int     false  "Number of days in the series, 365 by default"
+// @Success      200  {object}  models.ReadingStatsResponse
+// @Failure      400  {object}  models.ErrorResponse
+// @Failure      401  {object}  models.ErrorResponse
+// @Router       /readstats [get]
+func GetReadingStats(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	location := utils.UserLocation(userID)
+	if tz := c.Query("tz"); tz != "" {
+		var ok bool
+		if location, ok = utils.LoadTimezone(tz); !ok {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Unknown time zone " + tz})
+		}
+	}
+	days := c.QueryInt("days", 365)
+	if days < 1 || days > readingStatsMaxDays {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "days must be between 1 and " + strconv.Itoa(readingStatsMaxDays)})
+	}
+
+	activity, err := utils.ReadingActivity(userID, location)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
 	}
-	result := make([]models.ReadBook, 0, 66)
-	for i := range appdata.Books {
-		result = append(result, models.ReadBook{Book: appdata.Books[i].Book, Abbreviation: appdata.Books[i].Abbreviation, Status: models.StatusNotStarted})
+	today := utils.Date(time.Now().In(location))
+	from := today.AddDate(0, 0, 1-days)
+	weekStart := utils.WeekStart(today)
+	monthStart := today.AddDate(0, 0, 1-today.Day())
+
+	response := models.ReadingStatsResponse{
+		Timezone: location.String(),
+		Today:    today.Format(time.DateOnly),
+		DaysRead: len(activity),
+		From:     from.Format(time.DateOnly),
+		To:       today.Format(time.DateOnly),
+		Daily:    make([]models.ReadingCount, 0, days),
+		Weekly:   []models.ReadingCount{},
+		Monthly:  []models.ReadingCount{},
 	}
-	for bookID, count := range readHistories {
-		if count == appdata.Books[bookID-1].Chapters {
-			result[bookID-1].Status = models.StatusComplete
-		} else if count > 0 {
-			result[bookID-1].Status = models.StatusPartial
+	var longestStart time.Time
+	response.CurrentStreak, response.LongestStreak, longestStart = utils.ReadingStreaks(activity, today)
+	if response.LongestStreak > 0 {
+		start := longestStart.Format(time.DateOnly)
+		response.LongestStreakStart = &start
+	}
+
+	perDay := make(map[time.Time]int, len(activity))
+	for _, day := range activity {
+		perDay[day.Day] = day.Count
+		response.ChaptersRead += day.Count
+		if day.Day.Equal(today) {
+			response.ChaptersToday += day.Count
+		}
+		if !day.Day.Before(weekStart) && !day.Day.After(today) {
+			response.ChaptersThisWeek += day.Count
+		}
+		if !day.Day.Before(monthStart) && !day.Day.After(today) {
+			response.ChaptersThisMonth += day.Count
 		}
 	}
-	return c.Status(fiber.StatusOK).JSON(result)
+
+	for day := from; !day.After(today); day = day.AddDate(0, 0, 1) {
+		count := perDay[day]
+		response.Daily = append(response.Daily, models.ReadingCount{Date: day.Format(time.DateOnly), Count: count})
+		week := utils.WeekStart(day).Format(time.DateOnly)
+		if len(response.Weekly) == 0 || response.Weekly[len(response.Weekly)-1].Date != week {
+			response.Weekly = append(response.Weekly, models.ReadingCount{Date: week})
+		}
+		response.Weekly[len(response.Weekly)-1].Count += count
+		month := day.AddDate(0, 0, 1-day.Day()).Format(time.DateOnly)
+		if len(response.Monthly) == 0 || response.Monthly[len(response.Monthly)-1].Date != month {
+			response.Monthly = append(response.Monthly, models.ReadingCount{Date: month})
+		}
+		response.Monthly[len(response.Monthly)-1].Count += count
+	}
+	return c.JSON(response)
 }
@@ -0,0 +1,186 @@
+package routes
+
+import (
+	"fmt"
+	"time"
+	"users-api/app/appdata"
+	"users-api/app/models"
+	"users-api/app/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Most operations one sync may replay
+const readSyncMaxOperations = 5000
+
+// SyncReadHistory godoc
+// @Summary      Sync the read history
+// @Description  Applies chapters marked read or unread while offline in one transaction and returns what changed since the previous sync. For every chapter the change made last, by the client timestamps, wins: an operation older than the latest change of its chapter is ignored. Timestamps in the future count as now. Send the returned cursor with the next sync; without a cursor, or after a new reading cycle was started, the whole history of the current cycle is returned with full set. An empty list of operations just fetches changes.
+// @Tags         read_history
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        sync  body  models.ReadSyncRequest  true  "Cursor and operations"
+// @Success      200  {object}  models.ReadSyncResponse
+// @Failure      400  {object}  models.ErrorResponse
+// @Failure      401  {object}  models.ErrorResponse
+// @Router       /readhistory/sync [post]
+func SyncReadHistory(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	var req models.ReadSyncRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+	}
+	if len(req.Operations) > readSyncMaxOperations {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: fmt.Sprintf("Sync at most %d operations at once", readSyncMaxOperations)})
+	}
+	var cursorCycle, cursorChange uint
+	if req.Cursor != "" {
+		var err error
+		if cursorCycle, cursorChange, err = utils.ParseReadSyncCursor(req.Cursor); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Invalid cursor"})
+		}
+	}
+
+	// The last operation of every chapter, by client time. Of operations
+	// made at the same time the later one in the list wins.
+	now := time.Now()
+	latest := make(map[models.PlanChapter]models.ReadSyncOperation)
+	for i, op := range req.Operations {
+		if utils.VersesOfChapter(op.BookID, op.Chapter) == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: fmt.Sprintf("operations[%d]: no chapter %d in book %d", i, op.Chapter, op.BookID)})
+		}
+		if op.At.IsZero() {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: fmt.Sprintf("operations[%d]: at is missing", i)})
+		}
+		if op.At.After(now) {
+			op.At = now
+		}
+		chapter := models.PlanChapter{Book: op.BookID, Chapter: op.Chapter}
+		if previous, ok := latest[chapter]; !ok || !op.At.Before(previous.At) {
+			latest[chapter] = op
+		}
+	}
+
+	cycle, err := utils.CurrentCycle(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	response := models.ReadSyncResponse{CycleID: cycle.ID, Full: req.Cursor == "" || cursorCycle != cycle.ID, Changes: []models.ReadSyncChange{}}
+	err = appdata.DB.Transaction(func(tx *gorm.DB) error {
+		// Until commit, nothing else can change the history, so the cursor
+		// below covers every change made before it
+		if err := utils.LockReadHistory(tx, userID); err != nil {
+			return err
+		}
+		applied, err := applyReadSync(tx, userID, cycle.ID, latest)
+		if err != nil {
+			return err
+		}
+		response.Applied = applied
+		response.Ignored = len(req.Operations) - applied
+
+		var lastChange models.ReadHistoryChange
+		if err := tx.Where("user_id = ?", userID).Order("id DESC").Limit(1).Find(&lastChange).Error; err != nil {
+			return err
+		}
+		response.Cursor = utils.ReadSyncCursor(cycle.ID, lastChange.ID)
+
+		if response.Full {
+			var histories []models.ReadHistory
+			if err := tx.Where("user_id = ? AND cycle_id = ?", userID, cycle.ID).Order("book, chapter").Find(&histories).Error; err != nil {
+				return err
+			}
+			for _, h := range histories {
+				response.Changes = append(response.Changes, readSyncChange(h.Book, h.Chapter, true, h.CreatedAt))
+			}
+			return nil
+		}
+		var changes []models.ReadHistoryChange
+		if err := tx.Where("user_id = ? AND cycle_id = ? AND id > ?", userID, cycle.ID, cursorChange).Order("id").Find(&changes).Error; err != nil {
+			return err
+		}
+		for _, change := range changes {
+			response.Changes = append(response.Changes, readSyncChange(change.Book, change.Chapter, change.Read, change.ChangedAt))
+		}
+		return nil
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.JSON(response)
+}
+
+// applyReadSync applies the operations that are newer than what the server
+// knows of their chapter and returns how many it applied. The server knows
+// when a chapter was last changed from ReadHistoryChange, or for chapters
+// read before syncing existed, from when they were read.
+func applyReadSync(tx *gorm.DB, userID uint, cycleID uint, operations map[models.PlanChapter]models.ReadSyncOperation) (int, error) {
+	if len(operations) == 0 {
+		return 0, nil
+	}
+	pairs := make([][]interface{}, 0, len(operations))
+	for chapter := range operations {
+		pairs = append(pairs, []interface{}{chapter.Book, chapter.Chapter})
+	}
+	lastChanged := make(map[models.PlanChapter]time.Time, len(operations))
+	var histories []models.ReadHistory
+	if err := tx.Where("user_id = ? AND cycle_id = ? AND (book, chapter) IN ?", userID, cycleID, pairs).Find(&histories).Error; err != nil {
+		return 0, err
+	}
+	for _, h := range histories {
+		lastChanged[models.PlanChapter{Book: h.Book, Chapter: h.Chapter}] = h.CreatedAt
+	}
+	var changes []models.ReadHistoryChange
+	if err := tx.Where("user_id = ? AND cycle_id = ? AND (book, chapter) IN ?", userID, cycleID, pairs).Find(&changes).Error; err != nil {
+		return 0, err
+	}
+	for _, change := range changes {
+		lastChanged[models.PlanChapter{Book: change.Book, Chapter: change.Chapter}] = change.ChangedAt
+	}
+
+	var read, unread []models.PlanChapter
+	var readHistories []models.ReadHistory
+	applied := 0
+	for chapter, op := range operations {
+		if op.At.Before(lastChanged[chapter]) {
+			continue
+		}
+		applied++
+		if op.Read {
+			read = append(read, chapter)
+			readHistories = append(readHistories, models.ReadHistory{UserID: userID, CycleID: cycleID, Book: chapter.Book, Chapter: chapter.Chapter, CreatedAt: op.At})
+		} else {
+			unread = append(unread, chapter)
+		}
+	}
+
+	if len(readHistories) > 0 {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&readHistories, 500).Error; err != nil {
+			return 0, err
+		}
+	}
+	if len(unread) > 0 {
+		unreadPairs := make([][]interface{}, 0, len(unread))
+		for _, chapter := range unread {
+			unreadPairs = append(unreadPairs, []interface{}{chapter.Book, chapter.Chapter})
+		}
+		if err := tx.Where("user_id = ? AND cycle_id = ? AND (book, chapter) IN ?", userID, cycleID, unreadPairs).Delete(&models.ReadHistory{}).Error; err != nil {
+			return 0, err
+		}
+	}
+	// Changes are recorded one by one as each has its own time
+	for _, chapter := range append(read, unread...) {
+		op := operations[chapter]
+		if err := utils.RecordReadChanges(tx, userID, cycleID, []models.PlanChapter{chapter}, op.Read, op.At); err != nil {
+			return 0, err
+		}
+	}
+	return applied, nil
+}
+
+func readSyncChange(book uint, chapter uint, read bool, changedAt time.Time) models.ReadSyncChange {
+	return models.ReadSyncChange{BookID: book, Book: appdata.Books[book-1].Book, Chapter: chapter, Read: read, ChangedAt: changedAt}
+}
@@ -0,0 +1,69 @@
+package routes
+
+import (
+	"time"
+	"users-api/app/appdata"
+	"users-api/app/models"
+	"users-api/app/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetSessions godoc
+// @Summary      List active sessions
+// @Description  Returns every device the user is logged in on, with the session of the current access token marked as current.
+// @Tags         sessions
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {array}   models.Session
+// @Failure      401  {object}  models.ErrorResponse
+// @Router       /sessions [get]
+func GetSessions(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	currentSession := utils.GetSessionFromJwt(c)
+
+	// Only the latest token of a session is unrevoked, so there is one row per session
+	var tokens []models.RefreshToken
+	if err := appdata.DB.
+		Where("user_id = ? AND revoked = ? AND expires_at > ?", userID, false, time.Now()).
+		Order("started_at DESC").
+		Find(&tokens).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+
+	sessions := make([]models.Session, 0, len(tokens))
+	for i := range tokens {
+		sessions = append(sessions, models.NewSession(&tokens[i], currentSession))
+	}
+	return c.JSON(sessions)
+}
+
+// RevokeSession godoc
+// @Summary      Revoke a session
+// @Description  Logs out a single device by deleting every refresh token of the session and revoking its access tokens.
+// @Tags         sessions
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path  string  true  "Session ID as returned by GET /sessions"
+// @Success      200  {object}  models.GenericMessage
+// @Failure      404  {object}  models.ErrorResponse
+// @Router       /sessions/{id} [delete]
+func RevokeSession(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	sessionID := c.Params("id")
+
+	result := appdata.DB.Where("user_id = ? AND session_id = ?", userID, sessionID).Delete(&models.RefreshToken{})
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{Error: "Session not found"})
+	}
+	if err := utils.RevokeAccessTokens(sessionID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+
+	return c.JSON(models.GenericMessage{Message: "Session revoked"})
+}
@@ -3,6 +3,7 @@ package routes
 import (
 	"errors"
 	"fmt"
+	"log"
 	"net/mail"
 	"strconv"
 	"time"
@@ -61,7 +62,15 @@ func CreateUser(c *fiber.Ctx) error {
 		})
 	}
 	email := address.Address
-	hashedPassword := utils.HashPassword(req.Password)
+	if violations := utils.Passwords.Check(req.Password, req.Username, email, req.Name); len(violations) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewPasswordPolicyError(violations))
+	}
+	hashedPassword, err := utils.HashPassword(req.Password)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Something went wrong, try again later",
+		})
+	}
 	photoUrl := utils.GetAvatarURL(email, req.Name)
 	user := models.User{Email: email, Username: req.Username, Password: hashedPassword, Name: req.Name, PhotoUrl: photoUrl}
 	result := appdata.DB.Create(&user)
@@ -89,11 +98,25 @@ func UpdateUser(c *fiber.Ctx) error {
 	photoUrl := c.FormValue("photourl")
 	bio := c.FormValue("bio")
 
+	// A new email only replaces the current one once it is confirmed
+	var newEmail string
 	if email != "" {
 		address, err := mail.ParseAddress(email)
-		if err == nil {
-			user.Email = address.Address
-			user.IsActivated = false
+		if err == nil && address.Address != user.Email {
+			newEmail = address.Address
+		}
+	}
+	if newEmail != "" {
+		var count int64
+		if err := appdata.DB.Model(&models.User{}).Where("email = ?", newEmail).Count(&count).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Something went wrong, try again later",
+			})
+		}
+		if count > 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Email or Username already exists",
+			})
 		}
 	}
 	if name != "" {
@@ -107,7 +130,9 @@ func UpdateUser(c *fiber.Ctx) error {
 	}
 	user.Bio = bio
 	user.Trim()
-	result := appdata.DB.Save(&user)
+	// Only the profile is written back, the password and token version loaded
+	// above may have changed meanwhile
+	result := appdata.DB.Select("name", "photo_url", "username", "bio", "updated_at").Updates(&user)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -119,6 +144,14 @@ func UpdateUser(c *fiber.Ctx) error {
 			})
 		}
 	}
+	if newEmail != "" {
+		if err := requestEmailChange(&user, newEmail); err != nil {
+			log.Printf("failed to request email change for user %d: %v", user.ID, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Something went wrong, try again later",
+			})
+		}
+	}
 	return c.JSON(user)
 }
 
@@ -137,16 +170,21 @@ func SendForgotPasswordEmail(c *fiber.Ctx) error {
 			})
 		}
 	}
-	randString := utils.GenerateAlphanumeric(25)
+	randString, err := utils.GenerateToken()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Something went wrong, try again later",
+		})
+	}
 	now := time.Now()
 	expiresAt := now.Add(time.Duration(appdata.ResetValidMinutes) * time.Minute)
-	forgotPassword := models.ForgotPassword{UserID: user.ID, Token: randString, ExpiresAt: expiresAt}
+	forgotPassword := models.ForgotPassword{UserID: user.ID, TokenHash: utils.HashToken(randString), ExpiresAt: expiresAt}
 	appdata.DB.Where("expires_at < ?", now).Delete(&models.ForgotPassword{})
 	appdata.DB.Where("user_id = ?", user.ID).Delete(&models.ForgotPassword{})
 	appdata.DB.Create(&forgotPassword)
 	resetLink := fmt.Sprintf("https://versequick.com/changepassword/%s", randString)
 	emailBody := fmt.Sprintf("Visit <a href=%s>%s</a> to change your password", resetLink, resetLink)
-	err := utils.SendEmail(email, "Reset your password", emailBody, true)
+	err = utils.SendEmail(email, "Reset your password", emailBody, true)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Something went wrong, try again later",
@@ -159,7 +197,7 @@ func SendForgotPasswordEmail(c *fiber.Ctx) error {
 func ResetPassword(c *fiber.Ctx) error {
 	token := c.FormValue("token")
 	var forgotPassword models.ForgotPassword
-	result := appdata.DB.Where("token = ?", token).First(&forgotPassword)
+	result := appdata.DB.Where("token_hash = ?", utils.HashToken(token)).First(&forgotPassword)
 	if result.Error != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Password reset token invalid, get a new one at /changepassword",
@@ -173,10 +211,23 @@ func ResetPassword(c *fiber.Ctx) error {
 	newPassword := c.FormValue("password")
 	var user models.User
 	appdata.DB.First(&user, forgotPassword.UserID)
-	hashedPassword := utils.HashPassword(newPassword)
+	if violations := utils.Passwords.Check(newPassword, user.Username, user.Email, user.Name); len(violations) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewPasswordPolicyError(violations))
+	}
+	hashedPassword, err := utils.HashPassword(newPassword)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Something went wrong, try again later",
+		})
+	}
 	user.Password = hashedPassword
-	appdata.DB.Save(&user)
+	appdata.DB.Model(&user).Update("password", hashedPassword)
 	appdata.DB.Delete(&forgotPassword)
+	if err := utils.LogoutEverywhere(user.ID, ""); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Something went wrong, try again later",
+		})
+	}
 	return c.JSON(fiber.Map{"message": fmt.Sprintf("Password changed successfully. The link is valid for %d minutes.", appdata.ResetValidMinutes)})
 }
 
@@ -197,11 +248,25 @@ func ChangePassword(c *fiber.Ctx) error {
 			"message": "Old password wrong. If you forgot the password, request a reset link.",
 		})
 	}
-	hashedPassword := utils.HashPassword(newPassword)
+	if violations := utils.Passwords.Check(newPassword, user.Username, user.Email, user.Name); len(violations) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewPasswordPolicyError(violations))
+	}
+	hashedPassword, err := utils.HashPassword(newPassword)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Something went wrong, try again later",
+		})
+	}
 	user.Password = hashedPassword
-	appdata.DB.Save(&user)
+	appdata.DB.Model(&user).Update("password", hashedPassword)
+	// Other devices are logged out, this one keeps its refresh token but has to use it
+	if err := utils.LogoutEverywhere(user.ID, utils.GetSessionFromJwt(c)); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Something went wrong, try again later",
+		})
+	}
 	return c.JSON(fiber.Map{
-		"message": "Password updated successfully",
+		"message": "Password updated successfully, refresh your access token to continue",
 	})
 }
 
@@ -225,16 +290,21 @@ func SendEmailVerificationEmail(c *fiber.Ctx) error {
 			"error": "Email already verified",
 		})
 	}
-	token := utils.GenerateAlphanumeric(25)
+	token, err := utils.GenerateToken()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Something went wrong, try again later",
+		})
+	}
 	now := time.Now()
 	expiresAt := now.Add(time.Duration(appdata.ResetValidMinutes) * time.Minute)
-	verifyEmail := models.VerifyEmail{UserID: user_id, Token: token, ExpiresAt: expiresAt}
+	verifyEmail := models.VerifyEmail{UserID: user_id, TokenHash: utils.HashToken(token), ExpiresAt: expiresAt}
 	appdata.DB.Where("expires_at < ?", now).Delete(&models.VerifyEmail{})
 	appdata.DB.Where("user_id = ?", user.ID).Delete(&models.VerifyEmail{})
 	appdata.DB.Create(&verifyEmail)
 	verifyLink := fmt.Sprintf("https://versequick.com/verifyemail/%s", token)
 	emailBody := fmt.Sprintf("Click the link below to verify your email.<br><br><a href=%s>%s</a>", verifyLink, verifyLink)
-	err := utils.SendEmail(user.Email, "Verify your email", emailBody, true)
+	err = utils.SendEmail(user.Email, "Verify your email", emailBody, true)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Something went wrong, try again later",
@@ -247,7 +317,7 @@ func SendEmailVerificationEmail(c *fiber.Ctx) error {
 func VerifyEmail(c *fiber.Ctx) error {
 	token := c.FormValue("token")
 	var verifyEmail models.VerifyEmail
-	result := appdata.DB.Where("token = ?", token).First(&verifyEmail)
+	result := appdata.DB.Where("token_hash = ?", utils.HashToken(token)).First(&verifyEmail)
 	if result.Error != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Verification token invalid, get a new one at /sendemailverificationemail",
@@ -258,10 +328,7 @@ func VerifyEmail(c *fiber.Ctx) error {
 			"error": "Verification token expired, get a new one at /sendemailverificationemail",
 		})
 	}
-	var user models.User
-	appdata.DB.First(&user, verifyEmail.UserID)
-	user.IsActivated = true
-	appdata.DB.Save(&user)
+	appdata.DB.Model(&models.User{}).Where("id = ?", verifyEmail.UserID).Update("is_activated", true)
 	appdata.DB.Delete(&verifyEmail)
 	return c.JSON(fiber.Map{"message": "Email verified successfully"})
 }
@@ -294,6 +361,7 @@ func UpdateUserPreferences(c *fiber.Ctx) error {
 	copyIncludesUrl := c.FormValue("copy_includes_url")
 	markAsReadAutomatically := c.FormValue("mark_as_read_automatically")
 	useAbbreviationsForNav := c.FormValue("use_abbreviations_for_nav")
+	timezone := c.FormValue("timezone")
 	fontSize, fontSizeError := strconv.Atoi(fontSizeString)
 	marginSize, marginSizeError := strconv.Atoi(marginSizeString)
 	fontFamily, fontFamilyError := strconv.Atoi(fontFamilyString)
@@ -331,6 +399,18 @@ func UpdateUserPreferences(c *fiber.Ctx) error {
 	if theme != "" {
 		userPreferences.Theme = &theme
 	}
+	// An empty timezone clears it, which is different from not sending one
+	if hasFormValue(c, "timezone") {
+		if timezone == "" {
+			userPreferences.Timezone = nil
+		} else if _, ok := utils.LoadTimezone(timezone); ok {
+			userPreferences.Timezone = &timezone
+		} else {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Unknown time zone, use a name like Europe/London",
+			})
+		}
+	}
 	if fontSizeError == nil {
 		userPreferences.FontSize = int(fontSize)
 	}
@@ -353,6 +433,15 @@ func UpdateUserPreferences(c *fiber.Ctx) error {
 	return c.JSON(userPreferences)
 }
 
+// hasFormValue tells a form field sent empty apart from one not sent at all
+func hasFormValue(c *fiber.Ctx, key string) bool {
+	if c.Context().QueryArgs().Has(key) || c.Context().PostArgs().Has(key) {
+		return true
+	}
+	form, err := c.MultipartForm()
+	return err == nil && len(form.Value[key]) > 0
+}
+
 func DeleteUserPreferences(c *fiber.Ctx) error {
 	user_id := utils.GetUserFromJwt(c)
 	var userPreferences models.UserPreference
@@ -0,0 +1,347 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+	"users-api/app/appdata"
+	"users-api/app/models"
+	"users-api/app/utils"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	ceremonyRegistration = "registration"
+	ceremonyLogin        = "login"
+)
+
+// BeginPasskeyRegistration godoc
+// @Summary      Start registering a passkey
+// @Description  Returns the options for navigator.credentials.create(). Passkeys the user already has are excluded. Needs the password, or a session started in the last 10 minutes for accounts without one.
+// @Tags         webauthn
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        password  body  models.PasswordRequest  false  "Password of the account"
+// @Success      200  {object}  models.WebAuthnBeginResponse
+// @Failure      400  {object}  models.ErrorResponse
+// @Failure      401  {object}  models.ErrorResponse
+// @Failure      403  {object}  models.ErrorResponse
+// @Router       /webauthn/register/begin [post]
+func BeginPasskeyRegistration(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	var req models.PasswordRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+		}
+	}
+	var user models.User
+	if err := appdata.DB.First(&user, userID).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	// A passkey skips TOTP and outlives password resets, so a stolen access token alone must not be enough
+	if status, err := confirmRecentLogin(c, &user, req.Password); err != nil {
+		return c.Status(status).JSON(models.ErrorResponse{Error: err.Error()})
+	}
+	webAuthnUser, err := utils.LoadWebAuthnUser(&user)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+
+	options, session, err := utils.WebAuthn.BeginRegistration(webAuthnUser,
+		webauthn.WithExclusions(webauthn.Credentials(webAuthnUser.Credentials).CredentialDescriptors()),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	challengeID, err := saveWebAuthnChallenge(&userID, ceremonyRegistration, session)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.JSON(models.WebAuthnBeginResponse{ChallengeID: challengeID, Options: options})
+}
+
+// FinishPasskeyRegistration godoc
+// @Summary      Finish registering a passkey
+// @Description  Verifies the attestation returned by navigator.credentials.create() and stores the passkey. The account owner is emailed about it.
+// @Tags         webauthn
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        credential  body  models.WebAuthnFinishRequest  true  "Challenge ID, credential and a name for the passkey"
+// @Success      201  {object}  models.WebAuthnCredential
+// @Failure      400  {object}  models.ErrorResponse
+// @Router       /webauthn/register/finish [post]
+func FinishPasskeyRegistration(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	var req models.WebAuthnFinishRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+	}
+	challenge, session, err := takeWebAuthnChallenge(req.ChallengeID, ceremonyRegistration)
+	if err != nil || challenge.UserID == nil || *challenge.UserID != userID {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Registration expired or not found, start again"})
+	}
+
+	var user models.User
+	if err := appdata.DB.First(&user, userID).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	webAuthnUser, err := utils.LoadWebAuthnUser(&user)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(req.Credential)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Invalid credential"})
+	}
+	credential, err := utils.WebAuthn.CreateCredential(webAuthnUser, *session, parsed)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Passkey could not be verified"})
+	}
+
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	name := req.Name
+	if name == "" {
+		name = fmt.Sprintf("Passkey %d", len(webAuthnUser.Records)+1)
+	}
+	record := models.WebAuthnCredential{UserID: userID, CredentialID: credential.ID, Name: name, Data: data}
+	if err := appdata.DB.Create(&record).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "This passkey is already registered"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	utils.RecordSecurityEvent(userID, models.SecurityEventPasskeyAdded, utils.GetSessionFromJwt(c), c.IP(), fmt.Sprintf("passkey %d named %q", record.ID, name))
+	utils.NotifyPasskeyAdded(&user, name, c.IP())
+	return c.Status(fiber.StatusCreated).JSON(record)
+}
+
+// GetPasskeys godoc
+// @Summary      List passkeys
+// @Description  Returns the passkeys registered by the user.
+// @Tags         webauthn
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {array}   models.WebAuthnCredential
+// @Failure      401  {object}  models.ErrorResponse
+// @Router       /webauthn/credentials [get]
+func GetPasskeys(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	var records []models.WebAuthnCredential
+	if err := appdata.DB.Where("user_id = ?", userID).Order("created_at").Find(&records).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.JSON(records)
+}
+
+// DeletePasskey godoc
+// @Summary      Delete a passkey
+// @Description  Removes a passkey so it can no longer be used to log in.
+// @Tags         webauthn
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path  int  true  "Passkey ID"
+// @Success      200  {object}  models.GenericMessage
+// @Failure      404  {object}  models.ErrorResponse
+// @Router       /webauthn/credentials/{id} [delete]
+func DeletePasskey(c *fiber.Ctx) error {
+	userID := utils.GetUserFromJwt(c)
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Invalid passkey id"})
+	}
+	result := appdata.DB.Where("id = ? AND user_id = ?", id, userID).Delete(&models.WebAuthnCredential{})
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{Error: "Passkey not found"})
+	}
+	return c.JSON(models.GenericMessage{Message: "Passkey deleted"})
+}
+
+// BeginPasskeyLogin godoc
+// @Summary      Start a passkey login
+// @Description  Returns the options for navigator.credentials.get(). With an email or username of an account that has passkeys only those are allowed, otherwise the authenticator offers any passkey it holds for this site. The response doesn't reveal whether the account exists.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        account  body  models.WebAuthnLoginBeginRequest  false  "Optional account"
+// @Success      200  {object}  models.WebAuthnBeginResponse
+// @Failure      400  {object}  models.ErrorResponse
+// @Router       /login/webauthn/begin [post]
+func BeginPasskeyLogin(c *fiber.Ctx) error {
+	var req models.WebAuthnLoginBeginRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+		}
+	}
+
+	var webAuthnUser *utils.WebAuthnUser
+	if req.EmailOrUsername != "" {
+		var user models.User
+		err := appdata.DB.Where("email = ? OR username = ?", req.EmailOrUsername, req.EmailOrUsername).First(&user).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+		}
+		if err == nil {
+			webAuthnUser, err = utils.LoadWebAuthnUser(&user)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+			}
+		}
+	}
+
+	var options *protocol.CredentialAssertion
+	var session *webauthn.SessionData
+	var userID *uint
+	var err error
+	if webAuthnUser != nil && len(webAuthnUser.Credentials) > 0 {
+		userID = &webAuthnUser.User.ID
+		options, session, err = utils.WebAuthn.BeginLogin(webAuthnUser, webauthn.WithUserVerification(protocol.VerificationRequired))
+	} else {
+		// Unknown accounts and accounts without passkeys get a discoverable login, so the
+		// response doesn't tell whether the account exists or has a passkey
+		options, session, err = utils.WebAuthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+
+	challengeID, err := saveWebAuthnChallenge(userID, ceremonyLogin, session)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	return c.JSON(models.WebAuthnBeginResponse{ChallengeID: challengeID, Options: options})
+}
+
+// FinishPasskeyLogin godoc
+// @Summary      Finish a passkey login
+// @Description  Verifies the assertion returned by navigator.credentials.get() and returns a JWT access token and a refresh token, like /login.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        credential  body  models.WebAuthnFinishRequest  true  "Challenge ID, credential and login options"
+// @Success      200  {object}  models.LoginResponse
+// @Failure      401  {object}  models.ErrorResponse
+// @Router       /login/webauthn/finish [post]
+func FinishPasskeyLogin(c *fiber.Ctx) error {
+	var req models.WebAuthnFinishRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewInvalidRequestBodyError())
+	}
+	challenge, session, err := takeWebAuthnChallenge(req.ChallengeID, ceremonyLogin)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Login expired or not found, start again"})
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(req.Credential)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Invalid credential"})
+	}
+
+	var webAuthnUser *utils.WebAuthnUser
+	var credential *webauthn.Credential
+	if challenge.UserID != nil {
+		var user models.User
+		if err := appdata.DB.First(&user, *challenge.UserID).Error; err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{Error: "Passkey could not be verified"})
+		}
+		if webAuthnUser, err = utils.LoadWebAuthnUser(&user); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+		}
+		credential, err = utils.WebAuthn.ValidateLogin(webAuthnUser, *session, parsed)
+	} else {
+		credential, err = utils.WebAuthn.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+			id, err := utils.UserIDFromWebAuthnHandle(userHandle)
+			if err != nil {
+				return nil, err
+			}
+			var user models.User
+			if err := appdata.DB.First(&user, id).Error; err != nil {
+				return nil, err
+			}
+			webAuthnUser, err = utils.LoadWebAuthnUser(&user)
+			return webAuthnUser, err
+		}, *session, parsed)
+	}
+	if err != nil || webAuthnUser == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{Error: "Passkey could not be verified"})
+	}
+
+	// The library compares sign counts, a count that did not go up means the key was probably copied
+	if credential.Authenticator.CloneWarning {
+		utils.RecordSecurityEvent(webAuthnUser.User.ID, models.SecurityEventPasskeyCloned, "", c.IP(), fmt.Sprintf("sign count %d did not increase", credential.Authenticator.SignCount))
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{Error: "This passkey may have been cloned and can't be used, log in with your password"})
+	}
+
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if err := appdata.DB.Model(&models.WebAuthnCredential{}).
+		Where("user_id = ? AND credential_id = ?", webAuthnUser.User.ID, credential.ID).
+		Updates(map[string]interface{}{"data": data, "last_used_at": time.Now()}).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+
+	loginReq := models.LoginRequest{Remember: req.Remember, Device: req.Device, Location: req.Location}
+	return issueTokens(c, webAuthnUser.User, &loginReq)
+}
+
+func saveWebAuthnChallenge(userID *uint, ceremony string, session *webauthn.SessionData) (string, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	appdata.DB.Where("expires_at < ?", now).Delete(&models.WebAuthnChallenge{})
+	challenge := models.WebAuthnChallenge{
+		ID:          uuid.NewString(),
+		UserID:      userID,
+		Ceremony:    ceremony,
+		SessionData: data,
+		ExpiresAt:   now.Add(utils.WebAuthn.Config.Timeouts.Login.Timeout),
+	}
+	if err := appdata.DB.Create(&challenge).Error; err != nil {
+		return "", err
+	}
+	return challenge.ID, nil
+}
+
+// takeWebAuthnChallenge loads and deletes a challenge so each one can be
+// answered only once
+func takeWebAuthnChallenge(id string, ceremony string) (*models.WebAuthnChallenge, *webauthn.SessionData, error) {
+	var challenge models.WebAuthnChallenge
+	if err := appdata.DB.Where("id = ? AND ceremony = ?", id, ceremony).First(&challenge).Error; err != nil {
+		return nil, nil, err
+	}
+	result := appdata.DB.Delete(&challenge)
+	if result.Error != nil {
+		return nil, nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil, gorm.ErrRecordNotFound
+	}
+	if challenge.ExpiresAt.Before(time.Now()) {
+		return nil, nil, errors.New("challenge expired")
+	}
+	var session webauthn.SessionData
+	if err := json.Unmarshal(challenge.SessionData, &session); err != nil {
+		return nil, nil, err
+	}
+	return &challenge, &session, nil
+}
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"log"
+	"time"
+	"users-api/app/appdata"
+	"users-api/app/models"
+
+	"gorm.io/gorm"
+)
+
+// PurgeUser deletes the user together with everything stored about them.
+// Tables without a foreign key to users are cleared explicitly, the rest
+// goes with the user through ON DELETE CASCADE. Export files go last.
+func PurgeUser(userID uint) error {
+	err := appdata.DB.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{
+			&models.ReadHistory{},
+			&models.Bookmark{},
+			&models.Note{},
+			&models.ParallelTranslations{},
+			&models.UserPreference{},
+			&models.WebAuthnChallenge{},
+			&models.OAuthState{},
+		} {
+			if err := tx.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("key = ?", AccountLoginKey(&models.User{ID: userID}, "")).Delete(&models.LoginAttempt{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.User{}, userID).Error
+	})
+	if err != nil {
+		return err
+	}
+	return RemoveDataExports(userID)
+}
+
+// purgeDueAccounts deletes the accounts whose grace period is over
+func purgeDueAccounts() {
+	var userIDs []uint
+	if err := appdata.DB.Model(&models.AccountDeletion{}).Where("purge_at <= ?", time.Now()).Pluck("user_id", &userIDs).Error; err != nil {
+		log.Printf("failed to find accounts to purge: %v", err)
+		return
+	}
+	for _, userID := range userIDs {
+		if err := PurgeUser(userID); err != nil {
+			log.Printf("failed to purge user %d: %v", userID, err)
+			continue
+		}
+		log.Printf("purged user %d", userID)
+	}
+}
+
+// StartAccountPurge purges due accounts and expired data exports now and then
+// every interval for the lifetime of the process
+func StartAccountPurge(interval time.Duration) {
+	go func() {
+		purgeDueAccounts()
+		RemoveExpiredDataExports()
+		for range time.Tick(interval) {
+			purgeDueAccounts()
+			RemoveExpiredDataExports()
+		}
+	}()
+}
@@ -0,0 +1,218 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"users-api/app/appdata"
+)
+
+// Other names of the books, in the order of appdata.Books: the OSIS ID, the
+// SBL and Logos abbreviations and other common spellings. Matching ignores
+// case, spaces and dots.
+var bookAliases = [][]string{
+	{"Gen", "Ge", "Gn"},
+	{"Exod", "Ex", "Exo"},
+	{"Lev", "Le", "Lv"},
+	{"Num", "Nu", "Nm"},
+	{"Deut", "Dt", "De"},
+	{"Josh", "Jos"},
+	{"Judg", "Jdg", "Jg"},
+	{"Ru", "Rth"},
+	{"1Sam", "1Sa", "1Samuel"},
+	{"2Sam", "2Sa", "2Samuel"},
+	{"1Kgs", "1Ki", "1Kings"},
+	{"2Kgs", "2Ki", "2Kings"},
+	{"1Chr", "1Ch", "1Chronicles"},
+	{"2Chr", "2Ch", "2Chronicles"},
+	{"Ezr"},
+	{"Neh", "Ne"},
+	{"Esth", "Es"},
+	{"Jb"},
+	{"Ps", "Psalms", "Pss", "Psa"},
+	{"Prov", "Pr", "Prv"},
+	{"Eccl", "Ec", "Qoh", "Ecclesiastes"},
+	{"Song", "So", "SongofSongs", "Canticles", "Cant"},
+	{"Isa", "Is"},
+	{"Jer", "Je"},
+	{"Lam", "La"},
+	{"Ezek", "Eze", "Ezk"},
+	{"Dan", "Da", "Dn"},
+	{"Hos", "Ho"},
+	{"Joel", "Joe", "Jl"},
+	{"Amos", "Am"},
+	{"Obad", "Ob"},
+	{"Jonah", "Jon"},
+	{"Mic", "Mi"},
+	{"Nah", "Na"},
+	{"Hab", "Hb"},
+	{"Zeph", "Zep"},
+	{"Hag", "Hg"},
+	{"Zech", "Zec"},
+	{"Mal", "Ml"},
+	{"Matt", "Mt"},
+	{"Mark", "Mk", "Mar"},
+	{"Luke", "Lk", "Luk"},
+	{"John", "Jn", "Jhn"},
+	{"Acts", "Ac"},
+	{"Rom", "Ro", "Rm"},
+	{"1Cor", "1Co"},
+	{"2Cor", "2Co"},
+	{"Gal", "Ga"},
+	{"Eph"},
+	{"Phil", "Php", "Pp"},
+	{"Col"},
+	{"1Thess", "1Th"},
+	{"2Thess", "2Th"},
+	{"1Tim", "1Ti"},
+	{"2Tim", "2Ti"},
+	{"Titus", "Tt"},
+	{"Phlm", "Phm", "Philem"},
+	{"Heb"},
+	{"Jas", "Jm"},
+	{"1Pet", "1Pe", "1Pt"},
+	{"2Pet", "2Pe", "2Pt"},
+	{"1John", "1Jn", "1Jhn"},
+	{"2John", "2Jn", "2Jhn"},
+	{"3John", "3Jn", "3Jhn"},
+	{"Jud", "Jd"},
+	{"Re", "Rv", "Revelations", "TheRevelation"},
+}
+
+var bookIndex = buildBookIndex()
+
+// Roman numerals some apps put in front of 1 Samuel, 2 Kings, ...
+var romanBookNumber = regexp.MustCompile(`^(iii|ii|i)\s+`)
+
+func normalizeBookName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	name = romanBookNumber.ReplaceAllStringFunc(name, func(numeral string) string {
+		return strconv.Itoa(len(strings.TrimSpace(numeral)))
+	})
+	return strings.NewReplacer(" ", "", ".", "", "_", "").Replace(name)
+}
+
+func buildBookIndex() map[string]int {
+	index := make(map[string]int)
+	add := func(name string, i int) {
+		key := normalizeBookName(name)
+		if other, ok := index[key]; ok && other != i {
+			panic(fmt.Sprintf("book alias %s is ambiguous", name))
+		}
+		index[key] = i
+	}
+	for i, book := range appdata.Books {
+		add(book.Book, i)
+		add(book.Abbreviation, i)
+		for _, alias := range bookAliases[i] {
+			add(alias, i)
+		}
+	}
+	return index
+}
+
+// FindBook returns the position in appdata.Books of the book with the given
+// name, USFM code or common abbreviation
+func FindBook(name string) (int, bool) {
+	i, ok := bookIndex[normalizeBookName(name)]
+	return i, ok
+}
+
+// VerseRef points to a verse. Book is the position in appdata.Books.
+type VerseRef struct {
+	Book    int
+	Chapter uint
+	Verse   uint
+}
+
+func (ref VerseRef) BookName() string {
+	return appdata.Books[ref.Book].Book
+}
+
+func (ref VerseRef) String() string {
+	return fmt.Sprintf("%s %d:%d", ref.BookName(), ref.Chapter, ref.Verse)
+}
+
+// A book followed by a chapter and a verse, separated the OSIS and USFM way
+// (Gen.1.1, GEN.1.1) or the usual way (Genesis 1:1, 1 Sam 3:4). Anything
+// after the first verse, like the end of a range, is ignored.
+var verseRefPattern = regexp.MustCompile(`^\s*(.*?[A-Za-z])\.?\s*(\d+)(?:\s*[:.,]\s*(\d+))?(?:\s*[-–—+].*)?\s*$`)
+
+// ParseVerseRef reads a reference like "John 3:16", "Jn 3.16", "JHN.3.16" or
+// "John.3.16-John.3.18". A reference to a whole chapter points to its first
+// verse.
+func ParseVerseRef(reference string) (VerseRef, error) {
+	match := verseRefPattern.FindStringSubmatch(reference)
+	if match == nil {
+		return VerseRef{}, errors.New("not a verse reference")
+	}
+	book, ok := FindBook(match[1])
+	if !ok {
+		return VerseRef{}, fmt.Errorf("unknown book %q", match[1])
+	}
+	chapter, _ := strconv.ParseUint(match[2], 10, 32)
+	verse := uint64(1)
+	if match[3] != "" {
+		verse, _ = strconv.ParseUint(match[3], 10, 32)
+	} else if appdata.Books[book].Chapters == 1 {
+		// Books with one chapter are cited by verse alone, like Jude 3
+		chapter, verse = 1, chapter
+	}
+	ref := VerseRef{Book: book, Chapter: uint(chapter), Verse: uint(verse)}
+	return ref, ref.Validate()
+}
+
+// Validate checks that the chapter and the verse exist in the book
+func (ref VerseRef) Validate() error {
+	if ref.Book < 0 || ref.Book >= len(appdata.Books) {
+		return errors.New("unknown book")
+	}
+	book := appdata.Books[ref.Book]
+	if ref.Chapter < 1 || ref.Chapter > book.Chapters {
+		return fmt.Errorf("%s has chapters 1 to %d", book.Book, book.Chapters)
+	}
+	if ref.Verse < 1 || ref.Verse > appdata.ChapterVerses[ref.Book][ref.Chapter-1] {
+		return fmt.Errorf("verse %d doesn't exist in %s %d", ref.Verse, book.Book, ref.Chapter)
+	}
+	return nil
+}
+
+// ValidateVerseRange checks a range from start to endChapter:endVerse in the
+// book of start. Ranges may go over several chapters but not books.
+func ValidateVerseRange(start VerseRef, endChapter uint, endVerse uint) error {
+	if err := start.Validate(); err != nil {
+		return err
+	}
+	end := VerseRef{Book: start.Book, Chapter: endChapter, Verse: endVerse}
+	if err := end.Validate(); err != nil {
+		return fmt.Errorf("end of range: %w", err)
+	}
+	if endChapter < start.Chapter || (endChapter == start.Chapter && endVerse < start.Verse) {
+		return errors.New("the range ends before it starts")
+	}
+	return nil
+}
+
+// ParseRangeBound reads the start or, if end is set, the end of a range of
+// references. A whole book or chapter, like "Genesis" or "Genesis 3", starts
+// at its first verse and ends at its last.
+func ParseRangeBound(reference string, end bool) (VerseRef, error) {
+	if book, ok := FindBook(reference); ok {
+		if !end {
+			return VerseRef{Book: book, Chapter: 1, Verse: 1}, nil
+		}
+		chapters := appdata.Books[book].Chapters
+		return VerseRef{Book: book, Chapter: chapters, Verse: appdata.ChapterVerses[book][chapters-1]}, nil
+	}
+	ref, err := ParseVerseRef(reference)
+	if err != nil || !end {
+		return ref, err
+	}
+	match := verseRefPattern.FindStringSubmatch(reference)
+	if match[3] == "" && appdata.Books[ref.Book].Chapters > 1 {
+		ref.Verse = appdata.ChapterVerses[ref.Book][ref.Chapter-1]
+	}
+	return ref, nil
+}
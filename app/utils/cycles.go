@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"users-api/app/appdata"
+	"users-api/app/models"
+
+	"gorm.io/gorm"
+)
+
+// CycleName is the name a cycle gets when the user doesn't give one
+func CycleName(number int64) string {
+	return fmt.Sprintf("Read-through %d", number)
+}
+
+// CurrentCycle returns the cycle the user is reading in, starting their first
+// one if they have none
+func CurrentCycle(userID uint) (models.ReadingCycle, error) {
+	var cycle models.ReadingCycle
+	err := appdata.DB.Where("user_id = ? AND ended_at IS NULL", userID).First(&cycle).Error
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return cycle, err
+	}
+	cycle, err = StartCycle(userID, "")
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		// Another request started it meanwhile
+		err = appdata.DB.Where("user_id = ? AND ended_at IS NULL", userID).First(&cycle).Error
+	}
+	return cycle, err
+}
+
+// StartCycle ends the current cycle of the user, if any, and starts a new one.
+// An empty name numbers the cycle.
+func StartCycle(userID uint, name string) (models.ReadingCycle, error) {
+	now := time.Now()
+	cycle := models.ReadingCycle{UserID: userID, Name: name, StartedAt: now}
+	err := appdata.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.ReadingCycle{}).Where("user_id = ? AND ended_at IS NULL", userID).Update("ended_at", now).Error; err != nil {
+			return err
+		}
+		if cycle.Name == "" {
+			var count int64
+			if err := tx.Model(&models.ReadingCycle{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+				return err
+			}
+			cycle.Name = CycleName(count + 1)
+		}
+		return tx.Create(&cycle).Error
+	})
+	return cycle, err
+}
@@ -0,0 +1,370 @@
+package utils
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"users-api/app/appdata"
+	"users-api/app/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TokenTypeDataExport is the typ of the signed link to a prepared export
+const TokenTypeDataExport = "data_export"
+
+// DataExportInlineRows is the most rows an export can have to be built while
+// the client waits. Larger accounts get a download link by email.
+const DataExportInlineRows = 2000
+
+var dataExportFileName = regexp.MustCompile(`^[A-Za-z0-9_-]+\.zip$`)
+
+// CountDataExportRows returns how many rows the export of the user has
+func CountDataExportRows(userID uint) (int64, error) {
+	var total int64
+	for _, model := range []interface{}{&models.ReadHistory{}, &models.Bookmark{}, &models.Note{}, &models.Highlight{}} {
+		var count int64
+		if err := appdata.DB.Model(model).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// WriteDataExport writes a ZIP archive of everything stored about the user:
+// JSON files of every kind of data and a CSV copy of the tables
+func WriteDataExport(userID uint, w io.Writer) error {
+	var user models.User
+	if err := appdata.DB.First(&user, userID).Error; err != nil {
+		return err
+	}
+	var preference models.UserPreference
+	if err := appdata.DB.Where("user_id = ?", userID).Limit(1).Find(&preference).Error; err != nil {
+		return err
+	}
+	user.Preference = preference
+	var history []models.ReadHistory
+	if err := appdata.DB.Where("user_id = ?", userID).Order("created_at").Find(&history).Error; err != nil {
+		return err
+	}
+	var cycles []models.ReadingCycle
+	if err := appdata.DB.Where("user_id = ?", userID).Order("started_at").Find(&cycles).Error; err != nil {
+		return err
+	}
+	var bookmarks []models.Bookmark
+	if err := appdata.DB.Where("user_id = ?", userID).Order("position, created_at").Find(&bookmarks).Error; err != nil {
+		return err
+	}
+	var folders []models.BookmarkFolder
+	if err := appdata.DB.Where("user_id = ?", userID).Order("position").Find(&folders).Error; err != nil {
+		return err
+	}
+	var notes []models.Note
+	if err := appdata.DB.Where("user_id = ?", userID).Order("created_at").Find(&notes).Error; err != nil {
+		return err
+	}
+	var highlights []models.Highlight
+	if err := appdata.DB.Where("user_id = ?", userID).Order("book, chapter, verse").Find(&highlights).Error; err != nil {
+		return err
+	}
+	var translations []models.ParallelTranslations
+	if err := appdata.DB.Where("user_id = ?", userID).Order("created_at").Find(&translations).Error; err != nil {
+		return err
+	}
+	var tokens []models.RefreshToken
+	if err := appdata.DB.Where("user_id = ? AND revoked = ? AND expires_at > ?", userID, false, time.Now()).Order("started_at").Find(&tokens).Error; err != nil {
+		return err
+	}
+	sessions := make([]models.Session, 0, len(tokens))
+	for i := range tokens {
+		sessions = append(sessions, models.NewSession(&tokens[i], ""))
+	}
+
+	archive := zip.NewWriter(w)
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", user},
+		{"preferences.json", preference},
+		{"read_history.json", history},
+		{"reading_cycles.json", cycles},
+		{"bookmarks.json", bookmarks},
+		{"bookmark_folders.json", folders},
+		{"notes.json", notes},
+		{"highlights.json", highlights},
+		{"parallel_translations.json", translations},
+		{"sessions.json", sessions},
+	}
+	for _, file := range files {
+		if err := writeJSONFile(archive, file.name, file.data); err != nil {
+			return err
+		}
+	}
+
+	historyRows := [][]string{{"cycle_id", "book", "chapter", "created_at"}}
+	for _, h := range history {
+		historyRows = append(historyRows, []string{formatUint(h.CycleID), formatUint(h.Book), formatUint(h.Chapter), formatTime(h.CreatedAt)})
+	}
+	cycleRows := [][]string{{"id", "name", "started_at", "ended_at"}}
+	for _, cycle := range cycles {
+		endedAt := ""
+		if cycle.EndedAt != nil {
+			endedAt = formatTime(*cycle.EndedAt)
+		}
+		cycleRows = append(cycleRows, []string{formatUint(cycle.ID), cycle.Name, formatTime(cycle.StartedAt), endedAt})
+	}
+	folderNames := make(map[uint]string, len(folders))
+	for _, folder := range folders {
+		folderNames[folder.ID] = folder.Name
+	}
+	bookmarkRows := [][]string{{"book", "chapter_number", "verse_number", "end_chapter", "end_verse", "title", "color", "folder", "created_at"}}
+	for _, b := range bookmarks {
+		folder := ""
+		if b.FolderID != nil {
+			folder = folderNames[*b.FolderID]
+		}
+		bookmarkRows = append(bookmarkRows, []string{b.Book, formatUint(b.ChapterNumber), formatUint(b.VerseNumber), formatUint(b.EndChapter), formatUint(b.EndVerse), b.Title, derefString(b.Color), folder, formatTime(b.CreatedAt)})
+	}
+	noteRows := [][]string{{"book", "chapter_number", "verse_number", "note", "tags", "created_at", "updated_at"}}
+	for _, n := range notes {
+		noteRows = append(noteRows, []string{n.Book, formatUint(n.ChapterNumber), formatUint(n.VerseNumber), n.Note, strings.Join(n.Tags, ","), formatTime(n.CreatedAt), formatTime(n.UpdatedAt)})
+	}
+	highlightRows := [][]string{{"book", "chapter", "verse", "end_chapter", "end_verse", "color", "style", "created_at"}}
+	for _, h := range highlights {
+		highlightRows = append(highlightRows, []string{formatUint(h.Book), formatUint(h.Chapter), formatUint(h.Verse), formatUint(h.EndChapter), formatUint(h.EndVerse), h.Color, h.Style, formatTime(h.CreatedAt)})
+	}
+	translationRows := [][]string{{"translation_1", "translation_2", "created_at"}}
+	for _, t := range translations {
+		translationRows = append(translationRows, []string{t.Translation1, t.Translation2, formatTime(t.CreatedAt)})
+	}
+	sessionRows := [][]string{{"device", "location", "client_id", "scope", "created_at", "last_refreshed_at", "expires_at"}}
+	for _, s := range sessions {
+		sessionRows = append(sessionRows, []string{derefString(s.Device), derefString(s.Location), derefString(s.ClientID), s.Scope, formatTime(s.CreatedAt), formatTime(s.LastRefreshedAt), formatTime(s.ExpiresAt)})
+	}
+	tables := []struct {
+		name string
+		rows [][]string
+	}{
+		{"read_history.csv", historyRows},
+		{"reading_cycles.csv", cycleRows},
+		{"bookmarks.csv", bookmarkRows},
+		{"notes.csv", noteRows},
+		{"highlights.csv", highlightRows},
+		{"parallel_translations.csv", translationRows},
+		{"sessions.csv", sessionRows},
+	}
+	for _, table := range tables {
+		if err := writeCSVFile(archive, table.name, table.rows); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// dataExportJobTimeout is how long an export can be building before it is
+// assumed lost, e.g. to a restart, and a new one may start
+const dataExportJobTimeout = time.Hour
+
+// ClaimDataExport records that an export of the user is being built. It
+// returns false, together with the recorded export, while another one is
+// still being built or its link is still valid.
+func ClaimDataExport(userID uint) (bool, models.DataExport, error) {
+	var existing models.DataExport
+	claimed := false
+	err := appdata.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Where("user_id = ? AND (ready_at < ? OR (ready_at IS NULL AND requested_at < ?))", userID,
+			now.Add(-time.Duration(appdata.DataExportValidMinutes)*time.Minute), now.Add(-dataExportJobTimeout)).
+			Delete(&models.DataExport{}).Error; err != nil {
+			return err
+		}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.DataExport{UserID: userID, RequestedAt: now})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 1 {
+			claimed = true
+			return nil
+		}
+		return tx.First(&existing, userID).Error
+	})
+	return claimed, existing, err
+}
+
+// FinishDataExport records the file a claimed export was saved to. Without
+// a file the claim is dropped, so that the user can try again.
+func FinishDataExport(userID uint, file string) error {
+	if file == "" {
+		return appdata.DB.Delete(&models.DataExport{}, userID).Error
+	}
+	return appdata.DB.Model(&models.DataExport{UserID: userID}).Updates(map[string]interface{}{"file": file, "ready_at": time.Now()}).Error
+}
+
+// SaveDataExport writes the export of the user to DATA_EXPORT_DIR and returns
+// the name of the file. Previous exports of the user are removed first.
+func SaveDataExport(userID uint) (string, error) {
+	if err := os.MkdirAll(appdata.DataExportDir, 0o700); err != nil {
+		return "", err
+	}
+	if err := RemoveDataExports(userID); err != nil {
+		return "", err
+	}
+	token, err := GenerateToken()
+	if err != nil {
+		return "", err
+	}
+	name := dataExportPrefix(userID) + token + ".zip"
+	path := filepath.Join(appdata.DataExportDir, name)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", err
+	}
+	if err := WriteDataExport(userID, file); err != nil {
+		file.Close()
+		os.Remove(path)
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return name, nil
+}
+
+// OpenDataExport opens a file written by SaveDataExport for the user
+func OpenDataExport(userID uint, name string) (*os.File, error) {
+	if !dataExportFileName.MatchString(name) || !strings.HasPrefix(name, dataExportPrefix(userID)) {
+		return nil, os.ErrNotExist
+	}
+	return os.Open(filepath.Join(appdata.DataExportDir, name))
+}
+
+// Export files start with the ID of their user, so that they can be found
+// without the database
+func dataExportPrefix(userID uint) string {
+	return formatUint(userID) + "-"
+}
+
+// RemoveDataExports removes the export files of the user
+func RemoveDataExports(userID uint) error {
+	entries, err := os.ReadDir(appdata.DataExportDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var errs []error
+	for _, entry := range entries {
+		if dataExportFileName.MatchString(entry.Name()) && strings.HasPrefix(entry.Name(), dataExportPrefix(userID)) {
+			if err := os.Remove(filepath.Join(appdata.DataExportDir, entry.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RemoveExpiredDataExports removes the export files older than their links,
+// and forgets the exports of large accounts whose links expired
+func RemoveExpiredDataExports() {
+	cutoff := time.Now().Add(-time.Duration(appdata.DataExportValidMinutes) * time.Minute)
+	if err := appdata.DB.Where("ready_at < ?", cutoff).Delete(&models.DataExport{}).Error; err != nil {
+		log.Printf("failed to delete expired data exports: %v", err)
+	}
+	entries, err := os.ReadDir(appdata.DataExportDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err == nil && dataExportFileName.MatchString(entry.Name()) && info.ModTime().Before(cutoff) {
+			os.Remove(filepath.Join(appdata.DataExportDir, entry.Name()))
+		}
+	}
+}
+
+// PrepareDataExportToken signs the download link of a saved export. Logging
+// out everywhere invalidates it like an access token.
+func PrepareDataExportToken(user *models.User, file string) string {
+	claims := jwt.MapClaims{
+		"typ":  TokenTypeDataExport,
+		"id":   user.ID,
+		"ver":  user.TokenVersion,
+		"file": file,
+		"exp":  time.Now().Add(time.Duration(appdata.DataExportValidMinutes) * time.Minute).Unix(),
+	}
+	signedToken, err := Keys.Sign(claims)
+	if err != nil {
+		return ""
+	}
+	return signedToken
+}
+
+// ParseDataExportToken returns the user and the file a download link is for
+func ParseDataExportToken(tokenString string) (uint, string, error) {
+	claims, err := ParseToken(tokenString, TokenTypeDataExport)
+	if err != nil {
+		return 0, "", err
+	}
+	userID, _ := claims["id"].(float64)
+	version, _ := claims["ver"].(float64)
+	file, _ := claims["file"].(string)
+	currentVersion, err := Revocations.TokenVersion(uint(userID))
+	if err != nil {
+		return 0, "", err
+	}
+	if uint(version) < currentVersion {
+		return 0, "", errors.New("download link was revoked")
+	}
+	return uint(userID), file, nil
+}
+
+func writeJSONFile(archive *zip.Writer, name string, data interface{}) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(data)
+}
+
+func writeCSVFile(archive *zip.Writer, name string, rows [][]string) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(file)
+	if err := writer.WriteAll(rows); err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+func formatUint(n uint) string {
+	return strconv.FormatUint(uint64(n), 10)
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
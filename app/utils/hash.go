@@ -1,15 +1,63 @@
 package utils
 
 import (
+	"errors"
+	"log"
+	"strings"
+
 	"github.com/alexedwards/argon2id"
+	"golang.org/x/crypto/bcrypt"
 )
 
-func HashPassword(s string) string {
-	hash, _ := argon2id.CreateHash(s, argon2id.DefaultParams)
-	return hash
+// PasswordParams are the argon2id parameters new password hashes are created
+// with, set from ARGON2_* in InitializeApp
+var PasswordParams = argon2id.DefaultParams
+
+func HashPassword(s string) (string, error) {
+	return argon2id.CreateHash(s, PasswordParams)
 }
 
 func CheckPassword(password string, hash string) bool {
-	ans, _ := argon2id.ComparePasswordAndHash(password, hash)
-	return ans
+	match, _ := VerifyPassword(password, hash)
+	return match
+}
+
+// VerifyPassword checks a password against an argon2id hash or a bcrypt hash
+// of an imported account. needsRehash is set when the password matched a hash
+// weaker than PasswordParams, which should then be replaced while the
+// plaintext is at hand.
+func VerifyPassword(password string, hash string) (match bool, needsRehash bool) {
+	if hash == "" {
+		// Accounts created through a social login have no password
+		return false, false
+	}
+	if isBcryptHash(hash) {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err != nil && !errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			log.Printf("failed to check bcrypt password hash: %v", err)
+		}
+		return err == nil, err == nil
+	}
+	match, params, err := argon2id.CheckHash(password, hash)
+	if err != nil {
+		log.Printf("failed to check argon2id password hash: %v", err)
+		return false, false
+	}
+	if !match {
+		return false, false
+	}
+	// Parallelism isn't compared, its default depends on the CPUs of the host
+	return true, params.Memory < PasswordParams.Memory ||
+		params.Iterations < PasswordParams.Iterations ||
+		params.SaltLength < PasswordParams.SaltLength ||
+		params.KeyLength < PasswordParams.KeyLength
+}
+
+func isBcryptHash(hash string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2x$", "$2y$"} {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+	return false
 }
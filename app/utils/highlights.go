@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"users-api/app/appdata"
+	"users-api/app/models"
+
+	"gorm.io/gorm"
+)
+
+// Styles a highlight can have
+const (
+	HighlightStyleHighlight = "highlight"
+	HighlightStyleUnderline = "underline"
+)
+
+// versePosition orders verses within a book
+func versePosition(chapter uint, verse uint) uint {
+	return chapter<<10 | verse
+}
+
+// previousVerse returns the verse before chapter:verse in the book, which
+// must not be its first verse
+func previousVerse(book uint, chapter uint, verse uint) (uint, uint) {
+	if verse > 1 {
+		return chapter, verse - 1
+	}
+	return chapter - 1, appdata.ChapterVerses[book-1][chapter-2]
+}
+
+// nextVerse returns the verse after chapter:verse in the book, which must not
+// be its last verse
+func nextVerse(book uint, chapter uint, verse uint) (uint, uint) {
+	if verse < appdata.ChapterVerses[book-1][chapter-1] {
+		return chapter, verse + 1
+	}
+	return chapter + 1, 1
+}
+
+// SubtractVerseRange returns what is left of the highlight once the verses
+// from chapter:verse to endChapter:endVerse are taken out of it: nothing, the
+// part before or after the range, or both.
+func SubtractVerseRange(highlight models.Highlight, chapter uint, verse uint, endChapter uint, endVerse uint) []models.Highlight {
+	start, end := versePosition(chapter, verse), versePosition(endChapter, endVerse)
+	if versePosition(highlight.EndChapter, highlight.EndVerse) < start || versePosition(highlight.Chapter, highlight.Verse) > end {
+		return []models.Highlight{highlight}
+	}
+	var parts []models.Highlight
+	if versePosition(highlight.Chapter, highlight.Verse) < start {
+		before := highlight
+		before.ID = 0
+		before.EndChapter, before.EndVerse = previousVerse(highlight.Book, chapter, verse)
+		parts = append(parts, before)
+	}
+	if versePosition(highlight.EndChapter, highlight.EndVerse) > end {
+		after := highlight
+		after.ID = 0
+		after.Chapter, after.Verse = nextVerse(highlight.Book, endChapter, endVerse)
+		parts = append(parts, after)
+	}
+	return parts
+}
+
+// HighlightsOverlap tells whether two highlights share a verse
+func HighlightsOverlap(a models.Highlight, b models.Highlight) bool {
+	return a.Book == b.Book &&
+		versePosition(a.Chapter, a.Verse) <= versePosition(b.EndChapter, b.EndVerse) &&
+		versePosition(b.Chapter, b.Verse) <= versePosition(a.EndChapter, a.EndVerse)
+}
+
+// ClearHighlights takes the verses from chapter:verse to endChapter:endVerse
+// out of the highlights of the user, shortening or splitting the highlights
+// that go beyond the range. tx must be a transaction: the highlights of the
+// user are locked until it ends, so that concurrent changes can't overlap.
+func ClearHighlights(tx *gorm.DB, userID uint, book uint, chapter uint, verse uint, endChapter uint, endVerse uint) error {
+	if err := lockUser(tx, lockHighlights, userID); err != nil {
+		return err
+	}
+	var overlapping []models.Highlight
+	err := tx.Where("user_id = ? AND book = ? AND (chapter, verse) <= (?, ?) AND (end_chapter, end_verse) >= (?, ?)",
+		userID, book, endChapter, endVerse, chapter, verse).Find(&overlapping).Error
+	if err != nil || len(overlapping) == 0 {
+		return err
+	}
+	var rest []models.Highlight
+	ids := make([]uint, 0, len(overlapping))
+	for _, highlight := range overlapping {
+		ids = append(ids, highlight.ID)
+		rest = append(rest, SubtractVerseRange(highlight, chapter, verse, endChapter, endVerse)...)
+	}
+	if err := tx.Where("id IN ?", ids).Delete(&models.Highlight{}).Error; err != nil {
+		return err
+	}
+	if len(rest) == 0 {
+		return nil
+	}
+	return tx.Create(&rest).Error
+}
+
+// ApplyHighlight highlights the range of the highlight, replacing the
+// highlights of the verses in it. tx must be a transaction, like for
+// ClearHighlights.
+func ApplyHighlight(tx *gorm.DB, highlight *models.Highlight) error {
+	if err := ClearHighlights(tx, highlight.UserID, highlight.Book, highlight.Chapter, highlight.Verse, highlight.EndChapter, highlight.EndVerse); err != nil {
+		return err
+	}
+	return tx.Create(highlight).Error
+}
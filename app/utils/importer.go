@@ -0,0 +1,275 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats POST /import understands
+const (
+	// CSV with a header row, as exported by Logos and most apps. Verses are
+	// given in a reference column (OSIS "Gen.1.1", USFM "GEN.1.1" or
+	// "Genesis 1:1") or in book, chapter and verse columns.
+	ImportFormatCSV = "csv"
+	// {"bookmarks": [...], "notes": [...]} where every entry has a reference
+	// or book, chapter and verse fields, notes a note and both optionally a
+	// created_at
+	ImportFormatJSON = "json"
+	// {"moments": [...]} as in YouVersion exports, where every moment has a
+	// kind, USFM references, content and created_dt
+	ImportFormatYouVersion = "youversion"
+)
+
+const (
+	ImportKindBookmark = "bookmark"
+	ImportKindNote     = "note"
+)
+
+// ImportMaxRows is the most entries one import may have
+const ImportMaxRows = 10000
+
+// ImportItem is one bookmark or note read from an import. Err is set when the
+// entry can't be imported, the other fields are then as far as they were read.
+type ImportItem struct {
+	Location  string // Where in the upload the entry is, like "line 3" or "notes[2]"
+	Kind      string
+	Reference string // As written in the upload
+	Ref       VerseRef
+	Note      string
+	CreatedAt *time.Time
+	Err       error
+}
+
+// DetectImportFormat guesses the format of an upload from its file name and
+// content
+func DetectImportFormat(filename string, data []byte) string {
+	trimmed := bytes.TrimSpace(data)
+	if strings.EqualFold(filepath.Ext(filename), ".csv") || len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return ImportFormatCSV
+	}
+	var probe struct {
+		Moments json.RawMessage `json:"moments"`
+	}
+	if json.Unmarshal(trimmed, &probe) == nil && probe.Moments != nil {
+		return ImportFormatYouVersion
+	}
+	return ImportFormatJSON
+}
+
+// ParseImport reads the bookmarks and notes of an upload. An error is only
+// returned when the upload can't be read at all; problems with single entries
+// are reported in their Err.
+func ParseImport(format string, data []byte) ([]ImportItem, error) {
+	var items []ImportItem
+	var err error
+	switch format {
+	case ImportFormatCSV:
+		items, err = parseImportCSV(data)
+	case ImportFormatJSON:
+		items, err = parseImportJSON(data)
+	case ImportFormatYouVersion:
+		items, err = parseImportYouVersion(data)
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(items) > ImportMaxRows {
+		return nil, fmt.Errorf("imports are limited to %d entries", ImportMaxRows)
+	}
+	return items, nil
+}
+
+var csvColumns = map[string][]string{
+	"reference": {"reference", "ref", "osisref", "osis", "usfm", "passage"},
+	"book":      {"book"},
+	"chapter":   {"chapter", "chapter_number"},
+	"verse":     {"verse", "verse_number", "versenumber"},
+	"note":      {"note", "notes", "content", "text", "body"},
+	"kind":      {"type", "kind"},
+	"created":   {"created_at", "created", "createdat", "date", "created_dt"},
+}
+
+func parseImportCSV(data []byte) ([]ImportItem, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.New("the CSV has no header row")
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		for column, names := range csvColumns {
+			for _, n := range names {
+				if _, taken := columns[column]; n == name && !taken {
+					columns[column] = i
+				}
+			}
+		}
+	}
+	_, hasReference := columns["reference"]
+	_, hasBook := columns["book"]
+	if verse, ok := columns["verse"]; ok && !hasReference && !hasBook {
+		// Without a book column, a verse column holds whole references
+		columns["reference"] = verse
+		hasReference = true
+	}
+	if !hasReference && !hasBook {
+		return nil, errors.New("the CSV needs a reference column or book, chapter and verse columns")
+	}
+
+	var items []ImportItem
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			items = append(items, ImportItem{Location: fmt.Sprintf("line %d", line), Err: err})
+			if len(items) > ImportMaxRows {
+				break
+			}
+			continue
+		}
+		field := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		entry := importEntry{
+			Reference: field("reference"),
+			Book:      field("book"),
+			Note:      field("note"),
+			Kind:      field("kind"),
+			CreatedAt: field("created"),
+		}
+		entry.Chapter, _ = strconv.ParseUint(field("chapter"), 10, 32)
+		entry.Verse, _ = strconv.ParseUint(field("verse"), 10, 32)
+		items = append(items, entry.item(fmt.Sprintf("line %d", line), ""))
+		if len(items) > ImportMaxRows {
+			break
+		}
+	}
+	return items, nil
+}
+
+func parseImportJSON(data []byte) ([]ImportItem, error) {
+	var upload struct {
+		Bookmarks []importEntry `json:"bookmarks"`
+		Notes     []importEntry `json:"notes"`
+	}
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return nil, fmt.Errorf("the JSON doesn't match the import schema: %v", err)
+	}
+	items := make([]ImportItem, 0, len(upload.Bookmarks)+len(upload.Notes))
+	for i, entry := range upload.Bookmarks {
+		items = append(items, entry.item(fmt.Sprintf("bookmarks[%d]", i), ImportKindBookmark))
+	}
+	for i, entry := range upload.Notes {
+		items = append(items, entry.item(fmt.Sprintf("notes[%d]", i), ImportKindNote))
+	}
+	return items, nil
+}
+
+func parseImportYouVersion(data []byte) ([]ImportItem, error) {
+	var upload struct {
+		Moments []struct {
+			Kind       string   `json:"kind"`
+			References []string `json:"references"`
+			Content    string   `json:"content"`
+			CreatedDt  string   `json:"created_dt"`
+		} `json:"moments"`
+	}
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return nil, fmt.Errorf("the JSON doesn't match the YouVersion export schema: %v", err)
+	}
+	items := make([]ImportItem, 0, len(upload.Moments))
+	for i, moment := range upload.Moments {
+		entry := importEntry{Kind: moment.Kind, Note: moment.Content, CreatedAt: moment.CreatedDt}
+		if len(moment.References) > 0 {
+			entry.Reference = moment.References[0]
+		}
+		items = append(items, entry.item(fmt.Sprintf("moments[%d]", i), ""))
+	}
+	return items, nil
+}
+
+// importEntry is an entry of any format before it is checked
+type importEntry struct {
+	Reference string `json:"reference"`
+	Book      string `json:"book"`
+	Chapter   uint64 `json:"chapter"`
+	Verse     uint64 `json:"verse"`
+	Note      string `json:"note"`
+	Kind      string `json:"type"`
+	CreatedAt string `json:"created_at"`
+}
+
+// item checks the entry. kind is the kind the format implies, or "" to take
+// it from the entry or else from whether it has a note.
+func (entry importEntry) item(location string, kind string) ImportItem {
+	item := ImportItem{Location: location, Reference: entry.Reference, Note: strings.TrimSpace(entry.Note)}
+	if kind == "" {
+		kind = strings.ToLower(strings.TrimSpace(entry.Kind))
+	}
+	switch kind {
+	case "":
+		kind = ImportKindBookmark
+		if item.Note != "" {
+			kind = ImportKindNote
+		}
+	case ImportKindBookmark, ImportKindNote:
+	default:
+		item.Kind = kind
+		item.Err = fmt.Errorf("%ss can't be imported", kind)
+		return item
+	}
+	item.Kind = kind
+
+	if entry.Reference != "" {
+		item.Ref, item.Err = ParseVerseRef(entry.Reference)
+	} else {
+		item.Reference = fmt.Sprintf("%s %d:%d", entry.Book, entry.Chapter, entry.Verse)
+		book, ok := FindBook(entry.Book)
+		if !ok {
+			item.Err = fmt.Errorf("unknown book %q", entry.Book)
+			return item
+		}
+		item.Ref = VerseRef{Book: book, Chapter: uint(entry.Chapter), Verse: uint(entry.Verse)}
+		item.Err = item.Ref.Validate()
+	}
+	if item.Err != nil {
+		return item
+	}
+	if kind == ImportKindNote && item.Note == "" {
+		item.Err = errors.New("note is empty")
+		return item
+	}
+	if entry.CreatedAt != "" {
+		item.CreatedAt = parseImportTime(entry.CreatedAt)
+	}
+	return item
+}
+
+// parseImportTime reads the usual timestamp formats of exports. Timestamps
+// that can't be read are left out and the entry gets the time of the import.
+func parseImportTime(value string) *time.Time {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02", "01/02/2006"} {
+		if t, err := time.Parse(layout, strings.TrimSpace(value)); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SigningKey is a private key used to sign access tokens. ID is published as
+// the kid header of the tokens and in the JWKS.
+type SigningKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	Private   crypto.Signer
+	CreatedAt time.Time
+}
+
+// KeyRing holds the keys loaded from a directory of PEM encoded private keys,
+// one key per <kid>.pem file. The newest key signs new tokens, older keys keep
+// verifying for retireAfter after they were superseded so that tokens signed
+// with them can run out. Keys are generated into the directory when it is
+// empty and whenever the signing key is older than rotateAfter.
+type KeyRing struct {
+	dir         string
+	alg         string
+	rotateAfter time.Duration
+	retireAfter time.Duration
+	mu          sync.RWMutex
+	keys        []*SigningKey // newest first
+	loadedAt    time.Time
+}
+
+// Keys is the key ring used for access tokens, loaded in InitializeApp
+var Keys *KeyRing
+
+// LoadKeyRing loads the keys in dir, generating a key with alg (RS256 or
+// EdDSA) if there is no usable key yet. A rotateAfter of zero disables
+// rotation.
+func LoadKeyRing(dir string, alg string, rotateAfter time.Duration, retireAfter time.Duration) (*KeyRing, error) {
+	if alg != jwt.SigningMethodRS256.Alg() && alg != jwt.SigningMethodEdDSA.Alg() {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	ring := &KeyRing{dir: dir, alg: alg, rotateAfter: rotateAfter, retireAfter: retireAfter}
+	if err := ring.Rotate(); err != nil {
+		return nil, err
+	}
+	return ring, nil
+}
+
+// Rotate reloads the directory, picking up keys added by other instances,
+// generates a new signing key if the current one is due and drops retired keys.
+func (ring *KeyRing) Rotate() error {
+	return ring.load(true)
+}
+
+func (ring *KeyRing) load(generate bool) error {
+	keys, err := readKeys(ring.dir)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if len(keys) == 0 && !generate {
+		return errors.New("no signing keys found in " + ring.dir)
+	}
+	if len(keys) == 0 || (generate && ring.rotateAfter > 0 && now.Sub(keys[0].CreatedAt) > ring.rotateAfter) {
+		key, err := ring.generateKey(now)
+		if err != nil {
+			return err
+		}
+		log.Printf("generated JWT signing key %s", key.ID)
+		keys = append([]*SigningKey{key}, keys...)
+	}
+	// A key is retired once the key after it has been signing for retireAfter
+	active := keys[:1]
+	for i := 1; i < len(keys); i++ {
+		if now.Sub(keys[i-1].CreatedAt) < ring.retireAfter {
+			active = append(active, keys[i])
+		}
+	}
+	ring.mu.Lock()
+	ring.keys = active
+	ring.loadedAt = now
+	ring.mu.Unlock()
+	return nil
+}
+
+// StartRotation calls Rotate every interval for the lifetime of the process
+func (ring *KeyRing) StartRotation(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if err := ring.Rotate(); err != nil {
+				log.Printf("failed to rotate JWT signing keys: %v", err)
+			}
+		}
+	}()
+}
+
+// SigningKey returns the key new tokens are signed with
+func (ring *KeyRing) SigningKey() *SigningKey {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+	return ring.keys[0]
+}
+
+// Sign signs the claims with the current signing key
+func (ring *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	key := ring.SigningKey()
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+// Keyfunc resolves the verification key of a token from its kid header. An
+// unknown kid may be a key another instance just rotated in, so the directory
+// is reloaded, at most once a minute.
+func (ring *KeyRing) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, loadedAt := ring.find(kid)
+	if key == nil && time.Since(loadedAt) > time.Minute {
+		if err := ring.load(false); err != nil {
+			log.Printf("failed to reload JWT signing keys: %v", err)
+		}
+		key, _ = ring.find(kid)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("token algorithm %s does not match key %s", token.Method.Alg(), kid)
+	}
+	return key.Private.Public(), nil
+}
+
+func (ring *KeyRing) find(kid string) (*SigningKey, time.Time) {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+	for _, key := range ring.keys {
+		if key.ID == kid {
+			return key, ring.loadedAt
+		}
+	}
+	return nil, ring.loadedAt
+}
+
+// JWK is the public part of a signing key in RFC 7517 format
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of every key that still verifies tokens
+func (ring *KeyRing) JWKS() JWKS {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+	set := JWKS{Keys: make([]JWK, 0, len(ring.keys))}
+	for _, key := range ring.keys {
+		jwk := JWK{Use: "sig", Alg: key.Method.Alg(), Kid: key.ID}
+		switch public := key.Private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+func (ring *KeyRing) generateKey(now time.Time) (*SigningKey, error) {
+	var private crypto.Signer
+	var err error
+	if ring.alg == jwt.SigningMethodEdDSA.Alg() {
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	} else {
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	kid := now.UTC().Format("20060102T150405Z")
+	path := filepath.Join(ring.dir, kid+".pem")
+	// O_EXCL so that two instances rotating at once don't overwrite each other's key
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if err := pem.Encode(file, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		return nil, err
+	}
+	return &SigningKey{ID: kid, Method: signingMethodFor(private), Private: private, CreatedAt: now}, nil
+}
+
+// readKeys parses every .pem file in dir, newest first by modification time
+func readKeys(dir string) ([]*SigningKey, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]*SigningKey, 0, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		private, err := parsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, &SigningKey{
+			ID:        strings.TrimSuffix(filepath.Base(path), ".pem"),
+			Method:    signingMethodFor(private),
+			Private:   private,
+			CreatedAt: info.ModTime(),
+		})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case ed25519.PrivateKey:
+		return key, nil
+	default:
+		return nil, errors.New("only RSA and Ed25519 keys are supported")
+	}
+}
+
+func signingMethodFor(key crypto.Signer) jwt.SigningMethod {
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
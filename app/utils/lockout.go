@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"users-api/app/appdata"
+	"users-api/app/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Failed logins are counted per account and per client IP. Once a counter
+// reaches its threshold the key is locked, starting at loginLockoutBase and
+// doubling with every further failure up to loginLockoutMax. Counters start
+// over after loginFailureWindow without a failure.
+const (
+	accountFailureThreshold = 5
+	ipFailureThreshold      = 20
+	loginLockoutBase        = time.Minute
+	loginLockoutMax         = time.Hour
+	loginFailureWindow      = 24 * time.Hour
+)
+
+// AccountLoginKey identifies the account counter. Unknown identifiers get a
+// counter too, so lockouts don't reveal which accounts exist.
+func AccountLoginKey(user *models.User, identifier string) string {
+	if user != nil {
+		return fmt.Sprintf("account:%d", user.ID)
+	}
+	return "account:" + identifier
+}
+
+func IPLoginKey(ip string) string {
+	return "ip:" + ip
+}
+
+// LoginLockedUntil returns the latest lockout among the keys, or the zero time
+// if none of them is locked
+func LoginLockedUntil(keys ...string) (time.Time, error) {
+	var attempts []models.LoginAttempt
+	if err := appdata.DB.Where("key IN ? AND locked_until > ?", keys, time.Now()).Find(&attempts).Error; err != nil {
+		return time.Time{}, err
+	}
+	var lockedUntil time.Time
+	for _, attempt := range attempts {
+		if attempt.LockedUntil.After(lockedUntil) {
+			lockedUntil = *attempt.LockedUntil
+		}
+	}
+	return lockedUntil, nil
+}
+
+// RecordLoginFailure counts a failed login for the key. It returns the end of
+// the lockout if the key is now locked, and whether this failure started it.
+func RecordLoginFailure(key string, isIP bool) (*time.Time, bool, error) {
+	threshold := uint(accountFailureThreshold)
+	if isIP {
+		threshold = ipFailureThreshold
+	}
+	now := time.Now()
+	attempt := models.LoginAttempt{Key: key, Failures: 1, LastFailedAt: now}
+	err := appdata.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "key"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"failures":       gorm.Expr("CASE WHEN login_attempts.last_failed_at < ? THEN 1 ELSE login_attempts.failures + 1 END", now.Add(-loginFailureWindow)),
+				"last_failed_at": now,
+			}),
+		}).Create(&attempt).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("key = ?", key).First(&attempt).Error; err != nil {
+			return err
+		}
+		if attempt.Failures < threshold {
+			return nil
+		}
+		lockout := loginLockoutBase << min(attempt.Failures-threshold, 10)
+		lockedUntil := now.Add(min(lockout, loginLockoutMax))
+		attempt.LockedUntil = &lockedUntil
+		return tx.Model(&attempt).Update("locked_until", lockedUntil).Error
+	})
+	if err != nil || attempt.LockedUntil == nil || attempt.LockedUntil.Before(now) {
+		return nil, false, err
+	}
+	return attempt.LockedUntil, attempt.Failures == threshold, nil
+}
+
+// ClearLoginFailures resets the counter of the key after a successful login
+func ClearLoginFailures(key string) {
+	if err := appdata.DB.Where("key = ?", key).Delete(&models.LoginAttempt{}).Error; err != nil {
+		log.Printf("failed to clear login failures of %s: %v", key, err)
+	}
+}
+
+// NotifyLoginLockout emails the account owner that their account was locked
+// after repeated failed logins
+func NotifyLoginLockout(user *models.User, ip string, lockedUntil time.Time) {
+	emailBody := fmt.Sprintf("There were %d failed attempts to log in to your account, the last one from %s.<br><br>"+
+		"Logging in with a password is blocked until %s. If this wasn't you, consider changing your password once you can log in again.",
+		accountFailureThreshold, ip, lockedUntil.UTC().Format("2006-01-02 15:04 MST"))
+	if err := SendEmail(user.Email, "Your account was temporarily locked", emailBody, true); err != nil {
+		log.Printf("failed to send lockout email to user %d: %v", user.ID, err)
+	}
+}
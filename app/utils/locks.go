@@ -0,0 +1,18 @@
+package utils
+
+import (
+	"gorm.io/gorm"
+)
+
+// Namespaces of the advisory locks taken per user. The namespace goes in the
+// high 32 bits of the lock key and the user ID in the low ones.
+const (
+	lockReadHistory int64 = iota + 1
+	lockHighlights
+)
+
+// lockUser takes the advisory lock of the user in a namespace. It is held
+// until tx commits or rolls back, and taking it again in tx doesn't block.
+func lockUser(tx *gorm.DB, namespace int64, userID uint) error {
+	return tx.Exec("SELECT pg_advisory_xact_lock(?)", namespace<<32|int64(userID)).Error
+}
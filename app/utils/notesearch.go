@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"users-api/app/appdata"
+)
+
+// NoteSearchConfig is the text search configuration notes are indexed and
+// searched with
+const NoteSearchConfig = "english"
+
+// Limits of note tags
+const (
+	NoteMaxTags      = 20
+	NoteMaxTagLength = 50
+)
+
+// NoteHTMLSQL is an SQL expression giving the note column escaped for HTML.
+// Search snippets are cut from it, so that the only markup in them is the
+// <mark> put around matches; notes can come from imported files.
+const NoteHTMLSQL = `replace(replace(replace(replace(replace(note, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&quot;'), '''', '&#39;')`
+
+// BookOrderSQL is an SQL expression giving the position, from 1, of the book
+// column, which holds names of appdata.Books, so that references can be
+// compared in Bible order
+var BookOrderSQL = func() string {
+	names := make([]string, 0, len(appdata.Books))
+	for _, book := range appdata.Books {
+		names = append(names, strconv.Quote(book.Book))
+	}
+	return "array_position('{" + strings.Join(names, ",") + "}'::text[], book)"
+}()
+
+// NormalizeTags trims and lowercases tags and drops empty and repeated ones
+func NormalizeTags(tags []string) ([]string, error) {
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len([]rune(tag)) > NoteMaxTagLength {
+			return nil, fmt.Errorf("tags have at most %d characters", NoteMaxTagLength)
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > NoteMaxTags {
+		return nil, fmt.Errorf("notes have at most %d tags", NoteMaxTags)
+	}
+	return normalized, nil
+}
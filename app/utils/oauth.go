@@ -0,0 +1,187 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+)
+
+const (
+	OAuthProviderGoogle = "google"
+	OAuthProviderGitHub = "github"
+	googleIssuer        = "https://accounts.google.com"
+)
+
+// OAuthProvider is an external identity provider users can sign in with.
+// OpenID Connect providers are set up from their discovery document, GitHub
+// only speaks plain OAuth2 and is read from its REST API.
+type OAuthProvider struct {
+	Name     string
+	Config   oauth2.Config
+	verifier *oidc.IDTokenVerifier // nil for GitHub
+}
+
+// ExternalIdentity is the account a provider vouched for
+type ExternalIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Username      string
+}
+
+// OAuthProviders holds the providers configured in OAUTH_PROVIDERS by name
+var OAuthProviders = map[string]*OAuthProvider{}
+
+// NewOAuthProvider configures a provider. Issuer is required for generic OIDC
+// providers and ignored for GitHub; Google defaults to its own issuer.
+func NewOAuthProvider(ctx context.Context, name string, clientID string, clientSecret string, issuer string, redirectURL string) (*OAuthProvider, error) {
+	provider := &OAuthProvider{
+		Name: name,
+		Config: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+		},
+	}
+	if name == OAuthProviderGitHub {
+		provider.Config.Endpoint = github.Endpoint
+		provider.Config.Scopes = []string{"read:user", "user:email"}
+		return provider, nil
+	}
+	if issuer == "" && name == OAuthProviderGoogle {
+		issuer = googleIssuer
+	}
+	if issuer == "" {
+		return nil, fmt.Errorf("no issuer configured for OAuth provider %s", name)
+	}
+	oidcProvider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+	provider.Config.Endpoint = oidcProvider.Endpoint()
+	provider.Config.Scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+	provider.verifier = oidcProvider.Verifier(&oidc.Config{ClientID: clientID})
+	return provider, nil
+}
+
+// AuthCodeURL returns the URL to send the user to. The PKCE verifier and the
+// nonce must be kept until the code comes back.
+func (p *OAuthProvider) AuthCodeURL(state string, verifier string, nonce string) string {
+	options := []oauth2.AuthCodeOption{oauth2.S256ChallengeOption(verifier)}
+	if p.verifier != nil {
+		options = append(options, oidc.Nonce(nonce))
+	}
+	return p.Config.AuthCodeURL(state, options...)
+}
+
+// Exchange redeems an authorization code and returns the identity it belongs to
+func (p *OAuthProvider) Exchange(ctx context.Context, code string, verifier string, nonce string) (*ExternalIdentity, error) {
+	token, err := p.Config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, err
+	}
+	if p.verifier == nil {
+		return fetchGitHubIdentity(ctx, p.Config.Client(ctx, token))
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("token response has no id_token")
+	}
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("id_token nonce does not match")
+	}
+	var claims struct {
+		Email             string `json:"email"`
+		EmailVerified     bool   `json:"email_verified"`
+		Name              string `json:"name"`
+		PreferredUsername string `json:"preferred_username"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	return &ExternalIdentity{
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+		Username:      claims.PreferredUsername,
+	}, nil
+}
+
+func fetchGitHubIdentity(ctx context.Context, client *http.Client) (*ExternalIdentity, error) {
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := getJSON(ctx, client, "https://api.github.com/user", &user); err != nil {
+		return nil, err
+	}
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(ctx, client, "https://api.github.com/user/emails", &emails); err != nil {
+		return nil, err
+	}
+	identity := &ExternalIdentity{Subject: strconv.FormatInt(user.ID, 10), Name: user.Name, Username: user.Login}
+	for _, email := range emails {
+		if email.Primary {
+			identity.Email = email.Email
+			identity.EmailVerified = email.Verified
+		}
+	}
+	return identity, nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+var usernameInvalidChars = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// UsernameBase suggests a username for a new account from the provider's
+// username or the local part of the email
+func UsernameBase(identity *ExternalIdentity) string {
+	base := identity.Username
+	if base == "" {
+		base, _, _ = strings.Cut(identity.Email, "@")
+	}
+	base = strings.Trim(usernameInvalidChars.ReplaceAllString(strings.ToLower(base), "_"), "_")
+	if len(base) > 20 {
+		base = base[:20]
+	}
+	if base == "" {
+		base = "reader"
+	}
+	return base
+}
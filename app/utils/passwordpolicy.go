@@ -0,0 +1,181 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+	"users-api/app/models"
+
+	"github.com/nbutton23/zxcvbn-go"
+)
+
+// Codes of the rules a password can break
+const (
+	PasswordTooShort         = "too_short"
+	PasswordTooLong          = "too_long"
+	PasswordBanned           = "banned"
+	PasswordSimilarToAccount = "similar_to_account"
+	PasswordTooWeak          = "too_weak"
+	PasswordBreached         = "breached"
+)
+
+// Longer passwords are refused so hashing can't be used to tie up the server
+const passwordMaxLength = 256
+
+// Passwords are always refused, on top of the list in PASSWORD_BANNED_LIST
+var defaultBannedPasswords = []string{"password", "versequick", "jesuschrist", "iloveyou", "letmein", "qwertyuiop", "123456789"}
+
+// PasswordPolicy decides which passwords can be set on signup, reset and
+// change. Existing passwords keep working when the policy gets stricter.
+type PasswordPolicy struct {
+	MinLength int
+	MinScore  int // zxcvbn score from 0 (guessable) to 4 (very unguessable)
+	Banned    map[string]bool
+	Breaches  *BreachedPasswords // nil when no corpus is configured
+}
+
+// Passwords is the policy set up in InitializeApp
+var Passwords *PasswordPolicy
+
+// NewPasswordPolicy returns a policy with the default banned passwords and
+// those in bannedListFile, one per line, if it is set
+func NewPasswordPolicy(minLength int, minScore int, bannedListFile string) (*PasswordPolicy, error) {
+	policy := &PasswordPolicy{MinLength: minLength, MinScore: minScore, Banned: map[string]bool{}}
+	for _, password := range defaultBannedPasswords {
+		policy.Banned[password] = true
+	}
+	if bannedListFile == "" {
+		return policy, nil
+	}
+	file, err := os.Open(bannedListFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := normalizePassword(scanner.Text()); line != "" {
+			policy.Banned[line] = true
+		}
+	}
+	return policy, scanner.Err()
+}
+
+// Check returns every rule the password breaks. userInputs are the username,
+// email and name of the account, which the password must not resemble.
+func (p *PasswordPolicy) Check(password string, userInputs ...string) []models.PasswordViolation {
+	var violations []models.PasswordViolation
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, models.PasswordViolation{Code: PasswordTooShort, Message: fmt.Sprintf("Use at least %d characters", p.MinLength)})
+	}
+	if length > passwordMaxLength {
+		violations = append(violations, models.PasswordViolation{Code: PasswordTooLong, Message: fmt.Sprintf("Use at most %d characters", passwordMaxLength)})
+		return violations
+	}
+	normalized := normalizePassword(password)
+	if p.Banned[normalized] {
+		violations = append(violations, models.PasswordViolation{Code: PasswordBanned, Message: "This password is too common"})
+	}
+	if resemblesAccount(normalized, userInputs) {
+		violations = append(violations, models.PasswordViolation{Code: PasswordSimilarToAccount, Message: "Don't use your name, username or email in your password"})
+	}
+	if strength := zxcvbn.PasswordStrength(password, userInputs); strength.Score < p.MinScore {
+		violations = append(violations, models.PasswordViolation{Code: PasswordTooWeak, Message: "This password is easy to guess, add more words or characters"})
+	}
+	if p.Breaches != nil {
+		count, err := p.Breaches.Count(password)
+		if err != nil {
+			// A broken corpus shouldn't keep people from setting passwords
+			log.Printf("breached password lookup failed: %v", err)
+		} else if count > 0 {
+			violations = append(violations, models.PasswordViolation{Code: PasswordBreached, Message: fmt.Sprintf("This password appeared in %d data breaches, choose another one", count)})
+		}
+	}
+	return violations
+}
+
+// normalizePassword lowercases, undoes common symbol substitutions and drops
+// everything else but letters and digits, so that "P@ssword!" matches "password"
+func normalizePassword(password string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '@':
+			return 'a'
+		case '$':
+			return 's'
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, password)
+}
+
+func resemblesAccount(normalized string, userInputs []string) bool {
+	for _, input := range userInputs {
+		local, _, _ := strings.Cut(input, "@")
+		for _, part := range []string{normalizePassword(input), normalizePassword(local)} {
+			// A password of only symbols normalizes to nothing, which every part contains
+			if len(part) >= 3 && (strings.Contains(normalized, part) || (len(normalized) >= 3 && strings.Contains(part, normalized))) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// BreachedPasswords looks passwords up in a local copy of the Have I Been
+// Pwned corpus, split into one file per 5 character SHA-1 prefix with lines
+// of SUFFIX:COUNT, as written by the official downloader. Like the range API
+// only the file of the prefix is read, so the corpus never has to fit in
+// memory.
+type BreachedPasswords struct {
+	dir string
+}
+
+func LoadBreachedPasswords(dir string) (*BreachedPasswords, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return &BreachedPasswords{dir: dir}, nil
+}
+
+// Count returns how often the password appears in the corpus
+func (b *BreachedPasswords) Count(password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(b.dir, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineSuffix, count, found := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if found && strings.EqualFold(lineSuffix, suffix) {
+			var n int
+			if _, err := fmt.Sscan(count, &n); err != nil {
+				return 0, err
+			}
+			return n, nil
+		}
+	}
+	return 0, scanner.Err()
+}
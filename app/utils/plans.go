@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"fmt"
+	"time"
+	"users-api/app/appdata"
+	"users-api/app/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BuiltInPlan is a reading plan that comes with the app
+type BuiltInPlan struct {
+	Slug        string
+	Name        string
+	Description string
+	Days        func() [][]models.PlanChapter
+}
+
+// Books in roughly the order their events happened or they were written
+var chronologicalBooks = []string{
+	"GEN", "JOB", "EXO", "LEV", "NUM", "DEU", "JOS", "JDG", "RUT", "1SA", "2SA", "1CH", "PSA",
+	"1KI", "PRO", "ECC", "SNG", "2KI", "2CH", "JON", "AMO", "HOS", "MIC", "ISA", "JOL", "NAM",
+	"ZEP", "HAB", "JER", "LAM", "OBA", "EZK", "DAN", "EZR", "HAG", "ZEC", "EST", "NEH", "MAL",
+	"MAT", "MRK", "LUK", "JHN", "ACT", "JAM", "GAL", "1TH", "2TH", "1CO", "2CO", "ROM", "EPH",
+	"PHP", "COL", "PHM", "1TI", "TIT", "1PE", "2TI", "2PE", "HEB", "JUD", "1JN", "2JN", "3JN", "REV",
+}
+
+var BuiltInPlans = []BuiltInPlan{
+	{
+		Slug:        "bible-in-a-year",
+		Name:        "Bible in a year",
+		Description: "Every chapter from Genesis to Revelation in 365 days",
+		Days: func() [][]models.PlanChapter {
+			return SplitIntoDays(BookChapters(1, appdata.BookCount), 365)
+		},
+	},
+	{
+		Slug:        "nt-in-90-days",
+		Name:        "New Testament in 90 days",
+		Description: "Matthew to Revelation in 90 days",
+		Days: func() [][]models.PlanChapter {
+			return SplitIntoDays(BookChapters(appdata.OtCount+1, appdata.BookCount), 90)
+		},
+	},
+	{
+		Slug:        "chronological",
+		Name:        "Chronological Bible in a year",
+		Description: "The whole Bible in 365 days, with the books in the order their events happened",
+		Days: func() [][]models.PlanChapter {
+			var chapters []models.PlanChapter
+			for _, abbreviation := range chronologicalBooks {
+				book, ok := FindBook(abbreviation)
+				if !ok {
+					panic("unknown book in chronological plan: " + abbreviation)
+				}
+				chapters = append(chapters, BookChapters(uint(book+1), uint(book+1))...)
+			}
+			return SplitIntoDays(chapters, 365)
+		},
+	},
+}
+
+// BookChapters lists every chapter of the books from first to last, numbered
+// from 1
+func BookChapters(first uint, last uint) []models.PlanChapter {
+	var chapters []models.PlanChapter
+	for book := first; book <= last; book++ {
+		for chapter := uint(1); chapter <= appdata.Books[book-1].Chapters; chapter++ {
+			chapters = append(chapters, models.PlanChapter{Book: book, Chapter: chapter})
+		}
+	}
+	return chapters
+}
+
+// SplitIntoDays spreads the chapters over the given number of days as evenly
+// as possible, keeping their order
+func SplitIntoDays(chapters []models.PlanChapter, days int) [][]models.PlanChapter {
+	if days > len(chapters) {
+		days = len(chapters)
+	}
+	split := make([][]models.PlanChapter, days)
+	for day := 0; day < days; day++ {
+		split[day] = chapters[day*len(chapters)/days : (day+1)*len(chapters)/days]
+	}
+	return split
+}
+
+// SeedReadingPlans creates the built-in plans and updates their chapters
+func SeedReadingPlans() error {
+	return appdata.DB.Transaction(func(tx *gorm.DB) error {
+		for _, builtIn := range BuiltInPlans {
+			slug := builtIn.Slug
+			plan := models.ReadingPlan{Slug: &slug, Name: builtIn.Name, Description: builtIn.Description, Days: builtIn.Days()}
+			err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "slug"}},
+				DoUpdates: clause.AssignmentColumns([]string{"name", "description", "days"}),
+			}).Create(&plan).Error
+			if err != nil {
+				return fmt.Errorf("seeding plan %s: %w", slug, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Date returns the calendar day of t as midnight UTC, which is how plan dates
+// are stored and compared
+func Date(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"math"
+	"users-api/app/appdata"
+	"users-api/app/models"
+)
+
+// Average length of a verse in words, from the about 790000 words of the KJV
+const wordsPerVerse = 25
+
+// NewReadProgress fills in the percentage read, by verses, and the estimated
+// time left to read the rest
+func NewReadProgress(chapters uint, chaptersRead uint, verses uint, versesRead uint) models.ReadProgress {
+	progress := models.ReadProgress{Chapters: chapters, ChaptersRead: chaptersRead, Verses: verses, VersesRead: versesRead}
+	if verses > 0 {
+		progress.Percent = math.Round(float64(versesRead)*1000/float64(verses)) / 10
+	}
+	remainingWords := float64((verses - versesRead) * wordsPerVerse)
+	progress.RemainingMinutes = uint(math.Ceil(remainingWords / float64(appdata.ReadingWordsPerMinute)))
+	return progress
+}
+
+// VersesOfChapter returns the number of verses of a chapter, with books
+// numbered from 1 like in ReadHistory, or 0 if there is no such chapter
+func VersesOfChapter(book uint, chapter uint) uint {
+	if book < 1 || book > appdata.BookCount || chapter < 1 || chapter > appdata.Books[book-1].Chapters {
+		return 0
+	}
+	return appdata.ChapterVerses[book-1][chapter-1]
+}
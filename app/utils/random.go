@@ -1,15 +1,41 @@
 package utils
 
 import (
-	"math/rand"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"math/big"
 	"strings"
+	"users-api/app/appdata"
 )
 
+// GenerateToken returns a URL safe secret of appdata.TokenEntropyBytes random
+// bytes, for refresh tokens, emailed links and OAuth values
+func GenerateToken() (string, error) {
+	raw := make([]byte, appdata.TokenEntropyBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// HashToken returns the value stored in place of a token. Tokens carry enough
+// entropy that a plain SHA-256 can't be reversed by guessing.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 func generateRandomString(charset string, n uint) string {
 	var sb strings.Builder
+	max := big.NewInt(int64(len(charset)))
 	for i := uint(0); i < n; i++ {
-		randomIndex := rand.Intn(len(charset))
-		sb.WriteByte(charset[randomIndex])
+		randomIndex, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(err) // crypto/rand doesn't fail on supported platforms
+		}
+		sb.WriteByte(charset[randomIndex.Int64()])
 	}
 	return sb.String()
 }
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"time"
+	_ "time/tzdata"
+	"users-api/app/appdata"
+	"users-api/app/models"
+)
+
+// ReadingDay is the number of chapters marked read on a calendar day, stored
+// as midnight UTC like the other dates
+type ReadingDay struct {
+	Day   time.Time
+	Count int
+}
+
+// LoadTimezone loads an IANA time zone like Europe/Paris. The empty name and
+// "Local", which would be the zone of the server, are refused.
+func LoadTimezone(name string) (*time.Location, bool) {
+	if name == "" || name == "Local" {
+		return nil, false
+	}
+	location, err := time.LoadLocation(name)
+	return location, err == nil
+}
+
+// UserLocation returns the time zone of the user's preferences, UTC when
+// none is set
+func UserLocation(userID uint) *time.Location {
+	var preference models.UserPreference
+	if appdata.DB.Where("user_id = ?", userID).First(&preference).Error != nil || preference.Timezone == nil {
+		return time.UTC
+	}
+	if location, ok := LoadTimezone(*preference.Timezone); ok {
+		return location
+	}
+	return time.UTC
+}
+
+// ReadingActivity counts the chapters the user marked read on each day in
+// the given time zone, oldest day first. Days without reading are left out.
+func ReadingActivity(userID uint, location *time.Location) ([]ReadingDay, error) {
+	var days []ReadingDay
+	err := appdata.DB.Model(&models.ReadHistory{}).
+		Select("date(created_at AT TIME ZONE ?) AS day, count(*) AS count", location.String()).
+		Where("user_id = ?", userID).
+		Group("day").
+		Order("day").
+		Scan(&days).Error
+	for i := range days {
+		days[i].Day = Date(days[i].Day)
+	}
+	return days, err
+}
+
+// ReadingStreaks returns the current and the longest run of consecutive days
+// with reading, and the first day of the longest one. The current streak
+// still counts when nothing has been read yet today but yesterday was.
+func ReadingStreaks(days []ReadingDay, today time.Time) (current int, longest int, longestStart time.Time) {
+	run := 0
+	var runStart, last time.Time
+	for _, day := range days {
+		if run > 0 && day.Day.Equal(last.AddDate(0, 0, 1)) {
+			run++
+		} else {
+			run, runStart = 1, day.Day
+		}
+		last = day.Day
+		if run > longest {
+			longest, longestStart = run, runStart
+		}
+	}
+	if run > 0 && (last.Equal(today) || last.Equal(today.AddDate(0, 0, -1))) {
+		current = run
+	}
+	return current, longest, longestStart
+}
+
+// WeekStart returns the Monday of the week of day
+func WeekStart(day time.Time) time.Time {
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"fmt"
+	"time"
+	"users-api/app/models"
+
+	"gorm.io/gorm"
+)
+
+// LockReadHistory serialises the changes to the read history of the user
+// until tx ends. Change IDs are handed out before commit, so a change made
+// alongside a sync could otherwise show up after the client got a cursor past
+// its ID, and never be synced. Take it before writing the read history, in
+// the transaction that records the changes.
+func LockReadHistory(tx *gorm.DB, userID uint) error {
+	return lockUser(tx, lockReadHistory, userID)
+}
+
+// RecordReadChanges notes that the chapters were marked read or unread at the
+// given time, for clients syncing the read history. tx should hold
+// LockReadHistory; it is taken here too in case it doesn't.
+func RecordReadChanges(tx *gorm.DB, userID uint, cycleID uint, chapters []models.PlanChapter, read bool, changedAt time.Time) error {
+	if len(chapters) == 0 {
+		return nil
+	}
+	pairs := make([][]interface{}, 0, len(chapters))
+	changes := make([]models.ReadHistoryChange, 0, len(chapters))
+	for _, chapter := range chapters {
+		pairs = append(pairs, []interface{}{chapter.Book, chapter.Chapter})
+		changes = append(changes, models.ReadHistoryChange{
+			UserID:    userID,
+			CycleID:   cycleID,
+			Book:      chapter.Book,
+			Chapter:   chapter.Chapter,
+			Read:      read,
+			ChangedAt: changedAt,
+		})
+	}
+	return tx.Transaction(func(tx *gorm.DB) error {
+		if err := LockReadHistory(tx, userID); err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ? AND cycle_id = ? AND (book, chapter) IN ?", userID, cycleID, pairs).
+			Delete(&models.ReadHistoryChange{}).Error; err != nil {
+			return err
+		}
+		return tx.CreateInBatches(&changes, 500).Error
+	})
+}
+
+// ReadSyncCursor tells a syncing client which cycle it has synced and up to
+// which change
+func ReadSyncCursor(cycleID uint, changeID uint) string {
+	return fmt.Sprintf("%d.%d", cycleID, changeID)
+}
+
+// ParseReadSyncCursor reads a cursor made by ReadSyncCursor
+func ParseReadSyncCursor(cursor string) (cycleID uint, changeID uint, err error) {
+	if _, err := fmt.Sscanf(cursor, "%d.%d", &cycleID, &changeID); err != nil {
+		return 0, 0, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	return cycleID, changeID, nil
+}
@@ -0,0 +1,208 @@
+package utils
+
+import (
+	"errors"
+	"sync"
+	"time"
+	"users-api/app/appdata"
+	"users-api/app/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// RevocationStore is where access token revocations are kept. Access tokens
+// are rejected when their session was revoked or when they were issued before
+// the user's token version was last bumped.
+type RevocationStore interface {
+	RevokeSession(sessionID string, until time.Time) error
+	IsSessionRevoked(sessionID string) (bool, error)
+	TokenVersion(userID uint) (uint, error)
+	BumpTokenVersion(userID uint) error
+}
+
+// Revocations is the store consulted by RejectRevokedTokens. It is set up in
+// InitializeDatabase and can be replaced by any other RevocationStore, for
+// example one backed by a cache shared between instances.
+var Revocations RevocationStore
+
+// DBRevocationStore keeps revocations in the database
+type DBRevocationStore struct{}
+
+func (DBRevocationStore) RevokeSession(sessionID string, until time.Time) error {
+	appdata.DB.Where("expires_at < ?", time.Now()).Delete(&models.RevokedSession{})
+	revoked := models.RevokedSession{SessionID: sessionID, ExpiresAt: until}
+	return appdata.DB.Save(&revoked).Error
+}
+
+func (DBRevocationStore) IsSessionRevoked(sessionID string) (bool, error) {
+	var count int64
+	err := appdata.DB.Model(&models.RevokedSession{}).
+		Where("session_id = ? AND expires_at > ?", sessionID, time.Now()).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (DBRevocationStore) TokenVersion(userID uint) (uint, error) {
+	var user models.User
+	err := appdata.DB.Select("token_version").First(&user, userID).Error
+	return user.TokenVersion, err
+}
+
+func (DBRevocationStore) BumpTokenVersion(userID uint) error {
+	return appdata.DB.Model(&models.User{}).
+		Where("id = ?", userID).
+		Update("token_version", gorm.Expr("token_version + 1")).Error
+}
+
+type cacheEntry[T any] struct {
+	value     T
+	expiresAt time.Time
+}
+
+// CachedRevocationStore answers lookups from memory for up to ttl before
+// asking the underlying store again. Writes go through to the underlying store
+// and update the cache immediately, so revocations made by this instance take
+// effect at once and those made by other instances within ttl.
+type CachedRevocationStore struct {
+	store    RevocationStore
+	ttl      time.Duration
+	mu       sync.Mutex
+	sessions map[string]cacheEntry[bool]
+	versions map[uint]cacheEntry[uint]
+}
+
+func NewCachedRevocationStore(store RevocationStore, ttl time.Duration) *CachedRevocationStore {
+	return &CachedRevocationStore{
+		store:    store,
+		ttl:      ttl,
+		sessions: make(map[string]cacheEntry[bool]),
+		versions: make(map[uint]cacheEntry[uint]),
+	}
+}
+
+// Entries are dropped lazily, this only keeps the maps from growing unbounded
+const maxCacheEntries = 100000
+
+func (s *CachedRevocationStore) RevokeSession(sessionID string, until time.Time) error {
+	if err := s.store.RevokeSession(sessionID, until); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[sessionID] = cacheEntry[bool]{value: true, expiresAt: until}
+	return nil
+}
+
+func (s *CachedRevocationStore) IsSessionRevoked(sessionID string) (bool, error) {
+	now := time.Now()
+	s.mu.Lock()
+	entry, ok := s.sessions[sessionID]
+	s.mu.Unlock()
+	if ok && entry.expiresAt.After(now) {
+		return entry.value, nil
+	}
+	revoked, err := s.store.IsSessionRevoked(sessionID)
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.sessions) >= maxCacheEntries {
+		s.sessions = make(map[string]cacheEntry[bool])
+	}
+	s.sessions[sessionID] = cacheEntry[bool]{value: revoked, expiresAt: now.Add(s.ttl)}
+	return revoked, nil
+}
+
+func (s *CachedRevocationStore) TokenVersion(userID uint) (uint, error) {
+	now := time.Now()
+	s.mu.Lock()
+	entry, ok := s.versions[userID]
+	s.mu.Unlock()
+	if ok && entry.expiresAt.After(now) {
+		return entry.value, nil
+	}
+	version, err := s.store.TokenVersion(userID)
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.versions) >= maxCacheEntries {
+		s.versions = make(map[uint]cacheEntry[uint])
+	}
+	s.versions[userID] = cacheEntry[uint]{value: version, expiresAt: now.Add(s.ttl)}
+	return version, nil
+}
+
+func (s *CachedRevocationStore) BumpTokenVersion(userID uint) error {
+	if err := s.store.BumpTokenVersion(userID); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	delete(s.versions, userID)
+	s.mu.Unlock()
+	return nil
+}
+
+// RevokeAccessTokens puts a session on the denylist for as long as any access
+// token issued for it can still be valid.
+func RevokeAccessTokens(sessionID string) error {
+	longestExpiry := max(appdata.JwtExpiryMinutes, appdata.JwtExpiryNoRemember)
+	return Revocations.RevokeSession(sessionID, time.Now().Add(time.Duration(longestExpiry)*time.Minute))
+}
+
+// LogoutEverywhere deletes the refresh tokens of every session of the user
+// except keepSession (pass "" to keep none) and invalidates all access tokens
+// issued so far. Clients of the kept session have to refresh their token.
+func LogoutEverywhere(userID uint, keepSession string) error {
+	if err := appdata.DB.Where("user_id = ? AND session_id <> ?", userID, keepSession).Delete(&models.RefreshToken{}).Error; err != nil {
+		return err
+	}
+	return Revocations.BumpTokenVersion(userID)
+}
+
+// RejectRevokedTokens runs after the JWT middleware has validated a token and
+// turns away tokens that aren't access tokens, tokens of revoked sessions and
+// tokens of outdated token versions.
+func RejectRevokedTokens(c *fiber.Ctx) error {
+	claims := c.Locals("user").(*jwt.Token).Claims.(jwt.MapClaims)
+	if claims["typ"] == TokenTypeClientAccess {
+		return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{Error: "Third-party apps can't use this route"})
+	}
+	if claims["typ"] != TokenTypeAccess {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{Error: "Not an access token"})
+	}
+	return checkTokenRevocation(c, claims)
+}
+
+// checkTokenRevocation rejects access tokens of either kind that were revoked
+// by a token version bump or by revoking their session
+func checkTokenRevocation(c *fiber.Ctx, claims jwt.MapClaims) error {
+	userID := GetUserFromJwt(c)
+	tokenVersion, _ := claims["ver"].(float64)
+
+	currentVersion, err := Revocations.TokenVersion(userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{Error: "User no longer exists"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+	}
+	if uint(tokenVersion) < currentVersion {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{Error: "Access token revoked, refresh or login again."})
+	}
+
+	if sessionID := GetSessionFromJwt(c); sessionID != "" {
+		revoked, err := Revocations.IsSessionRevoked(sessionID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.NewInternalError())
+		}
+		if revoked {
+			return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{Error: "Session logged out, login again."})
+		}
+	}
+	return c.Next()
+}
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"users-api/app/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Scopes third-party apps can ask for
+const (
+	ScopeProfileRead     = "profile:read"
+	ScopeHistoryRead     = "history:read"
+	ScopeHistoryWrite    = "history:write"
+	ScopeBookmarksRead   = "bookmarks:read"
+	ScopeBookmarksWrite  = "bookmarks:write"
+	ScopeNotesRead       = "notes:read"
+	ScopeNotesWrite      = "notes:write"
+	ScopeHighlightsRead  = "highlights:read"
+	ScopeHighlightsWrite = "highlights:write"
+)
+
+// ScopeDescriptions is what the consent screen shows for each scope
+var ScopeDescriptions = map[string]string{
+	ScopeProfileRead:     "See your name, username, email and reading preferences",
+	ScopeHistoryRead:     "See which chapters you have read",
+	ScopeHistoryWrite:    "Mark chapters as read or unread",
+	ScopeBookmarksRead:   "See your bookmarks",
+	ScopeBookmarksWrite:  "Add and remove bookmarks",
+	ScopeNotesRead:       "See your notes",
+	ScopeNotesWrite:      "Create, edit and delete notes",
+	ScopeHighlightsRead:  "See your highlighted verses",
+	ScopeHighlightsWrite: "Highlight verses and remove highlights",
+}
+
+// ParseScopes splits a space separated scope string, rejecting unknown scopes
+// and dropping duplicates
+func ParseScopes(scope string) ([]string, error) {
+	var scopes []string
+	for _, s := range strings.Fields(scope) {
+		if _, ok := ScopeDescriptions[s]; !ok {
+			return nil, fmt.Errorf("unknown scope %s", s)
+		}
+		if !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes, nil
+}
+
+// AllowScope is the jwtware SuccessHandler for routes third-party apps may
+// call. Our own access tokens are checked like in RejectRevokedTokens, app
+// tokens additionally need the scope.
+func AllowScope(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims := c.Locals("user").(*jwt.Token).Claims.(jwt.MapClaims)
+		if claims["typ"] != TokenTypeClientAccess {
+			return RejectRevokedTokens(c)
+		}
+		granted, _ := claims["scope"].(string)
+		if !slices.Contains(strings.Fields(granted), scope) {
+			return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{Error: fmt.Sprintf("The app was not granted the %s scope", scope)})
+		}
+		return checkTokenRevocation(c, claims)
+	}
+}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"users-api/app/appdata"
+	"users-api/app/models"
+)
+
+// RecordSecurityEvent stores an audit record for the user and writes it to the
+// server log. Failures to store the record are logged and otherwise ignored.
+func RecordSecurityEvent(userID uint, eventType string, sessionID string, ip string, detail string) {
+	log.Printf("security event %s for user %d (session %s, ip %s): %s", eventType, userID, sessionID, ip, detail)
+	event := models.SecurityEvent{UserID: userID, Type: eventType, SessionID: sessionID, IP: ip, Detail: detail}
+	if err := appdata.DB.Create(&event).Error; err != nil {
+		log.Printf("failed to store security event for user %d: %v", userID, err)
+	}
+}
+
+// NotifyRefreshTokenReuse emails the account owner that a stolen refresh token
+// may have been used, if NOTIFY_TOKEN_REUSE is enabled.
+func NotifyRefreshTokenReuse(user *models.User, token *models.RefreshToken, ip string) {
+	if !appdata.NotifyTokenReuse {
+		return
+	}
+	device := "an unknown device"
+	if token.Device != nil && *token.Device != "" {
+		device = *token.Device
+	}
+	emailBody := fmt.Sprintf("An old login token of your session on %s was used again from %s, which can mean it was stolen.<br><br>"+
+		"We logged that session out to be safe. If this wasn't you, change your password and review your sessions.", device, ip)
+	if err := SendEmail(user.Email, "Suspicious activity on your account", emailBody, true); err != nil {
+		log.Printf("failed to send token reuse email to user %d: %v", user.ID, err)
+	}
+}
+
+// NotifyPasskeyAdded emails the account owner that a passkey was added, so a
+// passkey registered by someone else doesn't go unnoticed.
+func NotifyPasskeyAdded(user *models.User, name string, ip string) {
+	emailBody := fmt.Sprintf("A passkey named \"%s\" was added to your account from %s. It can be used to log in without your password.<br><br>"+
+		"If this wasn't you, delete the passkey, change your password and review your sessions.", html.EscapeString(name), ip)
+	if err := SendEmail(user.Email, "A passkey was added to your account", emailBody, true); err != nil {
+		log.Printf("failed to send passkey email to user %d: %v", user.ID, err)
+	}
+}
@@ -1,15 +1,29 @@
 package utils
 
 import (
+	"errors"
 	"time"
 	"users-api/app/appdata"
 	"users-api/app/models"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
-func PrepareAccessToken(user *models.User, remember bool) string {
+// Tokens signed with the key ring say what they are for in the typ claim so
+// that one kind can't be used in place of another
+const (
+	TokenTypeAccess       = "access"
+	TokenTypeMfa          = "mfa"
+	TokenTypeClientAccess = "client_access" // Issued to a third-party app, limited to its scopes
+)
+
+// MfaChallengeMinutes is how long a user has to complete the second factor
+// after the password was accepted
+const MfaChallengeMinutes = 5
+
+func PrepareAccessToken(user *models.User, sessionID string, remember bool) string {
 	var jwtExpiryMinutes uint
 	if remember {
 		jwtExpiryMinutes = appdata.JwtExpiryMinutes
@@ -18,11 +32,14 @@ func PrepareAccessToken(user *models.User, remember bool) string {
 	}
 	expiry := time.Now().Add(time.Duration(jwtExpiryMinutes) * time.Minute)
 	claims := jwt.MapClaims{
+		"typ": TokenTypeAccess,
 		"id":  user.ID,
+		"jti": uuid.NewString(),
+		"sid": sessionID,
+		"ver": user.TokenVersion,
 		"exp": expiry.Unix(),
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err := token.SignedString(appdata.JwtSecret)
+	signedToken, err := Keys.Sign(claims)
 	if err == nil {
 		return signedToken
 	} else {
@@ -30,25 +47,136 @@ func PrepareAccessToken(user *models.User, remember bool) string {
 	}
 }
 
-func PrepareRefreshToken(user *models.User, device *string, location *string, remember bool) string {
+// PrepareClientAccessToken returns an access token for a third-party app. It
+// is only accepted on routes that require one of its scopes.
+func PrepareClientAccessToken(user *models.User, sessionID string, clientID string, scope string) string {
+	expiry := time.Now().Add(time.Duration(appdata.JwtExpiryMinutes) * time.Minute)
+	claims := jwt.MapClaims{
+		"typ":   TokenTypeClientAccess,
+		"id":    user.ID,
+		"jti":   uuid.NewString(),
+		"sid":   sessionID,
+		"ver":   user.TokenVersion,
+		"cid":   clientID,
+		"scope": scope,
+		"exp":   expiry.Unix(),
+	}
+	signedToken, err := Keys.Sign(claims)
+	if err == nil {
+		return signedToken
+	} else {
+		return ""
+	}
+}
+
+// PrepareMfaToken returns the challenge token handed out instead of a token
+// pair when the password was correct but a second factor is still needed. It
+// carries the login options so they can be applied once the challenge is met.
+func PrepareMfaToken(user *models.User, req *models.LoginRequest) string {
+	claims := jwt.MapClaims{
+		"typ":      TokenTypeMfa,
+		"id":       user.ID,
+		"remember": req.Remember,
+		"device":   req.Device,
+		"location": req.Location,
+		"exp":      time.Now().Add(MfaChallengeMinutes * time.Minute).Unix(),
+	}
+	signedToken, err := Keys.Sign(claims)
+	if err != nil {
+		return ""
+	}
+	return signedToken
+}
+
+// ParseToken verifies a token signed with the key ring and checks it is of the
+// given type
+func ParseToken(tokenString string, tokenType string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, Keys.Keyfunc)
+	if err != nil {
+		return nil, err
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	if claims["typ"] != tokenType {
+		return nil, errors.New("wrong token type")
+	}
+	return claims, nil
+}
+
+// LoginRequestFromMfaClaims restores the login options saved in an MFA
+// challenge token
+func LoginRequestFromMfaClaims(claims jwt.MapClaims) models.LoginRequest {
+	var req models.LoginRequest
+	req.Remember, _ = claims["remember"].(bool)
+	if device, ok := claims["device"].(string); ok {
+		req.Device = &device
+	}
+	if location, ok := claims["location"].(string); ok {
+		req.Location = &location
+	}
+	return req
+}
+
+// PrepareRefreshToken starts a new session for the user and returns its first
+// refresh token. Token is empty if the token could not be saved.
+func PrepareRefreshToken(user *models.User, device *string, location *string, remember bool) models.RefreshToken {
+	return createRefreshToken(models.RefreshToken{UserID: user.ID, SessionID: uuid.NewString(), StartedAt: time.Now(), Device: device, Location: location, Remember: remember})
+}
+
+// PrepareClientRefreshToken starts a session for a third-party app the user
+// authorized. The app's name stands in for the device in the session list.
+func PrepareClientRefreshToken(user *models.User, client *models.OAuthClient, scope string) models.RefreshToken {
+	return createRefreshToken(models.RefreshToken{UserID: user.ID, SessionID: uuid.NewString(), StartedAt: time.Now(), Device: &client.Name, ClientID: &client.ClientID, Scope: scope, Remember: true})
+}
+
+// RotateRefreshToken issues the successor of a refresh token within the same
+// session and token family. The caller is responsible for revoking the
+// previous token.
+func RotateRefreshToken(previous *models.RefreshToken) models.RefreshToken {
+	return createRefreshToken(models.RefreshToken{
+		UserID:    previous.UserID,
+		SessionID: previous.SessionID,
+		ParentID:  &previous.ID,
+		StartedAt: previous.StartedAt,
+		Device:    previous.Device,
+		Location:  previous.Location,
+		Remember:  previous.Remember,
+		ClientID:  previous.ClientID,
+		Scope:     previous.Scope,
+	})
+}
+
+// createRefreshToken stores refreshToken with a new token string and expiry
+func createRefreshToken(refreshToken models.RefreshToken) models.RefreshToken {
 	var refreshExpiryMinutes uint
-	if remember {
+	if refreshToken.Remember {
 		refreshExpiryMinutes = appdata.RefreshExpiryMinutes
 	} else {
 		refreshExpiryMinutes = appdata.RefreshExpiryNoRemember
 	}
-	expiry := time.Now().Add(time.Duration(refreshExpiryMinutes) * time.Minute)
+	refreshToken.ExpiresAt = time.Now().Add(time.Duration(refreshExpiryMinutes) * time.Minute)
 
 	oneRefreshPeriodBefore := time.Now().Add(-time.Duration(appdata.RefreshExpiryMinutes) * time.Minute)
 	appdata.DB.Where("expires_at < ?", oneRefreshPeriodBefore).Delete(&models.RefreshToken{})
-	tokenString := GenerateAlphanumeric(25)
-	refreshToken := models.RefreshToken{UserID: user.ID, Device: device, Location: location, Token: tokenString, ExpiresAt: expiry, Remember: remember}
-	result := appdata.DB.Create(&refreshToken)
-	if result.Error == nil {
-		return tokenString
-	} else {
-		return ""
+	token, err := GenerateToken()
+	if err != nil {
+		return refreshToken
 	}
+	refreshToken.TokenHash = HashToken(token)
+	if err := appdata.DB.Create(&refreshToken).Error; err != nil {
+		return refreshToken
+	}
+	refreshToken.Token = token
+	return refreshToken
+}
+
+// RevokeRefreshTokenFamily revokes every token of a session and returns how
+// many were still live. The rows are kept rather than deleted so that a later
+// replay is still recognised as reuse.
+func RevokeRefreshTokenFamily(sessionID string) (int64, error) {
+	result := appdata.DB.Model(&models.RefreshToken{}).
+		Where("session_id = ? AND revoked = ?", sessionID, false).
+		Update("revoked", true)
+	return result.RowsAffected, result.Error
 }
 
 func GetUserFromJwt(c *fiber.Ctx) uint {
@@ -57,3 +185,12 @@ func GetUserFromJwt(c *fiber.Ctx) uint {
 	user_id := uint(claims["id"].(float64))
 	return user_id
 }
+
+// GetSessionFromJwt returns the session the access token was issued for, or
+// an empty string for tokens minted before sessions were tracked.
+func GetSessionFromJwt(c *fiber.Ctx) string {
+	user := c.Locals("user").(*jwt.Token)
+	claims := user.Claims.(jwt.MapClaims)
+	sessionID, _ := claims["sid"].(string)
+	return sessionID
+}
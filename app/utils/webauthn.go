@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"encoding/json"
+	"strconv"
+	"users-api/app/appdata"
+	"users-api/app/models"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// WebAuthn is the relying party configuration for passkeys, set up in
+// InitializeApp
+var WebAuthn *webauthn.WebAuthn
+
+// WebAuthnUser adapts a user and their stored passkeys to webauthn.User
+type WebAuthnUser struct {
+	User        *models.User
+	Credentials []webauthn.Credential
+	Records     []models.WebAuthnCredential // Same order as Credentials
+}
+
+// The user handle is the decimal user ID, it identifies the account when the
+// authenticator picks the passkey in a discoverable login
+func (u *WebAuthnUser) WebAuthnID() []byte {
+	return []byte(strconv.FormatUint(uint64(u.User.ID), 10))
+}
+
+func (u *WebAuthnUser) WebAuthnName() string {
+	return u.User.Username
+}
+
+func (u *WebAuthnUser) WebAuthnDisplayName() string {
+	if u.User.Name != "" {
+		return u.User.Name
+	}
+	return u.User.Username
+}
+
+func (u *WebAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.Credentials
+}
+
+// LoadWebAuthnUser returns the user with their passkeys
+func LoadWebAuthnUser(user *models.User) (*WebAuthnUser, error) {
+	var records []models.WebAuthnCredential
+	if err := appdata.DB.Where("user_id = ?", user.ID).Order("created_at").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	webAuthnUser := &WebAuthnUser{User: user, Records: records, Credentials: make([]webauthn.Credential, 0, len(records))}
+	for _, record := range records {
+		var credential webauthn.Credential
+		if err := json.Unmarshal(record.Data, &credential); err != nil {
+			return nil, err
+		}
+		webAuthnUser.Credentials = append(webAuthnUser.Credentials, credential)
+	}
+	return webAuthnUser, nil
+}
+
+// UserIDFromWebAuthnHandle reverses WebAuthnID
+func UserIDFromWebAuthnHandle(handle []byte) (uint, error) {
+	id, err := strconv.ParseUint(string(handle), 10, 64)
+	return uint(id), err
+}
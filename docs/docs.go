@@ -15,73 +15,87 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/login": {
-            "post": {
-                "description": "Authenticates a user with email and password and returns a JWT access token and a refresh token.",
-                "consumes": [
-                    "application/json"
-                ],
+        "/.well-known/jwks.json": {
+            "get": {
+                "description": "Returns the JSON Web Key Set with every key that currently verifies access tokens. Tokens name their key in the kid header.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "auth"
                 ],
-                "summary": "Login a user",
-                "parameters": [
-                    {
-                        "description": "User login credentials",
-                        "name": "credentials",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/models.LoginRequest"
-                        }
-                    }
-                ],
+                "summary": "Public keys for access tokens",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.LoginResponse"
-                        }
-                    },
-                    "401": {
-                        "description": "Unauthorized",
-                        "schema": {
-                            "$ref": "#/definitions/models.ErrorResponse"
+                            "$ref": "#/definitions/utils.JWKS"
                         }
                     }
                 }
             }
         },
-        "/logout": {
-            "post": {
-                "description": "Logs out the user from all devices by invalidating all refresh tokens associated with their account.",
-                "consumes": [
-                    "application/json"
+        "/bookmark": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
                 ],
+                "description": "Returns the bookmarks of the user in their order. Filters combine: book (or abbreviation) and chapter keep the bookmarks whose range covers the chapter, folder_id those of a folder (\"none\" for those outside folders), color those of a colour and q those whose title contains the text.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "auth"
+                    "bookmarks"
                 ],
-                "summary": "Logout user from current device",
+                "summary": "List bookmarks",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Refresh token",
-                        "name": "Refresh",
-                        "in": "header",
-                        "required": true
+                        "description": "Name of the book",
+                        "name": "book",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Abbreviation of the book",
+                        "name": "abbreviation",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Chapter covered by the bookmarks",
+                        "name": "chapter",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Folder ID or none",
+                        "name": "folder_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Colour like #FFD54F",
+                        "name": "color",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Text in the title",
+                        "name": "q",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.GenericMessage"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Bookmark"
+                            }
                         }
                     },
                     "400": {
@@ -93,14 +107,38 @@ const docTemplate = `{
                 }
             }
         },
-        "/logout/all": {
+        "/bookmark/folders": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "bookmarks"
+                ],
+                "summary": "List bookmark folders",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.BookmarkFolder"
+                            }
+                        }
+                    }
+                }
+            },
             "post": {
                 "security": [
                     {
                         "BearerAuth": []
                     }
                 ],
-                "description": "Logs out the user from all devices by invalidating all provided refresh tokens.",
                 "consumes": [
                     "application/json"
                 ],
@@ -108,27 +146,35 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "auth"
+                    "bookmarks"
                 ],
-                "summary": "Logout user from all devices",
+                "summary": "Create a bookmark folder",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Bearer JWT token",
-                        "name": "Authorization",
-                        "in": "header",
-                        "required": true
+                        "description": "Name and position of the folder",
+                        "name": "folder",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.BookmarkFolderRequest"
+                        }
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "OK",
+                    "201": {
+                        "description": "Created",
                         "schema": {
-                            "$ref": "#/definitions/models.GenericMessage"
+                            "$ref": "#/definitions/models.BookmarkFolder"
                         }
                     },
-                    "401": {
-                        "description": "Unauthorized",
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
                         "schema": {
                             "$ref": "#/definitions/models.ErrorResponse"
                         }
@@ -136,14 +182,13 @@ const docTemplate = `{
                 }
             }
         },
-        "/markbookasread/{bookid}": {
-            "post": {
+        "/bookmark/folders/{id}": {
+            "put": {
                 "security": [
                     {
                         "BearerAuth": []
                     }
                 ],
-                "description": "Mark a book of the Bible as read",
                 "consumes": [
                     "application/json"
                 ],
@@ -151,27 +196,48 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "read_history"
+                    "bookmarks"
                 ],
-                "summary": "Mark a whole book read",
+                "summary": "Rename or move a bookmark folder",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "ID of the book, can be the name of the book, abbreviation or the number (1-66)",
-                        "name": "bookid",
+                        "type": "integer",
+                        "description": "Folder ID",
+                        "name": "id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "description": "New name and position, an empty name keeps the name",
+                        "name": "folder",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.BookmarkFolderRequest"
+                        }
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.MarkBookReadResponse"
+                            "$ref": "#/definitions/models.BookmarkFolder"
                         }
                     },
-                    "401": {
-                        "description": "Unauthorized",
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
                         "schema": {
                             "$ref": "#/definitions/models.ErrorResponse"
                         }
@@ -184,22 +250,19 @@ const docTemplate = `{
                         "BearerAuth": []
                     }
                 ],
-                "description": "Mark a book of the Bible as unread",
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "Deletes the folder. Its bookmarks are kept outside any folder.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "read_history"
+                    "bookmarks"
                 ],
-                "summary": "Mark a whole book unread",
+                "summary": "Delete a bookmark folder",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "ID of the book, can be the name of the book, abbreviation or the number (1-66)",
-                        "name": "bookid",
+                        "type": "integer",
+                        "description": "Folder ID",
+                        "name": "id",
                         "in": "path",
                         "required": true
                     }
@@ -208,11 +271,11 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.MarkBookReadResponse"
+                            "$ref": "#/definitions/models.GenericMessage"
                         }
                     },
-                    "401": {
-                        "description": "Unauthorized",
+                    "404": {
+                        "description": "Not Found",
                         "schema": {
                             "$ref": "#/definitions/models.ErrorResponse"
                         }
@@ -220,14 +283,14 @@ const docTemplate = `{
                 }
             }
         },
-        "/markchapterasread": {
+        "/bookmark/reorder": {
             "post": {
                 "security": [
                     {
                         "BearerAuth": []
                     }
                 ],
-                "description": "Mark a chapter from the Bible as read",
+                "description": "Puts the bookmarks in the order of the list, ahead of the bookmarks left out of it. To reorder a folder, send the IDs of its bookmarks.",
                 "consumes": [
                     "application/json"
                 ],
@@ -235,17 +298,17 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "read_history"
+                    "bookmarks"
                 ],
-                "summary": "Mark a chapter as read",
+                "summary": "Reorder bookmarks",
                 "parameters": [
                     {
-                        "description": "Bible chapter to mark as read",
-                        "name": "chapter",
+                        "description": "Bookmark IDs in their new order",
+                        "name": "order",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/models.BibleChapter"
+                            "$ref": "#/definitions/models.ReorderRequest"
                         }
                     }
                 ],
@@ -253,24 +316,26 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.MarkChapterAsReadResponse"
+                            "$ref": "#/definitions/models.GenericMessage"
                         }
                     },
-                    "401": {
-                        "description": "Unauthorized",
+                    "400": {
+                        "description": "Bad Request",
                         "schema": {
                             "$ref": "#/definitions/models.ErrorResponse"
                         }
                     }
                 }
-            },
-            "delete": {
+            }
+        },
+        "/bookmark/{id}": {
+            "put": {
                 "security": [
                     {
                         "BearerAuth": []
                     }
                 ],
-                "description": "Mark a chapter from the Bible as unread",
+                "description": "Changes the title, colour, folder or end of the range of a bookmark",
                 "consumes": [
                     "application/json"
                 ],
@@ -278,17 +343,24 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "read_history"
+                    "bookmarks"
                 ],
-                "summary": "Mark a chapter as unread",
+                "summary": "Update a bookmark",
                 "parameters": [
                     {
-                        "description": "Bible chapter to mark as unread",
-                        "name": "chapter",
+                        "type": "integer",
+                        "description": "Bookmark ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Fields to change",
+                        "name": "bookmark",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/models.BibleChapter"
+                            "$ref": "#/definitions/models.UpdateBookmarkRequest"
                         }
                     }
                 ],
@@ -296,48 +368,60 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.MarkChapterAsReadResponse"
+                            "$ref": "#/definitions/models.Bookmark"
                         }
                     },
-                    "401": {
-                        "description": "Unauthorized",
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
                         "schema": {
                             "$ref": "#/definitions/models.ErrorResponse"
                         }
                     }
                 }
-            }
-        },
-        "/readbooksstatus": {
-            "get": {
+            },
+            "delete": {
                 "security": [
                     {
                         "BearerAuth": []
                     }
                 ],
-                "description": "Returns the read status for each book in the Bible (complete, partial and not_started).",
-                "consumes": [
-                    "application/json"
-                ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "read_history"
+                    "bookmarks"
+                ],
+                "summary": "Delete a bookmark",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Bookmark ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
                 ],
-                "summary": "Get read progress of all Bible books",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/models.ReadBook"
-                            }
+                            "$ref": "#/definitions/models.GenericMessage"
                         }
                     },
-                    "401": {
-                        "description": "Unauthorized",
+                    "404": {
+                        "description": "Not Found",
                         "schema": {
                             "$ref": "#/definitions/models.ErrorResponse"
                         }
@@ -345,14 +429,9 @@ const docTemplate = `{
                 }
             }
         },
-        "/readchaptersofbook/{bookid}": {
-            "get": {
-                "security": [
-                    {
-                        "BearerAuth": []
-                    }
-                ],
-                "description": "Returns the list of chapters marked as read for the specified book.",
+        "/cancelaccountdeletion": {
+            "post": {
+                "description": "Keeps an account that is scheduled for deletion, using the token of the link emailed when the deletion was requested. The user has to log in again afterwards.",
                 "consumes": [
                     "application/json"
                 ],
@@ -360,27 +439,29 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "read_history"
+                    "users"
                 ],
-                "summary": "Get read chapter numbers for a book",
+                "summary": "Cancel account deletion",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "ID of the book, can be the name of the book, abbreviation or the number (1-66)",
-                        "name": "bookid",
-                        "in": "path",
-                        "required": true
+                        "description": "Token of the cancellation link",
+                        "name": "token",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.EmailedTokenRequest"
+                        }
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.BookReadChaptersResponse"
+                            "$ref": "#/definitions/models.GenericMessage"
                         }
                     },
-                    "401": {
-                        "description": "Unauthorized",
+                    "400": {
+                        "description": "Bad Request",
                         "schema": {
                             "$ref": "#/definitions/models.ErrorResponse"
                         }
@@ -388,9 +469,9 @@ const docTemplate = `{
                 }
             }
         },
-        "/refresh": {
+        "/confirmemailchange": {
             "post": {
-                "description": "Validates the refresh token and returns a new access and refresh token pair.",
+                "description": "Switches the account to the new address of a pending email change, using the token of the link sent to that address. The address counts as verified.",
                 "consumes": [
                     "application/json"
                 ],
@@ -398,180 +479,4625 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "auth"
+                    "users"
                 ],
-                "summary": "Refresh JWT token",
+                "summary": "Confirm a new email",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Refresh token",
-                        "name": "Refresh",
-                        "in": "header",
-                        "required": true
+                        "description": "Token of the confirmation link",
+                        "name": "token",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.EmailedTokenRequest"
+                        }
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.LoginResponse"
+                            "$ref": "#/definitions/models.GenericMessage"
                         }
                     },
-                    "401": {
-                        "description": "Unauthorized",
+                    "400": {
+                        "description": "Bad Request",
                         "schema": {
                             "$ref": "#/definitions/models.ErrorResponse"
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "models.BibleChapter": {
+        },
+        "/cycles": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns every read-through of the Bible of the user, oldest first, with when it started, ended and was finished and how far it got",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "read_history"
+                ],
+                "summary": "List reading cycles",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.ReadingCycleSummary"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Ends the current read-through of the Bible and starts a new one with nothing read. The history of the ended cycle is kept and can still be queried with its ID.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "read_history"
+                ],
+                "summary": "Start a new reading cycle",
+                "parameters": [
+                    {
+                        "description": "Name of the cycle",
+                        "name": "cycle",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/models.StartCycleRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/models.ReadingCycle"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/cycles/{id}": {
+            "patch": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "read_history"
+                ],
+                "summary": "Rename a reading cycle",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Cycle ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "New name",
+                        "name": "cycle",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.StartCycleRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.ReadingCycle"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/highlights": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the highlights of the user, of a book if one is given. With a chapter, returns the highlights covering it and every highlighted verse of the chapter with its colour and style, ready for rendering.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "highlights"
+                ],
+                "summary": "Get highlights",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Book name or abbreviation like GEN",
+                        "name": "book",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Chapter of the book",
+                        "name": "chapter",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.HighlightsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Highlights a range of verses. Verses of the range that were highlighted already take the new colour and style; highlights reaching beyond the range keep their other verses.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "highlights"
+                ],
+                "summary": "Highlight verses",
+                "parameters": [
+                    {
+                        "description": "Range, colour and style",
+                        "name": "highlight",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.HighlightRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/models.Highlight"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/highlights/apply": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Highlights every range with the same colour and style in one transaction, like POST /highlights for each. The ranges must not overlap.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "highlights"
+                ],
+                "summary": "Highlight several ranges",
+                "parameters": [
+                    {
+                        "description": "Ranges, colour and style",
+                        "name": "highlights",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.BulkHighlightRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Highlight"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/highlights/clear": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Removes the highlighting of every verse in the ranges. Highlights reaching beyond a range keep their other verses. Colour and style are ignored.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "highlights"
+                ],
+                "summary": "Clear highlights of ranges",
+                "parameters": [
+                    {
+                        "description": "Ranges to clear",
+                        "name": "highlights",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.BulkHighlightRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.GenericMessage"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/highlights/{id}": {
+            "put": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Changes the colour or style of a highlight",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "highlights"
+                ],
+                "summary": "Change a highlight",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Highlight ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Colour and style",
+                        "name": "highlight",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.UpdateHighlightRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Highlight"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "highlights"
+                ],
+                "summary": "Delete a highlight",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Highlight ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.GenericMessage"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/import": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Imports bookmarks and notes exported from other Bible apps. Accepts CSV with a reference column (OSIS, USFM or \"Genesis 1:1\") or book, chapter and verse columns, the generic JSON schema {\"bookmarks\": [...], \"notes\": [...]}, and YouVersion style {\"moments\": [...]} exports. Entries that already exist are skipped, and the response reports what happened to every entry.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "bookmarks"
+                ],
+                "summary": "Import bookmarks and notes",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "Exported file",
+                        "name": "file",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "csv, json or youversion, guessed from the file when left out",
+                        "name": "format",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.ImportResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/login": {
+            "post": {
+                "description": "Authenticates a user with email and password and returns a JWT access token and a refresh token. Repeated failures lock the account and the client IP for a growing amount of time. If the user has two-factor authentication enabled, an MFA challenge token is returned instead, to be exchanged at /login/mfa.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Login a user",
+                "parameters": [
+                    {
+                        "description": "User login credentials",
+                        "name": "credentials",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.LoginRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.LoginResponse"
+                        }
+                    },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/models.MfaChallengeResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/login/magiclink": {
+            "post": {
+                "description": "Emails a single use link that logs the user in without a password. The response is the same whether or not the email belongs to an account.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Email a login link",
+                "parameters": [
+                    {
+                        "description": "Email of the account",
+                        "name": "email",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.MagicLinkRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.GenericMessage"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/login/magiclink/verify": {
+            "post": {
+                "description": "Exchanges the token of a magic link for a JWT access token and a refresh token, like /login. The link proves ownership of the email, so the email is marked verified.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Log in with an emailed link",
+                "parameters": [
+                    {
+                        "description": "Magic link token and login options",
+                        "name": "token",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.MagicLinkLoginRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.LoginResponse"
+                        }
+                    },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/models.MfaChallengeResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/login/mfa": {
+            "post": {
+                "description": "Exchanges the MFA challenge token returned by /login and a TOTP code (or an unused recovery code) for a JWT access token and a refresh token.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Complete a login with a second factor",
+                "parameters": [
+                    {
+                        "description": "Challenge token and code",
+                        "name": "challenge",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.MfaLoginRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.LoginResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/login/webauthn/begin": {
+            "post": {
+                "description": "Returns the options for navigator.credentials.get(). With an email or username of an account that has passkeys only those are allowed, otherwise the authenticator offers any passkey it holds for this site. The response doesn't reveal whether the account exists.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Start a passkey login",
+                "parameters": [
+                    {
+                        "description": "Optional account",
+                        "name": "account",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/models.WebAuthnLoginBeginRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.WebAuthnBeginResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/login/webauthn/finish": {
+            "post": {
+                "description": "Verifies the assertion returned by navigator.credentials.get() and returns a JWT access token and a refresh token, like /login.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Finish a passkey login",
+                "parameters": [
+                    {
+                        "description": "Challenge ID, credential and login options",
+                        "name": "credential",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.WebAuthnFinishRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.LoginResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/logout": {
+            "post": {
+                "description": "Logs out the current device by deleting the refresh tokens of its session and revoking its access tokens.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Logout user from current device",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Refresh token",
+                        "name": "Refresh",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.GenericMessage"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/logout/all": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Logs out the user from all devices by deleting all refresh tokens and revoking every access token issued so far.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Logout user from all devices",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bearer JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.GenericMessage"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/markbookasread/{bookid}": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Mark a book of the Bible as read",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "read_history"
+                ],
+                "summary": "Mark a whole book read",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ID of the book, can be the name of the book, abbreviation or the number (1-66)",
+                        "name": "bookid",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.MarkBookReadResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Mark a book of the Bible as unread",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "read_history"
+                ],
+                "summary": "Mark a whole book unread",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ID of the book, can be the name of the book, abbreviation or the number (1-66)",
+                        "name": "bookid",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.MarkBookReadResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/markchapterasread": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Mark a chapter from the Bible as read",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "read_history"
+                ],
+                "summary": "Mark a chapter as read",
+                "parameters": [
+                    {
+                        "description": "Bible chapter to mark as read",
+                        "name": "chapter",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.BibleChapter"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.MarkChapterAsReadResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Mark a chapter from the Bible as unread",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "read_history"
+                ],
+                "summary": "Mark a chapter as unread",
+                "parameters": [
+                    {
+                        "description": "Bible chapter to mark as unread",
+                        "name": "chapter",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.BibleChapter"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.MarkChapterAsReadResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/me": {
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Schedules the account and all its data for deletion after a grace period. Needs the password, or a session started in the last 10 minutes for accounts without one. Every device is logged out and a link to cancel the deletion is emailed.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Delete the account",
+                "parameters": [
+                    {
+                        "description": "Password of the account",
+                        "name": "password",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/models.PasswordRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/models.GenericMessage"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/me/export": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns a ZIP archive of everything stored about the user: profile and preferences, reading history, bookmarks, notes, parallel translations and active sessions, as JSON and CSV. Large accounts get 202 instead and a download link by email once the archive is ready. Until that link expires, asking again doesn't start another export.",
+                "produces": [
+                    "application/zip",
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Export personal data",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/models.GenericMessage"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/me/export/download": {
+            "get": {
+                "description": "Downloads an export prepared by /me/export, using the token of the emailed link. The token expires and stops working when the user logs out everywhere.",
+                "produces": [
+                    "application/zip",
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Download a data export",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Token of the download link",
+                        "name": "token",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/mfa": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns whether TOTP is enabled and how many unused recovery codes are left.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "mfa"
+                ],
+                "summary": "Two-factor authentication status",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.MfaStatusResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/mfa/recoverycodes": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Replaces all recovery codes with new ones. Requires a current TOTP code.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "mfa"
+                ],
+                "summary": "Regenerate recovery codes",
+                "parameters": [
+                    {
+                        "description": "Code from the authenticator app",
+                        "name": "code",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.TotpCodeRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.RecoveryCodesResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/mfa/totp": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Generates a new TOTP secret and returns it with an otpauth:// provisioning URI for a QR code. The secret is only used once it is confirmed with a code at /mfa/totp/confirm.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "mfa"
+                ],
+                "summary": "Start TOTP enrollment",
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/models.TotpEnrollResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Turns off two-factor authentication and deletes the recovery codes. Requires the current password.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "mfa"
+                ],
+                "summary": "Disable TOTP",
+                "parameters": [
+                    {
+                        "description": "Current password",
+                        "name": "password",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.PasswordRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.GenericMessage"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/mfa/totp/confirm": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Enables two-factor authentication once a code from the authenticator app is verified, and returns single use recovery codes. The recovery codes are shown only this once.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "mfa"
+                ],
+                "summary": "Confirm TOTP enrollment",
+                "parameters": [
+                    {
+                        "description": "Code from the authenticator app",
+                        "name": "code",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.TotpCodeRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.RecoveryCodesResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/note/search": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Full-text search over the notes of the user. q takes web search syntax: words, \"quoted phrases\", or and -excluded words; the results are then ranked by relevance and come with snippets of HTML, where the note is escaped and matches are in \u003cmark\u003e tags. Without q the notes are listed in Bible order. The filters combine: tags keeps the notes with all the given tags, book those of a book and from and to those between two references, like from=Matthew\u0026to=John 3 or from=GEN.1.1\u0026to=GEN.11.9.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "notes"
+                ],
+                "summary": "Search notes",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Search text",
+                        "name": "q",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma-separated tags",
+                        "name": "tags",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Book name or abbreviation",
+                        "name": "book",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "First reference of the range",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Last reference of the range",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page, from 1",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Results per page, 20 by default and 100 at most",
+                        "name": "page_size",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.NoteSearchResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/note/tags": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the tags the user gave notes, with how many notes have each",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "notes"
+                ],
+                "summary": "List note tags",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.NoteTagCount"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/note/{noteid}/tags": {
+            "put": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Replaces the tags of a note. Tags are lowercased; an empty list clears them.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "notes"
+                ],
+                "summary": "Set the tags of a note",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Note ID",
+                        "name": "noteid",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Tags of the note",
+                        "name": "tags",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.NoteTagsRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Note"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/oauth/authorize": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Validates an authorization request of a third-party app and returns what to show on the consent screen. The app must use PKCE with S256.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "oauth server"
+                ],
+                "summary": "Get consent screen data",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Client ID",
+                        "name": "client_id",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Redirect URI registered for the app",
+                        "name": "redirect_uri",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Must be code",
+                        "name": "response_type",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Space separated scopes",
+                        "name": "scope",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Opaque value returned to the app",
+                        "name": "state",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "PKCE code challenge",
+                        "name": "code_challenge",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Must be S256",
+                        "name": "code_challenge_method",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.OAuthConsentResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Records the user's decision on the consent screen and returns where to redirect the browser: back to the app with an authorization code, or with error=access_denied.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "oauth server"
+                ],
+                "summary": "Approve or deny a third-party app",
+                "parameters": [
+                    {
+                        "description": "Authorization request and decision",
+                        "name": "decision",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.OAuthAuthorizeRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.OAuthRedirectResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/oauth/clients": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the third-party apps registered by the user.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "oauth server"
+                ],
+                "summary": "List registered apps",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.OAuthClient"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Registers an app that can ask users for access to their data. Confidential apps get a client secret, which is only shown in this response.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "oauth server"
+                ],
+                "summary": "Register a third-party app",
+                "parameters": [
+                    {
+                        "description": "App name and redirect URIs",
+                        "name": "client",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.OAuthClientRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/models.OAuthClientResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/oauth/clients/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Deletes an app registered by the user and revokes every token issued to it.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "oauth server"
+                ],
+                "summary": "Delete a registered app",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "App ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.GenericMessage"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/oauth/identities": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the external accounts linked to the user.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "oauth"
+                ],
+                "summary": "List linked providers",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.OAuthIdentity"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/oauth/identities/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Removes a linked external account. The last way to log in can't be removed, set a password first.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "oauth"
+                ],
+                "summary": "Unlink a provider",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Identity ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.GenericMessage"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/oauth/identities/{provider}/begin": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Like /oauth/{provider}/begin, but the identity is linked to the logged in user. Send the result to /oauth/identities/{provider}/finish.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "oauth"
+                ],
+                "summary": "Start linking a provider",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Provider name",
+                        "name": "provider",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.OAuthBeginResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/oauth/identities/{provider}/finish": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Redeems the code from the provider and links the identity to the logged in user.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "oauth"
+                ],
+                "summary": "Finish linking a provider",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Provider name",
+                        "name": "provider",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Code and state",
+                        "name": "result",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.OAuthFinishRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/models.OAuthIdentity"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/oauth/introspect": {
+            "post": {
+                "description": "Tells the calling app whether one of its access or refresh tokens is still active, and what it grants (RFC 7662).",
+                "consumes": [
+                    "application/x-www-form-urlencoded"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "oauth server"
+                ],
+                "summary": "Introspect an app token",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Access or refresh token",
+                        "name": "token",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Client ID, unless sent with HTTP Basic",
+                        "name": "client_id",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Client secret of confidential apps",
+                        "name": "client_secret",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.OAuthIntrospectionResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.OAuthErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/oauth/providers": {
+            "get": {
+                "description": "Returns the names of the configured external login providers.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "oauth"
+                ],
+                "summary": "List social login providers",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/oauth/revoke": {
+            "post": {
+                "description": "Revokes an access or refresh token issued to the calling app, ending its session (RFC 7009). Unknown tokens are ignored.",
+                "consumes": [
+                    "application/x-www-form-urlencoded"
+                ],
+                "tags": [
+                    "oauth server"
+                ],
+                "summary": "Revoke an app token",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Access or refresh token",
+                        "name": "token",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Client ID, unless sent with HTTP Basic",
+                        "name": "client_id",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Client secret of confidential apps",
+                        "name": "client_secret",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.OAuthErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/oauth/token": {
+            "post": {
+                "description": "Exchanges an authorization code (with the PKCE verifier) or a refresh token for an access token and a new refresh token. Confidential apps authenticate with HTTP Basic or client_secret. Access tokens only work on routes covered by their scopes.",
+                "consumes": [
+                    "application/x-www-form-urlencoded"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "oauth server"
+                ],
+                "summary": "OAuth2 token endpoint",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "authorization_code or refresh_token",
+                        "name": "grant_type",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Client ID, unless sent with HTTP Basic",
+                        "name": "client_id",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Client secret of confidential apps",
+                        "name": "client_secret",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Authorization code",
+                        "name": "code",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Redirect URI used for the code",
+                        "name": "redirect_uri",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "PKCE code verifier",
+                        "name": "code_verifier",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Refresh token",
+                        "name": "refresh_token",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.OAuthTokenResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.OAuthErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.OAuthErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/oauth/{provider}/begin": {
+            "post": {
+                "description": "Returns the provider's authorization URL to redirect the user to. The provider redirects back with a code and the state, which are sent to /oauth/{provider}/finish.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "oauth"
+                ],
+                "summary": "Start a social login",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Provider name",
+                        "name": "provider",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.OAuthBeginResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/oauth/{provider}/finish": {
+            "post": {
+                "description": "Redeems the code from the provider and returns a JWT access token and a refresh token, like /login. An unknown identity is linked to the account with the same email if both the provider and the account verified it, or gets a new account with a generated username when no account has that email. The provider has to have verified the email.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "oauth"
+                ],
+                "summary": "Finish a social login",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Provider name",
+                        "name": "provider",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Code, state and login options",
+                        "name": "result",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.OAuthFinishRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.LoginResponse"
+                        }
+                    },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/models.MfaChallengeResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/plans": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the built-in reading plans and the plans the user made, with the start date of those the user follows",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "plans"
+                ],
+                "summary": "List reading plans",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.ReadingPlanSummary"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Creates a plan that spreads the given chapters over a number of days, in their order",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "plans"
+                ],
+                "summary": "Create a reading plan",
+                "parameters": [
+                    {
+                        "description": "Name, chapters and length of the plan",
+                        "name": "plan",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.CreatePlanRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/models.ReadingPlan"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/plans/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns a built-in plan or a plan of the user with the chapters of every day",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "plans"
+                ],
+                "summary": "Get a reading plan",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Plan ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.ReadingPlan"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Deletes a plan the user made. Built-in plans can't be deleted.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "plans"
+                ],
+                "summary": "Delete a reading plan",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Plan ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.GenericMessage"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/plans/{id}/enroll": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Starts following a plan on the given date, today in the time zone of the user's preferences when left out. Following a plan again moves its start date.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "plans"
+                ],
+                "summary": "Follow a reading plan",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Plan ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Start date",
+                        "name": "plan",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/models.EnrollPlanRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.PlanEnrollment"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "plans"
+                ],
+                "summary": "Stop following a reading plan",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Plan ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.GenericMessage"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/plans/{id}/today": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the chapters of the day in a plan the user follows, the unread chapters of earlier days to catch up on and whether the user is ahead of or behind schedule. Chapters count as read when they are in the reading history of the current reading cycle. The day is taken from the date parameter, so clients can pass their local date; it defaults to today in the time zone of the user's preferences, UTC when none is set.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "plans"
+                ],
+                "summary": "Today's reading of a plan",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Plan ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Local date of the user, like 2025-03-14",
+                        "name": "date",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.PlanTodayResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/readbooksstatus": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the read status for each book in the Bible (complete, partial and not_started) in the current reading cycle, or in the cycle given, with the chapters and verses read, the percentage read weighted by verses and the estimated minutes left.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "read_history"
+                ],
+                "summary": "Get read progress of all Bible books",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID of a reading cycle, the current one by default",
+                        "name": "cycle",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.ReadBook"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/readchaptersofbook/{bookid}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the list of chapters marked as read for the specified book in the current reading cycle, or in the cycle given.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "read_history"
+                ],
+                "summary": "Get read chapter numbers for a book",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ID of the book, can be the name of the book, abbreviation or the number (1-66)",
+                        "name": "bookid",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "ID of a reading cycle, the current one by default",
+                        "name": "cycle",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.BookReadChaptersResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/readhistory/sync": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Applies chapters marked read or unread while offline in one transaction and returns what changed since the previous sync. For every chapter the change made last, by the client timestamps, wins: an operation older than the latest change of its chapter is ignored. Timestamps in the future count as now. Send the returned cursor with the next sync; without a cursor, or after a new reading cycle was started, the whole history of the current cycle is returned with full set. An empty list of operations just fetches changes.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "read_history"
+                ],
+                "summary": "Sync the read history",
+                "parameters": [
+                    {
+                        "description": "Cursor and operations",
+                        "name": "sync",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.ReadSyncRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.ReadSyncResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/readprogress": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the progress of every book like /readbooksstatus, and of the Old Testament, the New Testament and the whole Bible, in the current reading cycle or in the cycle given. Percentages are weighted by verses, so reading Psalm 119 counts more than reading Psalm 117.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "read_history"
+                ],
+                "summary": "Get read progress of the Bible",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID of a reading cycle, the current one by default",
+                        "name": "cycle",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.ReadProgressResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/readstats": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the current and longest daily reading streak, chapter counts for today, this week and this month, and chapters read per day, week and month over the last days for charts and a calendar heatmap. Days are counted in the time zone of the tz parameter, else the one in the user preferences, else UTC.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "read_history"
+                ],
+                "summary": "Get reading streaks and activity",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "IANA time zone like Europe/Paris",
+                        "name": "tz",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of days in the series, 365 by default",
+                        "name": "days",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.ReadingStatsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/refresh": {
+            "post": {
+                "description": "Validates the refresh token and returns a new access and refresh token pair.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Refresh JWT token",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Refresh token",
+                        "name": "Refresh",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.LoginResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/sessions": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns every device the user is logged in on, with the session of the current access token marked as current.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sessions"
+                ],
+                "summary": "List active sessions",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Session"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/sessions/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Logs out a single device by deleting every refresh token of the session and revoking its access tokens.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sessions"
+                ],
+                "summary": "Revoke a session",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Session ID as returned by GET /sessions",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.GenericMessage"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/undoemailchange": {
+            "post": {
+                "description": "Cancels an email change with the token of the link sent to the old address. If the change was confirmed already, the old address is restored and every device is logged out.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Undo an email change",
+                "parameters": [
+                    {
+                        "description": "Token of the undo link",
+                        "name": "token",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.EmailedTokenRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.GenericMessage"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/webauthn/credentials": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the passkeys registered by the user.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webauthn"
+                ],
+                "summary": "List passkeys",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.WebAuthnCredential"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/webauthn/credentials/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Removes a passkey so it can no longer be used to log in.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webauthn"
+                ],
+                "summary": "Delete a passkey",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Passkey ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.GenericMessage"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/webauthn/register/begin": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the options for navigator.credentials.create(). Passkeys the user already has are excluded. Needs the password, or a session started in the last 10 minutes for accounts without one.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webauthn"
+                ],
+                "summary": "Start registering a passkey",
+                "parameters": [
+                    {
+                        "description": "Password of the account",
+                        "name": "password",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/models.PasswordRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.WebAuthnBeginResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/webauthn/register/finish": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Verifies the attestation returned by navigator.credentials.create() and stores the passkey. The account owner is emailed about it.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webauthn"
+                ],
+                "summary": "Finish registering a passkey",
+                "parameters": [
+                    {
+                        "description": "Challenge ID, credential and a name for the passkey",
+                        "name": "credential",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.WebAuthnFinishRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/models.WebAuthnCredential"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "models.BibleChapter": {
+            "type": "object",
+            "properties": {
+                "abbreviation": {
+                    "type": "string"
+                },
+                "book": {
+                    "type": "string"
+                },
+                "book_id": {
+                    "type": "integer"
+                },
+                "chapter": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.BookReadChaptersResponse": {
+            "type": "object",
+            "properties": {
+                "abbreviation": {
+                    "type": "string"
+                },
+                "book": {
+                    "type": "string"
+                },
+                "book_id": {
+                    "type": "integer"
+                },
+                "read_chapters": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                }
+            }
+        },
+        "models.Bookmark": {
+            "type": "object",
+            "properties": {
+                "book": {
+                    "type": "string"
+                },
+                "chapter_number": {
+                    "type": "integer"
+                },
+                "color": {
+                    "type": "string",
+                    "example": "#FFD54F"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "end_chapter": {
+                    "type": "integer"
+                },
+                "end_verse": {
+                    "type": "integer"
+                },
+                "folder_id": {
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "position": {
+                    "type": "integer"
+                },
+                "title": {
+                    "type": "string"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "integer"
+                },
+                "verse_number": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.BookmarkFolder": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "position": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.BookmarkFolderRequest": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "type": "string",
+                    "example": "Promises"
+                },
+                "position": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.BulkHighlightRequest": {
+            "type": "object",
+            "properties": {
+                "color": {
+                    "type": "string",
+                    "example": "#FFF176"
+                },
+                "ranges": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.VerseRange"
+                    }
+                },
+                "style": {
+                    "type": "string",
+                    "example": "highlight"
+                }
+            }
+        },
+        "models.CreatePlanRequest": {
+            "type": "object",
+            "properties": {
+                "chapters": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.PlanChapterRequest"
+                    }
+                },
+                "days": {
+                    "type": "integer",
+                    "example": 30
+                },
+                "description": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string",
+                    "example": "Gospels in a month"
+                }
+            }
+        },
+        "models.EmailedTokenRequest": {
+            "type": "object",
+            "properties": {
+                "token": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.EnrollPlanRequest": {
+            "type": "object",
+            "properties": {
+                "start_date": {
+                    "type": "string",
+                    "example": "2025-01-01"
+                }
+            }
+        },
+        "models.ErrorResponse": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.GenericMessage": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.Highlight": {
+            "type": "object",
+            "properties": {
+                "book_id": {
+                    "type": "integer"
+                },
+                "chapter": {
+                    "type": "integer"
+                },
+                "color": {
+                    "type": "string",
+                    "example": "#FFF176"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "end_chapter": {
+                    "type": "integer"
+                },
+                "end_verse": {
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "style": {
+                    "type": "string",
+                    "example": "highlight"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "verse": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.HighlightRequest": {
+            "type": "object",
+            "properties": {
+                "book": {
+                    "type": "string",
+                    "example": "GEN"
+                },
+                "chapter": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "color": {
+                    "type": "string",
+                    "example": "#FFF176"
+                },
+                "end_chapter": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "end_verse": {
+                    "type": "integer",
+                    "example": 3
+                },
+                "style": {
+                    "type": "string",
+                    "example": "highlight"
+                },
+                "verse": {
+                    "type": "integer",
+                    "example": 1
+                }
+            }
+        },
+        "models.HighlightsResponse": {
+            "type": "object",
+            "properties": {
+                "highlights": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Highlight"
+                    }
+                },
+                "verses": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.VerseHighlight"
+                    }
+                }
+            }
+        },
+        "models.ImportResponse": {
+            "type": "object",
+            "properties": {
+                "imported": {
+                    "type": "integer"
+                },
+                "rejected": {
+                    "type": "integer"
+                },
+                "rows": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.ImportRowResult"
+                    }
+                },
+                "skipped": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.ImportRowResult": {
+            "type": "object",
+            "properties": {
+                "kind": {
+                    "type": "string",
+                    "example": "note"
+                },
+                "location": {
+                    "type": "string",
+                    "example": "line 2"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "reference": {
+                    "type": "string",
+                    "example": "Gen.1.1"
+                },
+                "status": {
+                    "type": "string",
+                    "example": "imported"
+                }
+            }
+        },
+        "models.LoginRequest": {
+            "type": "object",
+            "properties": {
+                "device": {
+                    "type": "string"
+                },
+                "emailorusername": {
+                    "type": "string"
+                },
+                "location": {
+                    "type": "string"
+                },
+                "password": {
+                    "type": "string"
+                },
+                "remember": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "models.LoginResponse": {
+            "type": "object",
+            "properties": {
+                "access_token": {
+                    "type": "string"
+                },
+                "refresh_token": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.MagicLinkLoginRequest": {
+            "type": "object",
+            "properties": {
+                "device": {
+                    "type": "string"
+                },
+                "location": {
+                    "type": "string"
+                },
+                "remember": {
+                    "type": "boolean"
+                },
+                "token": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.MagicLinkRequest": {
+            "type": "object",
+            "properties": {
+                "email": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.MarkBookReadResponse": {
+            "type": "object",
+            "properties": {
+                "abbreviation": {
+                    "type": "string"
+                },
+                "book": {
+                    "type": "string"
+                },
+                "count": {
+                    "type": "integer"
+                },
+                "message": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.MarkChapterAsReadResponse": {
+            "type": "object",
+            "properties": {
+                "abbreviation": {
+                    "type": "string"
+                },
+                "book": {
+                    "type": "string"
+                },
+                "chapter": {
+                    "type": "integer"
+                },
+                "message": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.MfaChallengeResponse": {
+            "type": "object",
+            "properties": {
+                "expires_in": {
+                    "description": "seconds",
+                    "type": "integer"
+                },
+                "mfa_required": {
+                    "type": "boolean"
+                },
+                "mfa_token": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.MfaLoginRequest": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string"
+                },
+                "mfa_token": {
+                    "type": "string"
+                },
+                "recovery_code": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.MfaStatusResponse": {
+            "type": "object",
+            "properties": {
+                "recovery_codes_left": {
+                    "type": "integer"
+                },
+                "totp_enabled": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "models.Note": {
+            "type": "object",
+            "properties": {
+                "book": {
+                    "type": "string"
+                },
+                "chapter_number": {
+                    "type": "integer"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "note": {
+                    "type": "string"
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "integer"
+                },
+                "verse_number": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.NoteSearchResponse": {
+            "type": "object",
+            "properties": {
+                "page": {
+                    "type": "integer"
+                },
+                "page_size": {
+                    "type": "integer"
+                },
+                "results": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.NoteSearchResult"
+                    }
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.NoteSearchResult": {
+            "type": "object",
+            "properties": {
+                "book": {
+                    "type": "string"
+                },
+                "chapter_number": {
+                    "type": "integer"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "note": {
+                    "type": "string"
+                },
+                "rank": {
+                    "type": "number"
+                },
+                "snippet": {
+                    "type": "string"
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "integer"
+                },
+                "verse_number": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.NoteTagCount": {
+            "type": "object",
+            "properties": {
+                "count": {
+                    "type": "integer"
+                },
+                "tag": {
+                    "type": "string",
+                    "example": "prayer"
+                }
+            }
+        },
+        "models.NoteTagsRequest": {
+            "type": "object",
+            "properties": {
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "prayer",
+                        "promises"
+                    ]
+                }
+            }
+        },
+        "models.OAuthAuthorizeRequest": {
+            "type": "object",
+            "properties": {
+                "approve": {
+                    "type": "boolean"
+                },
+                "client_id": {
+                    "type": "string"
+                },
+                "code_challenge": {
+                    "type": "string"
+                },
+                "code_challenge_method": {
+                    "type": "string"
+                },
+                "redirect_uri": {
+                    "type": "string"
+                },
+                "scope": {
+                    "type": "string"
+                },
+                "state": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.OAuthBeginResponse": {
+            "type": "object",
+            "properties": {
+                "authorization_url": {
+                    "type": "string"
+                },
+                "state": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.OAuthClient": {
+            "type": "object",
+            "properties": {
+                "client_id": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "redirect_uris": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "models.OAuthClientRequest": {
+            "type": "object",
+            "properties": {
+                "confidential": {
+                    "description": "Server side apps that can keep a secret",
+                    "type": "boolean"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "redirect_uris": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "models.OAuthClientResponse": {
+            "type": "object",
+            "properties": {
+                "client_id": {
+                    "type": "string"
+                },
+                "client_secret": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "redirect_uris": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "models.OAuthConsentResponse": {
+            "type": "object",
+            "properties": {
+                "client_id": {
+                    "type": "string"
+                },
+                "client_name": {
+                    "type": "string"
+                },
+                "redirect_uri": {
+                    "type": "string"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.OAuthScope"
+                    }
+                },
+                "state": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.OAuthErrorResponse": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "error_description": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.OAuthFinishRequest": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string"
+                },
+                "device": {
+                    "type": "string"
+                },
+                "location": {
+                    "type": "string"
+                },
+                "remember": {
+                    "type": "boolean"
+                },
+                "state": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.OAuthIdentity": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "email": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "last_login_at": {
+                    "type": "string"
+                },
+                "provider": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.OAuthIntrospectionResponse": {
+            "type": "object",
+            "properties": {
+                "active": {
+                    "type": "boolean"
+                },
+                "client_id": {
+                    "type": "string"
+                },
+                "exp": {
+                    "type": "integer"
+                },
+                "scope": {
+                    "type": "string"
+                },
+                "sub": {
+                    "type": "string"
+                },
+                "token_type": {
+                    "type": "string"
+                },
+                "username": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.OAuthRedirectResponse": {
+            "type": "object",
+            "properties": {
+                "redirect_to": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.OAuthScope": {
+            "type": "object",
+            "properties": {
+                "description": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.OAuthTokenResponse": {
+            "type": "object",
+            "properties": {
+                "access_token": {
+                    "type": "string"
+                },
+                "expires_in": {
+                    "type": "integer"
+                },
+                "refresh_token": {
+                    "type": "string"
+                },
+                "scope": {
+                    "type": "string"
+                },
+                "token_type": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.PasswordRequest": {
+            "type": "object",
+            "properties": {
+                "password": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.PlanChapter": {
+            "type": "object",
+            "properties": {
+                "book_id": {
+                    "type": "integer"
+                },
+                "chapter": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.PlanChapterRequest": {
+            "type": "object",
+            "properties": {
+                "book": {
+                    "type": "string",
+                    "example": "Matthew"
+                },
+                "chapter": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.PlanChapterStatus": {
+            "type": "object",
+            "properties": {
+                "abbreviation": {
+                    "type": "string"
+                },
+                "book": {
+                    "type": "string"
+                },
+                "book_id": {
+                    "type": "integer"
+                },
+                "chapter": {
+                    "type": "integer"
+                },
+                "read": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "models.PlanEnrollment": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "plan_id": {
+                    "type": "integer"
+                },
+                "start_date": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.PlanTodayResponse": {
+            "type": "object",
+            "properties": {
+                "catch_up": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.PlanChapterStatus"
+                    }
+                },
+                "chapters_expected": {
+                    "type": "integer"
+                },
+                "chapters_read": {
+                    "type": "integer"
+                },
+                "chapters_total": {
+                    "type": "integer"
+                },
+                "date": {
+                    "type": "string",
+                    "example": "2025-03-14"
+                },
+                "day": {
+                    "type": "integer"
+                },
+                "plan_id": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "string",
+                    "example": "behind"
+                },
+                "today": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.PlanChapterStatus"
+                    }
+                },
+                "total_days": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.ReadBook": {
+            "type": "object",
+            "properties": {
+                "abbreviation": {
+                    "type": "string"
+                },
+                "book": {
+                    "type": "string"
+                },
+                "chapters": {
+                    "type": "integer"
+                },
+                "chapters_read": {
+                    "type": "integer"
+                },
+                "percent": {
+                    "type": "number",
+                    "example": 42.5
+                },
+                "remaining_minutes": {
+                    "type": "integer"
+                },
+                "status": {
+                    "$ref": "#/definitions/models.StatusType"
+                },
+                "verses": {
+                    "type": "integer"
+                },
+                "verses_read": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.ReadProgress": {
+            "type": "object",
+            "properties": {
+                "chapters": {
+                    "type": "integer"
+                },
+                "chapters_read": {
+                    "type": "integer"
+                },
+                "percent": {
+                    "type": "number",
+                    "example": 42.5
+                },
+                "remaining_minutes": {
+                    "type": "integer"
+                },
+                "verses": {
+                    "type": "integer"
+                },
+                "verses_read": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.ReadProgressResponse": {
+            "type": "object",
+            "properties": {
+                "bible": {
+                    "$ref": "#/definitions/models.ReadProgress"
+                },
+                "books": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.ReadBook"
+                    }
+                },
+                "new_testament": {
+                    "$ref": "#/definitions/models.ReadProgress"
+                },
+                "old_testament": {
+                    "$ref": "#/definitions/models.ReadProgress"
+                }
+            }
+        },
+        "models.ReadSyncChange": {
+            "type": "object",
+            "properties": {
+                "book": {
+                    "type": "string"
+                },
+                "book_id": {
+                    "type": "integer"
+                },
+                "changed_at": {
+                    "type": "string"
+                },
+                "chapter": {
+                    "type": "integer"
+                },
+                "read": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "models.ReadSyncOperation": {
+            "type": "object",
+            "properties": {
+                "at": {
+                    "type": "string"
+                },
+                "book_id": {
+                    "type": "integer",
+                    "example": 43
+                },
+                "chapter": {
+                    "type": "integer",
+                    "example": 3
+                },
+                "read": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "models.ReadSyncRequest": {
+            "type": "object",
+            "properties": {
+                "cursor": {
+                    "type": "string",
+                    "example": "12.3480"
+                },
+                "operations": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.ReadSyncOperation"
+                    }
+                }
+            }
+        },
+        "models.ReadSyncResponse": {
+            "type": "object",
+            "properties": {
+                "applied": {
+                    "type": "integer"
+                },
+                "changes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.ReadSyncChange"
+                    }
+                },
+                "cursor": {
+                    "type": "string",
+                    "example": "12.3512"
+                },
+                "cycle_id": {
+                    "type": "integer"
+                },
+                "full": {
+                    "type": "boolean"
+                },
+                "ignored": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.ReadingCount": {
+            "type": "object",
+            "properties": {
+                "count": {
+                    "type": "integer"
+                },
+                "date": {
+                    "type": "string",
+                    "example": "2025-03-14"
+                }
+            }
+        },
+        "models.ReadingCycle": {
             "type": "object",
             "properties": {
-                "abbreviation": {
+                "ended_at": {
                     "type": "string"
                 },
-                "book": {
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
                     "type": "string"
                 },
-                "book_id": {
+                "started_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.ReadingCycleSummary": {
+            "type": "object",
+            "properties": {
+                "chapters": {
                     "type": "integer"
                 },
-                "chapter": {
+                "chapters_read": {
+                    "type": "integer"
+                },
+                "current": {
+                    "type": "boolean"
+                },
+                "ended_at": {
+                    "type": "string"
+                },
+                "finished_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string",
+                    "example": "Read-through 2"
+                },
+                "percent": {
+                    "type": "number",
+                    "example": 42.5
+                },
+                "remaining_minutes": {
+                    "type": "integer"
+                },
+                "started_at": {
+                    "type": "string"
+                },
+                "verses": {
+                    "type": "integer"
+                },
+                "verses_read": {
                     "type": "integer"
                 }
             }
         },
-        "models.BookReadChaptersResponse": {
+        "models.ReadingPlan": {
             "type": "object",
             "properties": {
-                "abbreviation": {
+                "created_at": {
                     "type": "string"
                 },
-                "book": {
+                "days": {
+                    "type": "array",
+                    "items": {
+                        "type": "array",
+                        "items": {
+                            "$ref": "#/definitions/models.PlanChapter"
+                        }
+                    }
+                },
+                "description": {
                     "type": "string"
                 },
-                "book_id": {
+                "id": {
                     "type": "integer"
                 },
-                "read_chapters": {
+                "name": {
+                    "type": "string"
+                },
+                "slug": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.ReadingPlanSummary": {
+            "type": "object",
+            "properties": {
+                "built_in": {
+                    "type": "boolean"
+                },
+                "chapters": {
+                    "type": "integer"
+                },
+                "days": {
+                    "type": "integer"
+                },
+                "description": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "slug": {
+                    "type": "string"
+                },
+                "start_date": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.ReadingStatsResponse": {
+            "type": "object",
+            "properties": {
+                "chapters_read": {
+                    "type": "integer"
+                },
+                "chapters_this_month": {
+                    "type": "integer"
+                },
+                "chapters_this_week": {
+                    "type": "integer"
+                },
+                "chapters_today": {
+                    "type": "integer"
+                },
+                "current_streak": {
+                    "type": "integer"
+                },
+                "daily": {
                     "type": "array",
                     "items": {
-                        "type": "integer"
+                        "$ref": "#/definitions/models.ReadingCount"
+                    }
+                },
+                "days_read": {
+                    "type": "integer"
+                },
+                "from": {
+                    "type": "string",
+                    "example": "2024-03-15"
+                },
+                "longest_streak": {
+                    "type": "integer"
+                },
+                "longest_streak_start": {
+                    "type": "string",
+                    "example": "2025-01-01"
+                },
+                "monthly": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.ReadingCount"
+                    }
+                },
+                "timezone": {
+                    "type": "string",
+                    "example": "Europe/Paris"
+                },
+                "to": {
+                    "type": "string",
+                    "example": "2025-03-14"
+                },
+                "today": {
+                    "type": "string",
+                    "example": "2025-03-14"
+                },
+                "weekly": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.ReadingCount"
                     }
                 }
             }
         },
-        "models.ErrorResponse": {
+        "models.RecoveryCodesResponse": {
             "type": "object",
             "properties": {
-                "error": {
+                "message": {
                     "type": "string"
+                },
+                "recovery_codes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
                 }
             }
         },
-        "models.GenericMessage": {
+        "models.ReorderRequest": {
             "type": "object",
             "properties": {
-                "message": {
-                    "type": "string"
+                "ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
                 }
             }
         },
-        "models.LoginRequest": {
+        "models.Session": {
             "type": "object",
             "properties": {
+                "client_id": {
+                    "description": "Set for third-party apps",
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "current": {
+                    "type": "boolean"
+                },
                 "device": {
                     "type": "string"
                 },
-                "emailorusername": {
+                "expires_at": {
                     "type": "string"
                 },
-                "location": {
+                "id": {
                     "type": "string"
                 },
-                "password": {
+                "last_refreshed_at": {
+                    "type": "string"
+                },
+                "location": {
                     "type": "string"
                 },
                 "remember": {
                     "type": "boolean"
+                },
+                "scope": {
+                    "type": "string"
                 }
             }
         },
-        "models.LoginResponse": {
+        "models.StartCycleRequest": {
             "type": "object",
             "properties": {
-                "access_token": {
+                "name": {
+                    "type": "string",
+                    "example": "2025 with the family"
+                }
+            }
+        },
+        "models.StatusType": {
+            "type": "string",
+            "enum": [
+                "complete",
+                "partial",
+                "not_started"
+            ],
+            "x-enum-varnames": [
+                "StatusComplete",
+                "StatusPartial",
+                "StatusNotStarted"
+            ]
+        },
+        "models.TotpCodeRequest": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.TotpEnrollResponse": {
+            "type": "object",
+            "properties": {
+                "provisioning_uri": {
+                    "description": "Encode as a QR code for authenticator apps",
                     "type": "string"
                 },
-                "refresh_token": {
+                "secret": {
                     "type": "string"
                 }
             }
         },
-        "models.MarkBookReadResponse": {
+        "models.UpdateBookmarkRequest": {
             "type": "object",
             "properties": {
-                "abbreviation": {
+                "color": {
+                    "type": "string",
+                    "example": "#FFD54F"
+                },
+                "end_chapter": {
+                    "type": "integer",
+                    "example": 5
+                },
+                "end_verse": {
+                    "type": "integer",
+                    "example": 12
+                },
+                "folder_id": {
+                    "type": "integer"
+                },
+                "title": {
+                    "type": "string",
+                    "example": "The Beatitudes"
+                }
+            }
+        },
+        "models.UpdateHighlightRequest": {
+            "type": "object",
+            "properties": {
+                "color": {
+                    "type": "string",
+                    "example": "#FFF176"
+                },
+                "style": {
+                    "type": "string",
+                    "example": "underline"
+                }
+            }
+        },
+        "models.VerseHighlight": {
+            "type": "object",
+            "properties": {
+                "color": {
+                    "type": "string"
+                },
+                "highlight_id": {
+                    "type": "integer"
+                },
+                "style": {
                     "type": "string"
                 },
+                "verse": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.VerseRange": {
+            "type": "object",
+            "properties": {
                 "book": {
+                    "type": "string",
+                    "example": "GEN"
+                },
+                "chapter": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "end_chapter": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "end_verse": {
+                    "type": "integer",
+                    "example": 3
+                },
+                "verse": {
+                    "type": "integer",
+                    "example": 1
+                }
+            }
+        },
+        "models.WebAuthnBeginResponse": {
+            "type": "object",
+            "properties": {
+                "challenge_id": {
                     "type": "string"
                 },
-                "count": {
+                "options": {}
+            }
+        },
+        "models.WebAuthnCredential": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
                     "type": "integer"
                 },
-                "message": {
+                "last_used_at": {
+                    "type": "string"
+                },
+                "name": {
                     "type": "string"
                 }
             }
         },
-        "models.MarkChapterAsReadResponse": {
+        "models.WebAuthnFinishRequest": {
             "type": "object",
             "properties": {
-                "abbreviation": {
+                "challenge_id": {
                     "type": "string"
                 },
-                "book": {
+                "credential": {
+                    "type": "object"
+                },
+                "device": {
                     "type": "string"
                 },
-                "chapter": {
-                    "type": "integer"
+                "location": {
+                    "type": "string"
                 },
-                "message": {
+                "name": {
                     "type": "string"
+                },
+                "remember": {
+                    "type": "boolean"
                 }
             }
         },
-        "models.ReadBook": {
+        "models.WebAuthnLoginBeginRequest": {
             "type": "object",
             "properties": {
-                "abbreviation": {
+                "emailorusername": {
+                    "description": "Leave empty to let the authenticator pick a passkey",
+                    "type": "string"
+                }
+            }
+        },
+        "utils.JWK": {
+            "type": "object",
+            "properties": {
+                "alg": {
                     "type": "string"
                 },
-                "book": {
+                "crv": {
                     "type": "string"
                 },
-                "status": {
-                    "$ref": "#/definitions/models.StatusType"
+                "e": {
+                    "type": "string"
+                },
+                "kid": {
+                    "type": "string"
+                },
+                "kty": {
+                    "type": "string"
+                },
+                "n": {
+                    "type": "string"
+                },
+                "use": {
+                    "type": "string"
+                },
+                "x": {
+                    "type": "string"
                 }
             }
         },
-        "models.StatusType": {
-            "type": "string",
-            "enum": [
-                "complete",
-                "partial",
-                "not_started"
-            ],
-            "x-enum-varnames": [
-                "StatusComplete",
-                "StatusPartial",
-                "StatusNotStarted"
-            ]
+        "utils.JWKS": {
+            "type": "object",
+            "properties": {
+                "keys": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/utils.JWK"
+                    }
+                }
+            }
         }
     },
     "securityDefinitions": {